@@ -0,0 +1,306 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"hls_downloader/downloader"
+)
+
+// config holds the settings shared by every invocation. They are read, in
+// increasing order of precedence, from the defaults, the config file, the
+// HLSDL_* environment variables and the command line flags.
+type config struct {
+	Workers   int               `yaml:"workers"`
+	Headers   map[string]string `yaml:"headers"`
+	Proxy     string            `yaml:"proxy"`
+	Output    outputConfig      `yaml:"output"`
+	Variant   stringList        `yaml:"variant"` // rules tried in order, see downloader.VariantRules, or "prompt"
+	Retry     retryConfig       `yaml:"retry"`
+	Mirrors   stringList        `yaml:"mirrors"`
+	Progress  string            `yaml:"progress"`
+	Overwrite bool              `yaml:"overwrite"`
+	Verbose   bool              `yaml:"verbose"`
+}
+
+type outputConfig struct {
+	Dir      string `yaml:"dir"`      // directory of the outputs without -o
+	Template string `yaml:"template"` // file name template of the outputs without -o
+}
+
+type retryConfig struct {
+	MaxRetries      int           `yaml:"max_retries"` // 0 doesn't retry, negative retries until MaxElapsed
+	InitialInterval time.Duration `yaml:"initial_interval"`
+	MaxInterval     time.Duration `yaml:"max_interval"`
+	MaxElapsed      time.Duration `yaml:"max_elapsed"`
+}
+
+// variantPrompt is the variant rule asking which variant to download.
+const variantPrompt = "prompt"
+
+func defaultConfig() *config {
+	return &config{
+		Output:   outputConfig{Template: "{date}.mp4"},
+		Retry:    retryConfig{MaxRetries: -1},
+		Progress: progressBar,
+	}
+}
+
+// stringList is a YAML list of strings that may be written as a single
+// string.
+type stringList []string
+
+func (l *stringList) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*l = stringList{node.Value}
+		return nil
+	}
+	var list []string
+	if err := node.Decode(&list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// configPath returns the config file to read: the -config flag, then
+// HLSDL_CONFIG, then ~/.config/hls_downloader/config.yaml. Only an
+// explicit file has to exist.
+func configPath(flagValue string) (path string, explicit bool) {
+	if flagValue != "" {
+		return flagValue, true
+	}
+	if env := os.Getenv("HLSDL_CONFIG"); env != "" {
+		return env, true
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", false
+	}
+	return filepath.Join(dir, "hls_downloader", "config.yaml"), false
+}
+
+// loadConfig reads the config file and the environment on top of the
+// defaults.
+func loadConfig(flagValue string) (*config, error) {
+	cfg := defaultConfig()
+	path, explicit := configPath(flagValue)
+	if path != "" {
+		f, err := os.Open(path)
+		switch {
+		case errors.Is(err, os.ErrNotExist) && !explicit:
+		case err != nil:
+			return nil, err
+		default:
+			defer f.Close()
+			dec := yaml.NewDecoder(f)
+			dec.KnownFields(true)
+			if err := dec.Decode(cfg); err != nil && err != io.EOF {
+				return nil, fmt.Errorf("config %s: %w", path, err)
+			}
+		}
+	}
+	if err := cfg.applyEnv(os.Environ()); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyEnv overrides the config with the HLSDL_* variables of env:
+//
+//	HLSDL_WORKERS, HLSDL_PROXY, HLSDL_OUTPUT_DIR, HLSDL_OUTPUT_TEMPLATE,
+//	HLSDL_VARIANT and HLSDL_MIRRORS (comma separated), HLSDL_PROGRESS,
+//	HLSDL_OVERWRITE, HLSDL_VERBOSE, HLSDL_RETRY_MAX_RETRIES,
+//	HLSDL_RETRY_INITIAL_INTERVAL, HLSDL_RETRY_MAX_INTERVAL,
+//	HLSDL_RETRY_MAX_ELAPSED and HLSDL_HEADER_<NAME>, the underscores of
+//	the name standing for dashes (HLSDL_HEADER_USER_AGENT).
+//
+// Other HLSDL_* variables are ignored with a warning, so a variable of a
+// later version doesn't break the commands.
+func (cfg *config) applyEnv(env []string) error {
+	for _, kv := range env {
+		key, value, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(key, "HLSDL_") {
+			continue
+		}
+		var err error
+		switch name := strings.TrimPrefix(key, "HLSDL_"); name {
+		case "CONFIG":
+		case "WORKERS":
+			cfg.Workers, err = strconv.Atoi(value)
+		case "PROXY":
+			cfg.Proxy = value
+		case "OUTPUT_DIR":
+			cfg.Output.Dir = value
+		case "OUTPUT_TEMPLATE":
+			cfg.Output.Template = value
+		case "VARIANT":
+			cfg.Variant = splitList(value)
+		case "MIRRORS":
+			cfg.Mirrors = splitList(value)
+		case "PROGRESS":
+			cfg.Progress = value
+		case "OVERWRITE":
+			cfg.Overwrite, err = strconv.ParseBool(value)
+		case "VERBOSE":
+			cfg.Verbose, err = strconv.ParseBool(value)
+		case "RETRY_MAX_RETRIES":
+			cfg.Retry.MaxRetries, err = strconv.Atoi(value)
+		case "RETRY_INITIAL_INTERVAL":
+			cfg.Retry.InitialInterval, err = time.ParseDuration(value)
+		case "RETRY_MAX_INTERVAL":
+			cfg.Retry.MaxInterval, err = time.ParseDuration(value)
+		case "RETRY_MAX_ELAPSED":
+			cfg.Retry.MaxElapsed, err = time.ParseDuration(value)
+		default:
+			header, ok := strings.CutPrefix(name, "HEADER_")
+			if !ok {
+				log.Printf("Ignoring unknown environment variable %s\n", key)
+				continue
+			}
+			if cfg.Headers == nil {
+				cfg.Headers = make(map[string]string)
+			}
+			cfg.Headers[strings.ReplaceAll(header, "_", "-")] = value
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+func splitList(value string) []string {
+	var list []string
+	for _, s := range strings.Split(value, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// options returns the downloader options of the config.
+func (cfg *config) options() (downloader.Options, error) {
+	opts := downloader.Options{
+		Verbose:   cfg.Verbose,
+		Workers:   cfg.Workers,
+		Mirrors:   cfg.Mirrors,
+		Overwrite: cfg.Overwrite,
+		Retry: downloader.RetryPolicy{
+			MaxRetries:      retryPolicyMax(cfg.Retry.MaxRetries),
+			InitialInterval: cfg.Retry.InitialInterval,
+			MaxInterval:     cfg.Retry.MaxInterval,
+			MaxElapsed:      cfg.Retry.MaxElapsed,
+		},
+	}
+	if len(cfg.Headers) > 0 {
+		opts.Headers = make(http.Header)
+		for name, value := range cfg.Headers {
+			opts.Headers.Set(name, value)
+		}
+	}
+	if cfg.Proxy != "" {
+		proxy, err := url.Parse(cfg.Proxy)
+		if err != nil {
+			return opts, fmt.Errorf("invalid proxy: %w", err)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = http.ProxyURL(proxy)
+		opts.HTTPClient = &http.Client{Transport: transport}
+	}
+	if len(cfg.Variant) == 1 && cfg.Variant[0] == variantPrompt {
+		opts.SelectVariant = promptVariant
+	} else if len(cfg.Variant) > 0 {
+		selectVariant, err := downloader.VariantRules(cfg.Variant)
+		if err != nil {
+			return opts, err
+		}
+		opts.SelectVariant = selectVariant
+	}
+	return opts, nil
+}
+
+// retryPolicyMax converts the max_retries of the config, where 0 doesn't
+// retry, to the MaxRetries of downloader.RetryPolicy, where 0 is the
+// default of retrying until the timeout.
+func retryPolicyMax(maxRetries int) int {
+	switch {
+	case maxRetries == 0:
+		return -1
+	case maxRetries < 0:
+		return 0
+	}
+	return maxRetries
+}
+
+// outputPath returns the output of a download without -o, a template
+// expanded by the downloader (see downloader.ExpandTemplate).
+func (cfg *config) outputPath() string {
+	return filepath.Join(expandHome(cfg.Output.Dir), cfg.Output.Template)
+}
+
+// expandHome replaces a leading ~ of path with the home directory.
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
+
+// clientFlags are the flags of the commands fetching playlists, on top of
+// the config.
+type clientFlags struct {
+	config  string
+	headers headerList
+	proxy   string
+	verbose bool
+}
+
+func (c *clientFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.config, "config", "", "Config file (default: $HLSDL_CONFIG or ~/.config/hls_downloader/config.yaml)")
+	fs.Var(&c.headers, "header", "Request header as 'Name: value', may be repeated")
+	fs.StringVar(&c.proxy, "proxy", "", "Proxy URL of the requests")
+	fs.BoolVar(&c.verbose, "v", false, "Verbose mode")
+}
+
+// load returns the config with the flags of fs given on the command line
+// applied.
+func (c *clientFlags) load(fs *flag.FlagSet) (*config, error) {
+	cfg, err := loadConfig(c.config)
+	if err != nil {
+		return nil, err
+	}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "header":
+			if cfg.Headers == nil {
+				cfg.Headers = make(map[string]string)
+			}
+			for name, value := range c.headers {
+				cfg.Headers[name] = value
+			}
+		case "proxy":
+			cfg.Proxy = c.proxy
+		case "v":
+			cfg.Verbose = c.verbose
+		}
+	})
+	return cfg, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestApplyEnv(t *testing.T) {
+	for _, tc := range []struct {
+		env  []string
+		want func(cfg *config)
+		err  bool
+	}{
+		{env: []string{"HLSDL_WORKERS=8"}, want: func(cfg *config) { cfg.Workers = 8 }},
+		{env: []string{"HLSDL_PROXY=http://proxy:3128"}, want: func(cfg *config) { cfg.Proxy = "http://proxy:3128" }},
+		{env: []string{"HLSDL_OUTPUT_DIR=~/videos", "HLSDL_OUTPUT_TEMPLATE={title}.mp4"}, want: func(cfg *config) {
+			cfg.Output = outputConfig{Dir: "~/videos", Template: "{title}.mp4"}
+		}},
+		{env: []string{"HLSDL_VARIANT=720p, lowest"}, want: func(cfg *config) { cfg.Variant = stringList{"720p", "lowest"} }},
+		{env: []string{"HLSDL_MIRRORS=a.example.com,b.example.com"}, want: func(cfg *config) {
+			cfg.Mirrors = stringList{"a.example.com", "b.example.com"}
+		}},
+		{env: []string{"HLSDL_PROGRESS=json", "HLSDL_OVERWRITE=true", "HLSDL_VERBOSE=1"}, want: func(cfg *config) {
+			cfg.Progress, cfg.Overwrite, cfg.Verbose = "json", true, true
+		}},
+		{env: []string{"HLSDL_RETRY_MAX_RETRIES=3", "HLSDL_RETRY_INITIAL_INTERVAL=1s", "HLSDL_RETRY_MAX_INTERVAL=10s", "HLSDL_RETRY_MAX_ELAPSED=1m"}, want: func(cfg *config) {
+			cfg.Retry = retryConfig{MaxRetries: 3, InitialInterval: time.Second, MaxInterval: 10 * time.Second, MaxElapsed: time.Minute}
+		}},
+		{env: []string{"HLSDL_HEADER_USER_AGENT=test", "HLSDL_HEADER_AUTHORIZATION=Bearer x=y"}, want: func(cfg *config) {
+			cfg.Headers = map[string]string{"USER-AGENT": "test", "AUTHORIZATION": "Bearer x=y"}
+		}},
+		{env: []string{"HLSDL_RETRY_MAX_RETRIES=0"}, want: func(cfg *config) { cfg.Retry.MaxRetries = 0 }},
+		// the other variables and HLSDL_CONFIG, read by configPath, are ignored
+		{env: []string{"HOME=/root", "HLSDL_CONFIG=/etc/hlsdl.yaml", "HLSDLX=1"}, want: func(cfg *config) {}},
+		// like unknown ones, with a warning
+		{env: []string{"HLSDL_WORKER=8", "HLSDL_FUTURE_SETTING=on"}, want: func(cfg *config) {}},
+		{env: []string{"HLSDL_WORKERS=many"}, err: true},
+		{env: []string{"HLSDL_OVERWRITE=maybe"}, err: true},
+		{env: []string{"HLSDL_RETRY_MAX_ELAPSED=10"}, err: true},
+	} {
+		cfg := defaultConfig()
+		err := cfg.applyEnv(tc.env)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%q: got no error", tc.env)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", tc.env, err)
+			continue
+		}
+		want := defaultConfig()
+		tc.want(want)
+		if !reflect.DeepEqual(cfg, want) {
+			t.Errorf("%q: got %+v, want %+v", tc.env, cfg, want)
+		}
+	}
+}
+
+func TestRetryOptions(t *testing.T) {
+	for maxRetries, want := range map[int]int{
+		-1: 0,  // retries until the timeout, the default of RetryPolicy
+		0:  -1, // doesn't retry
+		3:  3,
+	} {
+		cfg := defaultConfig()
+		cfg.Retry.MaxRetries = maxRetries
+		opts, err := cfg.options()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opts.Retry.MaxRetries != want {
+			t.Errorf("max_retries %d: got MaxRetries %d, want %d", maxRetries, opts.Retry.MaxRetries, want)
+		}
+	}
+	if cfg := defaultConfig(); cfg.Retry.MaxRetries >= 0 {
+		t.Errorf("retries are bounded by default: %d", cfg.Retry.MaxRetries)
+	}
+}
+
+func TestConfigPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(file, []byte(`workers: 2
+proxy: http://file:3128
+verbose: true
+headers:
+  Referer: https://file.example.com
+output:
+  dir: /videos
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("HLSDL_PROXY", "http://env:3128")
+	t.Setenv("HLSDL_OUTPUT_TEMPLATE", "{name}.mp4")
+
+	// the defaults, then the file, then the environment
+	cfg, err := loadConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Workers != 2 || cfg.Proxy != "http://env:3128" || !cfg.Verbose || cfg.Progress != progressBar {
+		t.Errorf("got config %+v", cfg)
+	}
+	if got := cfg.outputPath(); got != filepath.Join("/videos", "{name}.mp4") {
+		t.Errorf("got output %s", got)
+	}
+
+	// then the flags given on the command line
+	var c clientFlags
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	c.register(fs)
+	if err := fs.Parse([]string{"-config", file, "-proxy", "http://flag:3128", "-header", "Referer: https://flag.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = c.load(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Proxy != "http://flag:3128" || cfg.Headers["Referer"] != "https://flag.example.com" {
+		t.Errorf("flags not applied: %+v", cfg)
+	}
+	// a flag left out doesn't reset the config
+	if !cfg.Verbose || cfg.Workers != 2 {
+		t.Errorf("config reset by flags: %+v", cfg)
+	}
+}
+
+func TestConfigPath(t *testing.T) {
+	t.Setenv("HLSDL_CONFIG", "/etc/hlsdl.yaml")
+	if path, explicit := configPath("my.yaml"); path != "my.yaml" || !explicit {
+		t.Errorf("got %s %t, want the flag", path, explicit)
+	}
+	if path, explicit := configPath(""); path != "/etc/hlsdl.yaml" || !explicit {
+		t.Errorf("got %s %t, want HLSDL_CONFIG", path, explicit)
+	}
+	t.Setenv("HLSDL_CONFIG", "")
+	if _, explicit := configPath(""); explicit {
+		t.Error("the default config file is explicit")
+	}
+
+	// only an explicit file has to exist
+	if _, err := loadConfig(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("missing explicit config file accepted")
+	}
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	if _, err := loadConfig(""); err != nil {
+		t.Errorf("missing default config file: %s", err)
+	}
+}
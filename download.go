@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"hls_downloader/downloader"
+)
+
+// download downloads a stream into a mp4 file.
+func download(args []string) {
+	fs := newFlagSet("download", "[flags] <url>")
+	var (
+		client       clientFlags
+		u, out       string
+		workers      int
+		overwrite    bool
+		variantRules string
+		retries      int
+		mirrors      mirrorList
+		progressMode string
+		start, end   downloader.Bound
+		precise      bool
+		fillGaps     bool
+		minRun       time.Duration
+		skipAds      bool
+		chapters     string
+		chapterFile  string
+		timeline     bool
+		live         bool
+		liveDuration time.Duration
+	)
+	client.register(fs)
+	fs.StringVar(&u, "url", "", "Master playlist direct url, may be given as argument")
+	fs.StringVar(&out, "o", "", "Output file (mp4 format), may be a template like {host}/{name}_{resolution}.mp4 (default: {date}.mp4)")
+	fs.IntVar(&workers, "p", 0, "Number of workers, if 0, number of CPU cores will be used")
+	fs.BoolVar(&overwrite, "f", false, "Override output file if exists")
+	fs.StringVar(&variantRules, "variant", "", "Variants to select, tried in order: highest, lowest, index, 1280x720, 720p, name or prompt (comma separated) (default: highest)")
+	fs.IntVar(&retries, "retries", -1, "Retries of a failed segment request, 0 doesn't retry and -1 retries until the retry timeout")
+	fs.Var(&mirrors, "mirror", "Mirror host to fall back to when a segment fails, may be repeated or comma separated")
+	fs.Var(&start, "start", "Start of the range to download: seconds, [hh:]mm:ss, duration (1h2m) or RFC 3339 program date-time")
+	fs.Var(&start, "ss", "Alias of -start")
+	fs.Var(&end, "end", "End of the range to download, same formats as -start")
+	fs.Var(&end, "to", "Alias of -end")
+	fs.BoolVar(&precise, "precise", false, "Trim the range exactly instead of at segment boundaries (re-encodes the output)")
+	fs.BoolVar(&fillGaps, "fill-gaps", false, "Keep the EXT-X-GAP segments in the timeline, holding the previous segment, instead of joining the media around them")
+	fs.DurationVar(&minRun, "min-run", 0, "Drop the runs of segments between discontinuities shorter than this, like ad slates")
+	fs.BoolVar(&skipAds, "skip-ads", false, "Leave out the ad breaks signaled with SCTE-35 cues or EXT-X-DATERANGE and report their time ranges")
+	fs.StringVar(&chapters, "chapters", "", "Start chapters at discontinuity, scte (cue-out and cue-in) or pdt (program date-time jumps) events, comma separated")
+	fs.StringVar(&chapterFile, "chapter-file", "", "File of chapters, a start and a title per line, used instead of -chapters")
+	fs.BoolVar(&timeline, "timeline", false, "Write the wall-clock time of every segment, from EXT-X-PROGRAM-DATE-TIME, to <output>.timeline.json")
+	fs.BoolVar(&live, "live", false, "Record a live stream as it is published until it ends or is interrupted, following Low-Latency HLS parts")
+	fs.DurationVar(&liveDuration, "live-duration", 0, "Stop a live recording after this much media (default: until the stream ends)")
+	fs.StringVar(&progressMode, "progress", progressBar, "Progress output: bar or json (newline-delimited JSON events on stdout)")
+	fs.Parse(args)
+	u = playlistURL(fs, u)
+
+	cfg, err := client.load(fs)
+	if err != nil {
+		log.Panicln(err)
+	}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "p":
+			cfg.Workers = workers
+		case "f":
+			cfg.Overwrite = overwrite
+		case "variant":
+			cfg.Variant = splitList(variantRules)
+		case "retries":
+			cfg.Retry.MaxRetries = retries
+		case "mirror":
+			cfg.Mirrors = stringList(mirrors)
+		case "progress":
+			cfg.Progress = progressMode
+		}
+	})
+
+	checkFFmpeg()
+
+	if out == "" {
+		out = cfg.outputPath()
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	opts, err := cfg.options()
+	if err != nil {
+		log.Panicln(err)
+	}
+	opts.Logger = log.Default()
+	opts.Start = start
+	opts.End = end
+	opts.Precise = precise
+	opts.FillGaps = fillGaps
+	opts.MinRunDuration = minRun
+	opts.SkipAds = skipAds
+	opts.ChapterFile = chapterFile
+	opts.Timeline = timeline
+	for _, name := range splitList(chapters) {
+		source, err := downloader.ParseChapterSource(name)
+		if err != nil {
+			log.Panicln(err)
+		}
+		opts.Chapters = append(opts.Chapters, source)
+	}
+	opts.Live = live
+	opts.LiveDuration = liveDuration
+
+	var jsonOut *jsonProgress
+	switch cfg.Progress {
+	case progressBar:
+		printer := newProgressPrinter(cfg.Verbose)
+		defer printer.close()
+		opts.OnEvent = printer.onEvent
+	case progressJSON:
+		jsonOut = newJSONProgress()
+		opts.OnEvent = jsonOut.onEvent
+	default:
+		log.Panicln("Unknown progress mode:", cfg.Progress)
+	}
+
+	res, err := downloader.New(opts).Download(ctx, u, out)
+	if jsonOut != nil {
+		jsonOut.summary(res, err)
+	}
+	if errors.Is(err, downloader.ErrOutputExists) {
+		log.Panicln("Output file already exists, use -f to override")
+	}
+	if err != nil {
+		log.Panicln(err)
+	}
+}
@@ -0,0 +1,208 @@
+package downloader
+
+import (
+	"math"
+	"time"
+
+	"github.com/grafov/m3u8"
+)
+
+// AdBreak is a time range of the playlist signaled as an ad break, removed
+// with Options.SkipAds.
+type AdBreak struct {
+	Start    float64 `json:"start"` // seconds from the start of the playlist
+	End      float64 `json:"end"`
+	Segments int     `json:"segments"` // segments removed
+}
+
+// adBreaks returns the ad breaks of the playlist segments: the ranges
+// between SCTE-35 cue-out and cue-in tags, or lasting the duration of the
+// cue-out, and the EXT-X-DATERANGE with a SCTE35-OUT attribute, placed
+// with the program date-time of the segments or at the segment they
+// precede. dateRanges are the trailing EXT-X-DATERANGE of the playlist.
+func adBreaks(segments []*m3u8.MediaSegment, dateRanges []*m3u8.DateRange) []AdBreak {
+	offsets := make([]float64, len(segments)+1)
+	for i, s := range segments {
+		offsets[i+1] = offsets[i] + s.Duration
+	}
+	total := offsets[len(segments)]
+
+	var (
+		breaks []AdBreak
+		open   = -1 // index in breaks of the break without cue-in yet
+	)
+	for i, s := range segments {
+		if s.SCTE == nil {
+			continue
+		}
+		switch s.SCTE.CueType {
+		case m3u8.SCTE35Cue_Start:
+			if s.SCTE.Syntax == m3u8.SCTE35_67_2014 && s.SCTE.Time <= 0 {
+				// the end of the break is only known by decoding the cue
+				continue
+			}
+			if open >= 0 && breaks[open].End > offsets[i] {
+				// a break ends at the next cue-out at the latest, its
+				// cue-in is missing or was replaced by the cue-out
+				breaks[open].End = offsets[i]
+			}
+			end := math.Inf(1)
+			if s.SCTE.Time > 0 {
+				end = offsets[i] + s.SCTE.Time
+			}
+			breaks = append(breaks, AdBreak{Start: offsets[i], End: end})
+			open = len(breaks) - 1
+		case m3u8.SCTE35Cue_Mid:
+			if open < 0 {
+				// the playlist starts within the break, which may have
+				// started before it
+				start := offsets[i] - s.SCTE.Elapsed
+				end := math.Inf(1)
+				if s.SCTE.Time > 0 {
+					end = start + s.SCTE.Time
+				}
+				breaks = append(breaks, AdBreak{Start: start, End: end})
+				open = len(breaks) - 1
+			}
+		case m3u8.SCTE35Cue_End:
+			if open >= 0 {
+				breaks[open].End = offsets[i]
+				open = -1
+			}
+		}
+	}
+
+	// the date ranges of a break may be split in an out and an in tag with
+	// the same ID
+	var (
+		ranges []*m3u8.DateRange
+		starts []float64 // offset of the segment each range precedes
+		byID   = make(map[string]*m3u8.DateRange)
+	)
+	add := func(dr *m3u8.DateRange, offset float64) {
+		if prev, ok := byID[dr.ID]; ok && dr.ID != "" {
+			if !dr.EndDate.IsZero() {
+				prev.EndDate = dr.EndDate
+			}
+			if dr.Duration > 0 {
+				prev.Duration = dr.Duration
+			}
+			if dr.SCTE35In != "" && prev.EndDate.IsZero() && prev.Duration == 0 {
+				prev.EndDate = dr.StartDate
+			}
+			return
+		}
+		if dr.SCTE35Out == "" {
+			return
+		}
+		copied := *dr
+		byID[dr.ID] = &copied
+		ranges = append(ranges, &copied)
+		starts = append(starts, offset)
+	}
+	for i, s := range segments {
+		for _, dr := range s.DateRanges {
+			add(dr, offsets[i])
+		}
+	}
+	for _, dr := range dateRanges {
+		add(dr, total)
+	}
+	wall, hasWall := wallClock(segments)
+	for i, dr := range ranges {
+		start := starts[i]
+		if hasWall && len(segments) > 0 {
+			start = dr.StartDate.Sub(wall[0]).Seconds()
+		}
+		length := dr.Duration
+		switch {
+		case length > 0:
+		case !dr.EndDate.IsZero():
+			length = dr.EndDate.Sub(dr.StartDate).Seconds()
+		case dr.PlannedDuration > 0:
+			length = dr.PlannedDuration
+		default:
+			continue
+		}
+		breaks = append(breaks, AdBreak{Start: start, End: start + length})
+	}
+
+	for i := range breaks {
+		breaks[i].Start = math.Max(breaks[i].Start, 0)
+		breaks[i].End = math.Min(breaks[i].End, total)
+	}
+	return breaks
+}
+
+// adSegments returns the segments whose middle is in one of breaks, with
+// their start in the playlist.
+func adSegments(segments []*m3u8.MediaSegment, breaks []AdBreak) map[*m3u8.MediaSegment]float64 {
+	ads := make(map[*m3u8.MediaSegment]float64)
+	var offset float64
+	for _, s := range segments {
+		middle := offset + s.Duration/2
+		for _, b := range breaks {
+			if middle >= b.Start && middle < b.End {
+				ads[s] = offset
+				break
+			}
+		}
+		offset += s.Duration
+	}
+	return ads
+}
+
+// removeAds removes the ad segments from segments, and reports and keeps
+// in the result the time ranges of the playlist they covered. The segments
+// after a removed break start a run, see adSplits.
+func (dl *download) removeAds(segments []*m3u8.MediaSegment, ads map[*m3u8.MediaSegment]float64) []*m3u8.MediaSegment {
+	kept, removed, splits := withoutAds(segments, ads)
+	dl.adSplits = splits
+	if len(removed) == 0 {
+		dl.log.Println("No ad break found")
+		return kept
+	}
+	var total float64
+	for _, b := range removed {
+		total += b.End - b.Start
+		dl.log.Printf("Ad break removed: %s to %s (%d segments)\n",
+			secondsToDuration(b.Start).Round(time.Millisecond), secondsToDuration(b.End).Round(time.Millisecond), b.Segments)
+	}
+	dl.log.Printf("Removed %d ad breaks, %s\n", len(removed), secondsToDuration(total).Round(time.Millisecond))
+	dl.mu.Lock()
+	dl.result.AdBreaks = removed
+	dl.mu.Unlock()
+	return kept
+}
+
+// withoutAds returns the segments that aren't ads, the time ranges of the
+// playlist the ads covered, and the segments following a removed break,
+// for the content around it to be stitched in separate runs. The segments
+// are left unchanged.
+func withoutAds(segments []*m3u8.MediaSegment, ads map[*m3u8.MediaSegment]float64) ([]*m3u8.MediaSegment, []AdBreak, map[*m3u8.MediaSegment]bool) {
+	var (
+		kept    []*m3u8.MediaSegment
+		removed []AdBreak
+		splits  = make(map[*m3u8.MediaSegment]bool)
+		inAd    bool
+	)
+	for _, s := range segments {
+		start, ad := ads[s]
+		if !ad {
+			if inAd {
+				splits[s] = true
+				inAd = false
+			}
+			kept = append(kept, s)
+			continue
+		}
+		if !inAd {
+			removed = append(removed, AdBreak{Start: start})
+			inAd = true
+		}
+		last := &removed[len(removed)-1]
+		last.End = start + s.Duration
+		last.Segments++
+	}
+	return kept, removed, splits
+}
@@ -0,0 +1,284 @@
+package downloader
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/grafov/m3u8"
+)
+
+// decodeMedia decodes the media playlist and returns its segments.
+func decodeMedia(t *testing.T, playlist string) (*m3u8.MediaPlaylist, []*m3u8.MediaSegment) {
+	t.Helper()
+	p, listType, err := m3u8.DecodeFrom(strings.NewReader(playlist), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if listType != m3u8.MEDIA {
+		t.Fatal("media playlist expected")
+	}
+	mediapl := p.(*m3u8.MediaPlaylist)
+	var segments []*m3u8.MediaSegment
+	for _, s := range mediapl.Segments {
+		if s == nil {
+			break
+		}
+		segments = append(segments, s)
+	}
+	return mediapl, segments
+}
+
+func TestAdBreaks(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		playlist string
+		want     []AdBreak
+	}{
+		{
+			name: "no ads",
+			playlist: `#EXTM3U
+#EXT-X-TARGETDURATION:10
+#EXTINF:10,
+a.ts
+#EXT-X-ENDLIST
+`,
+		},
+		{
+			name: "cue-out and cue-in",
+			playlist: `#EXTM3U
+#EXT-X-TARGETDURATION:10
+#EXTINF:10,
+a.ts
+#EXT-X-CUE-OUT:20
+#EXTINF:10,
+ad1.ts
+#EXT-X-CUE-OUT-CONT:ElapsedTime=10,Duration=20
+#EXTINF:10,
+ad2.ts
+#EXT-X-CUE-IN
+#EXTINF:10,
+b.ts
+#EXT-X-ENDLIST
+`,
+			want: []AdBreak{{Start: 10, End: 30}},
+		},
+		{
+			name: "cue-out lasting its duration",
+			playlist: `#EXTM3U
+#EXT-X-TARGETDURATION:10
+#EXTINF:10,
+a.ts
+#EXT-X-CUE-OUT:15
+#EXTINF:10,
+ad1.ts
+#EXTINF:10,
+b.ts
+#EXT-X-ENDLIST
+`,
+			want: []AdBreak{{Start: 10, End: 25}},
+		},
+		{
+			// the cue-in of the first break is replaced by the cue-out
+			name: "back to back breaks",
+			playlist: `#EXTM3U
+#EXT-X-TARGETDURATION:10
+#EXTINF:10,
+a.ts
+#EXT-X-CUE-OUT:30
+#EXTINF:10,
+ad1.ts
+#EXT-X-CUE-IN
+#EXT-X-CUE-OUT:10
+#EXTINF:10,
+ad2.ts
+#EXT-X-CUE-IN
+#EXTINF:10,
+b.ts
+#EXT-X-ENDLIST
+`,
+			want: []AdBreak{{Start: 10, End: 20}, {Start: 20, End: 30}},
+		},
+		{
+			name: "unterminated cue-out",
+			playlist: `#EXTM3U
+#EXT-X-TARGETDURATION:10
+#EXTINF:10,
+a.ts
+#EXT-X-CUE-OUT
+#EXTINF:10,
+ad1.ts
+#EXTINF:10,
+ad2.ts
+#EXT-X-ENDLIST
+`,
+			want: []AdBreak{{Start: 10, End: 30}},
+		},
+		{
+			name: "unterminated cue-out ended by the next one",
+			playlist: `#EXTM3U
+#EXT-X-TARGETDURATION:10
+#EXT-X-CUE-OUT
+#EXTINF:10,
+ad1.ts
+#EXTINF:10,
+a.ts
+#EXT-X-CUE-OUT:10
+#EXTINF:10,
+ad2.ts
+#EXTINF:10,
+b.ts
+#EXT-X-ENDLIST
+`,
+			want: []AdBreak{{Start: 0, End: 20}, {Start: 20, End: 30}},
+		},
+		{
+			name: "playlist starting within a break",
+			playlist: `#EXTM3U
+#EXT-X-TARGETDURATION:10
+#EXT-X-CUE-OUT-CONT:ElapsedTime=8,Duration=30
+#EXTINF:10,
+ad2.ts
+#EXTINF:10,
+ad3.ts
+#EXTINF:10,
+a.ts
+#EXT-X-ENDLIST
+`,
+			want: []AdBreak{{Start: 0, End: 22}},
+		},
+		{
+			name: "date range pair placed with the program date-time",
+			playlist: `#EXTM3U
+#EXT-X-TARGETDURATION:10
+#EXT-X-PROGRAM-DATE-TIME:2026-03-01T10:00:00Z
+#EXTINF:10,
+a.ts
+#EXT-X-DATERANGE:ID="ad-1",START-DATE="2026-03-01T10:00:12Z",SCTE35-OUT=0xFC30
+#EXTINF:10,
+ad1.ts
+#EXT-X-DATERANGE:ID="ad-1",START-DATE="2026-03-01T10:00:12Z",END-DATE="2026-03-01T10:00:27Z",SCTE35-IN=0xFC30
+#EXTINF:10,
+b.ts
+#EXT-X-ENDLIST
+`,
+			want: []AdBreak{{Start: 12, End: 27}},
+		},
+		{
+			name: "date range cue-in without end date",
+			playlist: `#EXTM3U
+#EXT-X-TARGETDURATION:10
+#EXT-X-PROGRAM-DATE-TIME:2026-03-01T10:00:00Z
+#EXTINF:10,
+a.ts
+#EXT-X-DATERANGE:ID="ad-1",START-DATE="2026-03-01T10:00:10Z",SCTE35-OUT=0xFC30
+#EXTINF:10,
+ad1.ts
+#EXT-X-DATERANGE:ID="ad-1",START-DATE="2026-03-01T10:00:20Z",SCTE35-IN=0xFC30
+#EXTINF:10,
+b.ts
+#EXT-X-ENDLIST
+`,
+			want: []AdBreak{{Start: 10, End: 20}},
+		},
+		{
+			name: "date range at the segment it precedes",
+			playlist: `#EXTM3U
+#EXT-X-TARGETDURATION:10
+#EXTINF:10,
+a.ts
+#EXT-X-DATERANGE:ID="ad-2",START-DATE="2026-03-01T10:00:10Z",PLANNED-DURATION=20,SCTE35-OUT=0xFC30
+#EXTINF:10,
+ad1.ts
+#EXTINF:10,
+ad2.ts
+#EXTINF:10,
+b.ts
+#EXT-X-ENDLIST
+`,
+			want: []AdBreak{{Start: 10, End: 30}},
+		},
+		{
+			name: "date range without duration",
+			playlist: `#EXTM3U
+#EXT-X-TARGETDURATION:10
+#EXT-X-DATERANGE:ID="ad-3",START-DATE="2026-03-01T10:00:00Z",SCTE35-OUT=0xFC30
+#EXTINF:10,
+a.ts
+#EXT-X-ENDLIST
+`,
+		},
+	} {
+		mediapl, segments := decodeMedia(t, tc.playlist)
+		got := adBreaks(segments, mediapl.DateRanges)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got breaks %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestWithoutAds(t *testing.T) {
+	_, segments := decodeMedia(t, `#EXTM3U
+#EXT-X-TARGETDURATION:10
+#EXTINF:10,
+a.ts
+#EXT-X-CUE-OUT:20
+#EXTINF:10,
+ad1.ts
+#EXTINF:10,
+ad2.ts
+#EXT-X-CUE-IN
+#EXTINF:10,
+b.ts
+#EXTINF:10,
+c.ts
+#EXT-X-ENDLIST
+`)
+	ads := adSegments(segments, adBreaks(segments, nil))
+	kept, removed, splits := withoutAds(segments, ads)
+
+	var uris []string
+	for _, s := range kept {
+		uris = append(uris, s.URI)
+	}
+	if want := []string{"a.ts", "b.ts", "c.ts"}; !reflect.DeepEqual(uris, want) {
+		t.Errorf("kept %q, want %q", uris, want)
+	}
+	if want := []AdBreak{{Start: 10, End: 30, Segments: 2}}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("removed %+v, want %+v", removed, want)
+	}
+	if len(splits) != 1 || !splits[segments[3]] {
+		t.Errorf("got splits %v, want b.ts", splits)
+	}
+	// the decoded segments are left unchanged
+	for _, s := range segments {
+		if s.Discontinuity {
+			t.Errorf("segment %s marked as a discontinuity", s.URI)
+		}
+	}
+
+	// the content around the break is stitched in separate runs
+	dl := &download{Downloader: New(Options{}), adSplits: splits}
+	runs := dl.runs([]string{"a", "b", "c"}, kept)
+	if len(runs) != 2 || len(runs[0].files) != 1 || len(runs[1].files) != 2 {
+		t.Errorf("got %d runs, want a and b, c", len(runs))
+	}
+}
+
+func TestAdBreaksAreClipped(t *testing.T) {
+	_, segments := decodeMedia(t, `#EXTM3U
+#EXT-X-TARGETDURATION:10
+#EXTINF:10,
+a.ts
+#EXT-X-CUE-OUT:60
+#EXTINF:10,
+ad1.ts
+#EXT-X-ENDLIST
+`)
+	for _, b := range adBreaks(segments, nil) {
+		if b.Start < 0 || b.End > 20 || math.IsInf(b.End, 0) {
+			t.Errorf("break %+v outside of the playlist", b)
+		}
+	}
+}
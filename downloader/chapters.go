@@ -0,0 +1,168 @@
+package downloader
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafov/m3u8"
+)
+
+// ChapterSource is a kind of playlist event that starts a chapter, see
+// Options.Chapters.
+type ChapterSource string
+
+const (
+	ChaptersDiscontinuity ChapterSource = "discontinuity" // EXT-X-DISCONTINUITY, and the content after a removed ad break
+	ChaptersSCTE          ChapterSource = "scte"          // SCTE-35 cue-out and cue-in
+	ChaptersPDT           ChapterSource = "pdt"           // EXT-X-PROGRAM-DATE-TIME not following the previous segment
+)
+
+// ParseChapterSource parses the name of a chapter source.
+func ParseChapterSource(name string) (ChapterSource, error) {
+	switch s := ChapterSource(strings.ToLower(strings.TrimSpace(name))); s {
+	case ChaptersDiscontinuity, ChaptersSCTE, ChaptersPDT:
+		return s, nil
+	}
+	return "", fmt.Errorf("invalid chapter source %q, want discontinuity, scte or pdt", name)
+}
+
+// Chapter is a navigation point of the output.
+type Chapter struct {
+	Start float64 // seconds from the start of the output
+	End   float64
+	Title string
+}
+
+// pdtJump is the difference between the program date-time of a segment and
+// the end of the previous one above which a chapter starts.
+const pdtJump = time.Second
+
+// minChapter is the shortest chapter, closer marks are merged.
+const minChapter = 1.0
+
+// ReadChapterFile reads a chapter file: a chapter per line, its start in
+// the output (after the range cut with Options.Precise), in the formats of
+// Bound (seconds, [hh:]mm:ss or a duration), followed by its title. Empty lines and lines starting with # are
+// skipped.
+func ReadChapterFile(name string) ([]Chapter, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var chapters []Chapter
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		start, title, _ := strings.Cut(line, " ")
+		b, err := ParseBound(start)
+		if err != nil || !b.wall.IsZero() {
+			return nil, fmt.Errorf("%s:%d: invalid chapter start %q", name, lineNo, start)
+		}
+		chapters = append(chapters, Chapter{Start: b.offset, Title: strings.TrimSpace(title)})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(chapters, func(i, j int) bool { return chapters[i].Start < chapters[j].Start })
+	return chapters, nil
+}
+
+// chapters returns the chapters of the output joining runs: the chapters
+// of Options.ChapterFile when given, or those starting at the events of
+// Options.Chapters. start and length are the part of the output kept by
+// the muxer, length being 0 when it runs to the end. The chapters returned
+// are in output time, starting at start.
+func (dl *download) chapters(runs []*run, start, length float64) []Chapter {
+	var (
+		marks    []Chapter
+		offset   float64
+		wallNext time.Time // expected program date-time of the next segment
+	)
+	sources := make(map[ChapterSource]bool)
+	for _, s := range dl.opts.Chapters {
+		sources[s] = true
+	}
+	for i, r := range runs {
+		if i > 0 && sources[ChaptersDiscontinuity] && !r.gap {
+			marks = append(marks, Chapter{Start: offset})
+		}
+		for j, seg := range r.segments {
+			if sources[ChaptersSCTE] && seg.SCTE != nil && seg.SCTE.CueType != m3u8.SCTE35Cue_Mid {
+				marks = append(marks, Chapter{Start: offset})
+			}
+			if pdt := seg.ProgramDateTime; !pdt.IsZero() {
+				if sources[ChaptersPDT] && !wallNext.IsZero() && absDuration(pdt.Sub(wallNext)) > pdtJump {
+					marks = append(marks, Chapter{Start: offset})
+				}
+				wallNext = pdt
+			}
+			if !wallNext.IsZero() {
+				wallNext = wallNext.Add(secondsToDuration(r.durations[j]))
+			}
+			offset += r.durations[j]
+		}
+	}
+	if len(dl.chapterFile) > 0 {
+		// the chapter file starts are in the output, after start
+		marks = make([]Chapter, len(dl.chapterFile))
+		for i, c := range dl.chapterFile {
+			marks[i] = Chapter{Start: c.Start + start, Title: c.Title}
+		}
+	}
+	if len(marks) == 0 {
+		return nil
+	}
+
+	// the output starts at start and lasts until end
+	end := offset
+	if length > 0 {
+		end = math.Min(end, start+length)
+	}
+	var chapters []Chapter
+	for _, m := range append([]Chapter{{Start: start}}, marks...) {
+		if m.Start < start || m.Start >= end-minChapter {
+			if m.Start <= start && m.Title != "" && len(chapters) > 0 {
+				// a titled chapter started before the output
+				chapters[0].Title = m.Title
+			}
+			continue
+		}
+		if n := len(chapters); n > 0 && m.Start-(chapters[n-1].Start+start) < minChapter {
+			if m.Title != "" {
+				chapters[n-1].Title = m.Title
+			}
+			continue
+		}
+		chapters = append(chapters, Chapter{Start: m.Start - start, Title: m.Title})
+	}
+	for i := range chapters {
+		if i+1 < len(chapters) {
+			chapters[i].End = chapters[i+1].Start
+		} else {
+			chapters[i].End = end - start
+		}
+		if chapters[i].Title == "" {
+			chapters[i].Title = fmt.Sprintf("Chapter %d", i+1)
+		}
+	}
+	if len(chapters) < 2 {
+		return nil
+	}
+	return chapters
+}
+
+func absDuration(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
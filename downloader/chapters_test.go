@@ -0,0 +1,96 @@
+package downloader
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/grafov/m3u8"
+)
+
+// chapterRuns returns runs of 10 second segments, a run per count.
+func chapterRuns(counts ...int) []*run {
+	var runs []*run
+	for _, n := range counts {
+		r := &run{}
+		for i := 0; i < n; i++ {
+			r.segments = append(r.segments, &m3u8.MediaSegment{Duration: 10})
+			r.durations = append(r.durations, 10)
+			r.duration += 10
+		}
+		runs = append(runs, r)
+	}
+	return runs
+}
+
+func TestChapters(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		file          []Chapter
+		start, length float64
+		want          []Chapter
+	}{
+		{
+			name: "discontinuities",
+			want: []Chapter{
+				{Start: 0, End: 30, Title: "Chapter 1"},
+				{Start: 30, End: 50, Title: "Chapter 2"},
+				{Start: 50, End: 90, Title: "Chapter 3"},
+			},
+		},
+		{
+			name:  "range",
+			start: 25, length: 40,
+			want: []Chapter{
+				{Start: 0, End: 5, Title: "Chapter 1"},
+				{Start: 5, End: 25, Title: "Chapter 2"},
+				{Start: 25, End: 40, Title: "Chapter 3"},
+			},
+		},
+		{
+			name:  "range after a chapter",
+			start: 35,
+			want: []Chapter{
+				{Start: 0, End: 15, Title: "Chapter 1"},
+				{Start: 15, End: 55, Title: "Chapter 2"},
+			},
+		},
+		{
+			name: "file",
+			file: []Chapter{{Start: 0, Title: "Intro"}, {Start: 42, Title: "Talk"}},
+			want: []Chapter{
+				{Start: 0, End: 42, Title: "Intro"},
+				{Start: 42, End: 90, Title: "Talk"},
+			},
+		},
+		{
+			// the file starts are in the output, after start
+			name:  "file with a range",
+			file:  []Chapter{{Start: 0, Title: "Intro"}, {Start: 20, Title: "Talk"}},
+			start: 30, length: 50,
+			want: []Chapter{
+				{Start: 0, End: 20, Title: "Intro"},
+				{Start: 20, End: 50, Title: "Talk"},
+			},
+		},
+	} {
+		dl := &download{Downloader: New(Options{Chapters: []ChapterSource{ChaptersDiscontinuity}}), chapterFile: tc.file}
+		got := dl.chapters(chapterRuns(3, 2, 4), tc.start, tc.length)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got chapters %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestChaptersAfterGap(t *testing.T) {
+	// a run following a gap isn't a discontinuity chapter
+	runs := chapterRuns(3, 2, 4)
+	runs[1].gap = true
+	dl := &download{Downloader: New(Options{Chapters: []ChapterSource{ChaptersDiscontinuity}})}
+	want := []Chapter{
+		{Start: 0, End: 50, Title: "Chapter 1"},
+		{Start: 50, End: 90, Title: "Chapter 2"},
+	}
+	if got := dl.chapters(runs, 0, 0); !reflect.DeepEqual(got, want) {
+		t.Errorf("got chapters %+v, want %+v", got, want)
+	}
+}
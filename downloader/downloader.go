@@ -0,0 +1,503 @@
+// Package downloader downloads HLS streams. It picks a variant of a
+// master playlist, fetches (and decrypts) its segments concurrently and
+// joins them into a single file with a Muxer.
+//
+//	d := downloader.New(downloader.Options{Logger: log.Default()})
+//	res, err := d.Download(ctx, "https://example.com/master.m3u8", "out.mp4")
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafov/m3u8"
+)
+
+var (
+	ErrOutputExists = errors.New("output file already exists")
+	ErrNoVariants   = errors.New("no variants found in master playlist")
+	// ErrNoIFrameVariants is returned by Thumbnails for a master playlist
+	// without EXT-X-I-FRAME-STREAM-INF.
+	ErrNoIFrameVariants = errors.New("no I-frame variants found in master playlist")
+)
+
+// Options configures a Downloader, the zero value downloads the highest
+// bitrate variant with ffmpeg and logs nothing.
+type Options struct {
+	// HTTPClient sends every request, http.DefaultClient when nil.
+	HTTPClient *http.Client
+	// Headers are added to every request, they may replace the
+	// User-Agent.
+	Headers http.Header
+	// Retry is the retry policy of the segment requests.
+	Retry RetryPolicy
+	// Logger receives the progress messages, they are discarded when nil.
+	Logger *log.Logger
+	// Verbose logs every segment and key request.
+	Verbose bool
+	// Workers is the number of segments downloaded in parallel, derived
+	// from the number of segments and CPUs when 0.
+	Workers int
+	// Slots bounds the segments downloaded at once across every download
+	// sharing it, the limit being the capacity of the channel.
+	Slots chan struct{}
+	// SelectVariant picks the index of the variant to download among the
+	// variants sorted by descending bandwidth. The first (highest) one is
+	// used when nil.
+	SelectVariant func(variants []*Variant) (int, error)
+	// Mirrors are hosts segments are fetched from when the playlist hosts
+	// keep failing, tried after the redundant variants of the playlist.
+	Mirrors []string
+	// Start and End limit the download to the segments of a time range.
+	Start, End Bound
+	// Precise trims the range exactly instead of at segment boundaries.
+	Precise bool
+	// FillGaps keeps the time of the EXT-X-GAP segments, which are never
+	// downloaded, in the output by extending the segment before them.
+	// Otherwise the media around a gap is stitched in separate runs, like
+	// around a discontinuity, and the output is shorter than the playlist.
+	FillGaps bool
+	// MinRunDuration drops the runs of segments between discontinuities
+	// shorter than this, like ad slates.
+	MinRunDuration time.Duration
+	// SkipAds leaves out the segments of the ad breaks signaled with
+	// SCTE-35 cue-out and cue-in tags or EXT-X-DATERANGE SCTE35-OUT
+	// attributes, the content around them is stitched in separate runs.
+	SkipAds bool
+	// Chapters are the playlist events starting a chapter of the output,
+	// none when empty.
+	Chapters []ChapterSource
+	// ChapterFile is a file of chapters used instead of Chapters, see
+	// ReadChapterFile.
+	ChapterFile string
+	// Timeline writes the wall-clock time of every segment of the output,
+	// from their EXT-X-PROGRAM-DATE-TIME, to a JSON file next to it, see
+	// TimelineName.
+	Timeline bool
+	// Overwrite replaces the destination file if it exists.
+	Overwrite bool
+	// TempDir is where the segments are kept until they are muxed, the
+	// default directory for temporary files when empty.
+	TempDir string
+	// WorkDir keeps the segments across downloads instead of a temporary
+	// directory. Segments already in it are not downloaded again, which
+	// resumes an interrupted download of the same variant. It is left in
+	// place for the caller to remove.
+	WorkDir string
+	// Live records a live media playlist as it is updated, instead of
+	// downloading the segments it lists, until the stream ends,
+	// LiveDuration is recorded or the context is canceled; the recording
+	// is muxed in every case. Low-Latency HLS streams are followed with
+	// blocking playlist reloads and their partial segments.
+	Live bool
+	// LiveDuration stops a live recording once this much media is
+	// recorded, 0 records until the stream ends.
+	LiveDuration time.Duration
+	// Muxer joins the segments into the destination file, ffmpeg when nil.
+	Muxer Muxer
+	// OnEvent is called with the progress of the download. Calls are
+	// serialized but come from the download goroutines, so it should
+	// return quickly.
+	OnEvent func(Event)
+}
+
+// Downloader downloads HLS streams, it is safe for concurrent use.
+type Downloader struct {
+	opts   Options
+	client *http.Client
+	log    *log.Logger
+	muxer  Muxer
+}
+
+// New creates a Downloader configured with opts.
+func New(opts Options) *Downloader {
+	d := &Downloader{
+		opts:   opts,
+		client: opts.HTTPClient,
+		log:    opts.Logger,
+		muxer:  opts.Muxer,
+	}
+	if d.client == nil {
+		d.client = http.DefaultClient
+	}
+	if d.log == nil {
+		d.log = log.New(io.Discard, "", 0)
+	}
+	if d.muxer == nil {
+		d.muxer = &FFmpeg{}
+	}
+	return d
+}
+
+// Variant is a rendition of the stream. Redundant EXT-X-STREAM-INF entries
+// (and content steering pathways, which the parser reports as identical
+// variants) are folded into one Variant and kept as its mirrors.
+type Variant struct {
+	*m3u8.Variant
+	Mirrors []*m3u8.Variant
+}
+
+// VariantName returns a human readable name of the variant.
+func VariantName(v *m3u8.Variant) string {
+	name := v.VariantParams.Name
+	if name == "" {
+		name = v.VariantParams.Resolution
+	}
+	if name == "" {
+		name = fmt.Sprintf("%d", v.VariantParams.Bandwidth)
+	}
+	return name
+}
+
+// Result describes a finished (or failed) download.
+type Result struct {
+	URL        string        `json:"url"`
+	Variant    string        `json:"variant,omitempty"`
+	Output     string        `json:"output,omitempty"`
+	Size       int64         `json:"size"`
+	Segments   int           `json:"segments"`
+	Downloaded int           `json:"downloaded"`
+	Failed     int           `json:"failed"`
+	Bytes      int64         `json:"bytes"`
+	AdBreaks   []AdBreak     `json:"ad_breaks,omitempty"` // removed with Options.SkipAds
+	Duration   time.Duration `json:"-"`
+}
+
+// download holds the state of a single Download call.
+type download struct {
+	*Downloader
+	mu       sync.Mutex // guards result and serializes OnEvent
+	result   Result
+	transfer *transferProgress
+	started  time.Time
+	metadata map[string]string // written to the output file
+	// chapterFile holds the chapters of Options.ChapterFile
+	chapterFile []Chapter
+	// wall holds the wall-clock time of the playlist segments, nil
+	// without EXT-X-PROGRAM-DATE-TIME
+	wall map[*m3u8.MediaSegment]time.Time
+	// adSplits holds the segments following a removed ad break, which
+	// start a run like a discontinuity
+	adSplits map[*m3u8.MediaSegment]bool
+
+	keysMu sync.Mutex // guards keys
+	keys   map[string]*keyFetch
+}
+
+// emit stamps ev, accounts it in the result and passes it to OnEvent.
+func (dl *download) emit(ev Event) {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	ev.Time = time.Now()
+	switch ev.Type {
+	case EventPlaylistFetched:
+		if ev.Segments > 0 {
+			dl.result.Segments = ev.Segments
+		}
+	case EventVariantChosen:
+		dl.result.Variant = ev.Name
+	case EventSegmentFinished:
+		dl.result.Downloaded++
+		dl.result.Bytes += ev.Bytes
+	case EventSegmentFailed:
+		dl.result.Failed++
+	case EventDone:
+		dl.result.Output = ev.Output
+		dl.result.Size = ev.Size
+	}
+	if dl.opts.OnEvent != nil {
+		dl.opts.OnEvent(ev)
+	}
+}
+
+// Download fetches the master playlist at rawUrl and saves the selected
+// variant to dest, which must be a mp4 file. The returned Result is never
+// nil and describes how far the download went when an error is returned.
+func (d *Downloader) Download(ctx context.Context, rawUrl string, dest string) (*Result, error) {
+	st := time.Now()
+	dl := &download{Downloader: d, result: Result{URL: rawUrl}, started: st}
+	err := dl.run(ctx, rawUrl, dest)
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	res := dl.result
+	res.Duration = time.Since(st)
+	return &res, err
+}
+
+func (dl *download) run(ctx context.Context, rawUrl string, dest string) error {
+	uri, err := url.Parse(rawUrl)
+	if err != nil {
+		return err
+	}
+
+	// validate the output file name, a template is checked again once
+	// expanded
+	if !strings.HasSuffix(dest, ".mp4") {
+		return errors.New("output file must be a mp4 file")
+	}
+	tmpl := ""
+	if IsTemplate(dest) {
+		tmpl = dest
+	} else if _, err := os.Stat(dest); err == nil && !dl.opts.Overwrite {
+		return ErrOutputExists
+	}
+
+	if dl.opts.ChapterFile != "" {
+		if dl.chapterFile, err = ReadChapterFile(dl.opts.ChapterFile); err != nil {
+			return err
+		}
+	}
+
+	tmpDir := dl.opts.WorkDir
+	if tmpDir == "" {
+		tmpDir, err = os.MkdirTemp(dl.opts.TempDir, "hls_downloader")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tmpDir)
+	} else if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return err
+	}
+
+	if dl.opts.Verbose {
+		dl.log.Println("Segments directory:", tmpDir)
+	}
+
+	dl.log.Println("Fetching playlist...")
+
+	steering := &tagReader{tag: []byte("#EXT-X-CONTENT-STEERING")}
+	p, listType, err := dl.fetchPlaylist(ctx, uri, nil, steering)
+	if err != nil {
+		return err
+	}
+	if listType != m3u8.MASTER {
+		return errors.New("master playlist expected, media playlist found")
+	}
+	masterpl := p.(*m3u8.MasterPlaylist)
+	dl.emit(Event{Type: EventPlaylistFetched, URL: uri.String(), Variants: len(masterpl.Variants)})
+
+	// redundant variants are merged and kept as mirrors
+	variants := Variants(masterpl)
+	if len(variants) == 0 {
+		return ErrNoVariants
+	}
+	if dl.opts.Verbose && steering.found {
+		dl.log.Println("Content steering detected, pathways are used as mirrors")
+	}
+
+	session := dl.SessionData(ctx, uri, masterpl)
+	dl.metadata = sessionMetadata(session)
+
+	dl.log.Println("Available Variants:")
+	for i, variant := range variants {
+		name := VariantName(variant.Variant)
+		if len(variant.Mirrors) > 0 {
+			name = fmt.Sprintf("%s (%d mirrors)", name, len(variant.Mirrors))
+		}
+		dl.log.Printf("%d: %s\n", i, name)
+	}
+
+	variantId := 0
+	if dl.opts.SelectVariant != nil {
+		if variantId, err = dl.opts.SelectVariant(variants); err != nil {
+			return err
+		}
+		if variantId < 0 || variantId >= len(variants) {
+			return fmt.Errorf("invalid variant id %d", variantId)
+		}
+	} else {
+		dl.log.Printf("Automatically selected highest bitrate variant: %d\n", variantId)
+	}
+
+	variant := variants[variantId]
+
+	vUrl := concatUrl(uri, variant.URI)
+	dl.emit(Event{
+		Type:       EventVariantChosen,
+		URL:        vUrl.String(),
+		Variant:    intPtr(variantId),
+		Name:       VariantName(variant.Variant),
+		Bandwidth:  variant.Bandwidth,
+		Resolution: variant.Resolution,
+	})
+	if dl.opts.Verbose {
+		dl.log.Println("Fetching variant playlist:", vUrl)
+	}
+
+	if tmpl != "" {
+		dest = ExpandTemplate(tmpl, TemplateData{
+			URL:     uri,
+			Variant: variant,
+			Title:   sessionTitle(session),
+			Date:    dl.started,
+		})
+		dl.log.Println("Output file:", dest)
+		if _, err := os.Stat(dest); err == nil && !dl.opts.Overwrite {
+			return ErrOutputExists
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	mirrorUrls := []*url.URL{}
+	for _, m := range variant.Mirrors {
+		mUrl := concatUrl(uri, m.URI)
+		mirrorUrls = append(mirrorUrls, mUrl)
+		if dl.opts.Verbose {
+			dl.log.Println("Variant mirror:", mUrl)
+		}
+	}
+
+	p, listType, err = dl.fetchPlaylist(ctx, vUrl, masterpl.DefinedVariables(), nil)
+	for len(mirrorUrls) > 0 && err != nil {
+		dl.log.Println("Variant playlist failed, falling back to mirror:", err)
+		vUrl, mirrorUrls = mirrorUrls[0], mirrorUrls[1:]
+		p, listType, err = dl.fetchPlaylist(ctx, vUrl, masterpl.DefinedVariables(), nil)
+	}
+	if err != nil {
+		return err
+	}
+	if listType != m3u8.MEDIA {
+		return errors.New("media playlist expected, master playlist found")
+	}
+
+	mediapl := p.(*m3u8.MediaPlaylist)
+	dl.prefetchSessionKeys(ctx, uri, masterpl)
+	if dl.opts.Live && !mediapl.Closed {
+		return dl.recordLive(ctx, vUrl, mediapl, masterpl.DefinedVariables(), tmpDir, dest)
+	}
+	segments := []*m3u8.MediaSegment{}
+	for _, segment := range mediapl.Segments {
+		if segment == nil {
+			break
+		}
+		segments = append(segments, segment)
+	}
+
+	// the times of the segments without EXT-X-PROGRAM-DATE-TIME follow the
+	// segments before them, removed or not
+	dl.wall = wallClocks(segments)
+
+	// the ads are found in the whole playlist, a break may start before
+	// the range
+	var ads map[*m3u8.MediaSegment]float64
+	if dl.opts.SkipAds {
+		ads = adSegments(segments, adBreaks(segments, mediapl.DateRanges))
+	}
+
+	var rangeClip *clip
+	if dl.opts.Start.set || dl.opts.End.set {
+		rangeClip, err = selectRange(segments, dl.opts.Start, dl.opts.End)
+		if err != nil {
+			return err
+		}
+		dl.log.Printf("Selected %d of %d segments in range\n", len(rangeClip.segments), len(segments))
+		segments = rangeClip.segments
+	}
+	if dl.opts.SkipAds {
+		segments = dl.removeAds(segments, ads)
+	}
+	dl.emit(Event{Type: EventPlaylistFetched, URL: vUrl.String(), Segments: len(segments)})
+
+	bandwidth := variant.AverageBandwidth
+	if bandwidth == 0 {
+		bandwidth = variant.Bandwidth
+	}
+	dl.transfer = newTransferProgress(segments, bandwidth, dl.emit)
+	if dl.opts.Verbose {
+		dl.log.Printf("Estimated download size: %s\n", HumanBytes(dl.transfer.total))
+	}
+
+	st := time.Now()
+	defer func() {
+		dl.log.Println("Total time:", time.Since(st))
+	}()
+
+	nWorkers := len(segments) / runtime.NumCPU()
+	if nWorkers == 0 {
+		nWorkers = 1
+	}
+	if dl.opts.Workers > 0 {
+		nWorkers = dl.opts.Workers
+	}
+	if dl.opts.Verbose {
+		dl.log.Printf("Number of workers: %d\n", nWorkers)
+	}
+
+	dInput := &downloadInput{
+		playlistKey:  mediapl.Key,
+		variantUrl:   vUrl,
+		mirrorUrls:   mirrorUrls,
+		mirrorHosts:  dl.opts.Mirrors,
+		segments:     segments,
+		tmpDir:       tmpDir,
+		numOfWorkers: nWorkers,
+	}
+	files, err := dl.downloadSegments(ctx, dInput)
+	dl.transfer.finish()
+	if err != nil {
+		return err
+	}
+
+	return dl.stitch(ctx, dl.runs(files, segments), dest, tmpDir, rangeClip)
+}
+
+// stitch muxes the runs of segment files into dest, trimmed to rangeClip
+// with Options.Precise.
+func (dl *download) stitch(ctx context.Context, runs []*run, dest, tmpDir string, rangeClip *clip) error {
+	mux := dl.muxInput(runs)
+	mux.Output = dest
+	mux.TempDir = tmpDir
+	mux.Metadata = dl.metadata
+
+	dl.emit(Event{Type: EventStitchingStarted, Segments: len(mux.Segments)})
+	dl.log.Println("Stitching segments...")
+
+	if dl.opts.Precise && rangeClip != nil {
+		mux.Start = rangeClip.offset
+		mux.Length = rangeClip.length
+	}
+	mux.Chapters = dl.chapters(runs, mux.Start, mux.Length)
+	if len(mux.Chapters) > 0 {
+		dl.log.Printf("Writing %d chapters\n", len(mux.Chapters))
+	}
+	// the program date-time of the first segment is the creation time
+	tl := timeline(runs, dl.wall, mux.Start, mux.Length)
+	if tl != nil {
+		mux.Metadata = make(map[string]string, len(dl.metadata)+1)
+		for k, v := range dl.metadata {
+			mux.Metadata[k] = v
+		}
+		mux.Metadata["creation_time"] = tl.Start.UTC().Format("2006-01-02T15:04:05.000000Z")
+	}
+	if err := dl.muxer.Mux(ctx, mux); err != nil {
+		return err
+	}
+	if dl.opts.Timeline {
+		if tl == nil {
+			dl.log.Println("The playlist has no EXT-X-PROGRAM-DATE-TIME, no timeline written")
+		} else if name, err := writeTimeline(dest, tl); err != nil {
+			return err
+		} else {
+			dl.log.Println("Timeline written to", name)
+		}
+	}
+
+	dl.log.Println("Done!, output file:", dest)
+	if info, err := os.Stat(dest); err == nil {
+		dl.emit(Event{Type: EventDone, Output: dest, Size: info.Size()})
+	}
+	return nil
+}
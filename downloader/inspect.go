@@ -0,0 +1,265 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"net/url"
+
+	"github.com/grafov/m3u8"
+)
+
+var errNotMedia = errors.New("media playlist expected, master playlist found")
+
+// PlaylistInfo describes a stream, see Inspect.
+type PlaylistInfo struct {
+	URL          string            `json:"url"`
+	Type         string            `json:"type"` // master or media
+	Version      uint8             `json:"version,omitempty"`
+	Variants     []VariantInfo     `json:"variants,omitempty"`
+	IFrames      []VariantInfo     `json:"iframe_variants,omitempty"`
+	Alternatives []AlternativeInfo `json:"alternatives,omitempty"`
+	Media        *MediaInfo        `json:"media,omitempty"` // the playlist itself when it is a media playlist
+}
+
+// VariantInfo describes a variant of a master playlist.
+type VariantInfo struct {
+	Index            int        `json:"index"` // index for SelectVariant, -1 for I-frame variants
+	Name             string     `json:"name"`
+	URI              string     `json:"uri"`
+	Bandwidth        uint32     `json:"bandwidth"`
+	AverageBandwidth uint32     `json:"average_bandwidth,omitempty"`
+	Codecs           string     `json:"codecs,omitempty"`
+	Resolution       string     `json:"resolution,omitempty"`
+	FrameRate        float64    `json:"frame_rate,omitempty"`
+	Audio            string     `json:"audio,omitempty"`
+	Video            string     `json:"video,omitempty"`
+	Subtitles        string     `json:"subtitles,omitempty"`
+	Captions         string     `json:"captions,omitempty"`
+	Mirrors          int        `json:"mirrors,omitempty"`
+	Playlist         *MediaInfo `json:"playlist,omitempty"`
+}
+
+// AlternativeInfo describes an EXT-X-MEDIA rendition.
+type AlternativeInfo struct {
+	Type       string     `json:"type"`
+	GroupID    string     `json:"group_id"`
+	Name       string     `json:"name"`
+	Language   string     `json:"language,omitempty"`
+	Default    bool       `json:"default,omitempty"`
+	Autoselect string     `json:"autoselect,omitempty"`
+	URI        string     `json:"uri,omitempty"`
+	Playlist   *MediaInfo `json:"playlist,omitempty"`
+}
+
+// MediaInfo describes a media playlist. Error is set instead of the other
+// fields when it couldn't be fetched.
+type MediaInfo struct {
+	URL             string     `json:"url"`
+	Error           string     `json:"error,omitempty"`
+	Version         uint8      `json:"version,omitempty"`
+	PlaylistType    string     `json:"playlist_type,omitempty"` // VOD, EVENT or empty
+	Live            bool       `json:"live"`                    // no EXT-X-ENDLIST
+	IFramesOnly     bool       `json:"iframes_only,omitempty"`
+	TargetDuration  float64    `json:"target_duration"`
+	MediaSequence   uint64     `json:"media_sequence"`
+	Segments        int        `json:"segments"`
+	Duration        float64    `json:"duration"` // seconds
+	ByteRanges      int        `json:"byte_ranges,omitempty"`
+	Discontinuities int        `json:"discontinuities,omitempty"`
+	InitSections    []string   `json:"init_sections,omitempty"`
+	Keys            []KeyInfo  `json:"keys,omitempty"`
+	SCTE            []SCTEInfo `json:"scte,omitempty"`
+}
+
+// KeyInfo describes an EXT-X-KEY and the segments it applies to.
+type KeyInfo struct {
+	Method    string `json:"method"`
+	URI       string `json:"uri,omitempty"`
+	IV        string `json:"iv,omitempty"`
+	KeyFormat string `json:"keyformat,omitempty"`
+	First     int    `json:"first_segment"`
+	Last      int    `json:"last_segment"`
+}
+
+// SCTEInfo describes a SCTE-35 cue of a segment.
+type SCTEInfo struct {
+	Segment int     `json:"segment"`
+	Offset  float64 `json:"offset"` // seconds from the start of the playlist
+	Syntax  string  `json:"syntax"` // scte67 or oatcls
+	Cue     string  `json:"cue"`    // start, mid or end
+	ID      string  `json:"id,omitempty"`
+	Time    float64 `json:"time,omitempty"` // duration of the break
+	Elapsed float64 `json:"elapsed,omitempty"`
+	Data    string  `json:"data,omitempty"`
+}
+
+// Inspect fetches the playlist at rawUrl and, for a master playlist, every
+// media playlist of its variants and renditions, and describes them.
+func (d *Downloader) Inspect(ctx context.Context, rawUrl string) (*PlaylistInfo, error) {
+	uri, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	p, listType, err := d.FetchPlaylist(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	if listType == m3u8.MEDIA {
+		mediapl := p.(*m3u8.MediaPlaylist)
+		return &PlaylistInfo{
+			URL:     uri.String(),
+			Type:    "media",
+			Version: mediapl.Version(),
+			Media:   describeMedia(uri, mediapl),
+		}, nil
+	}
+
+	masterpl := p.(*m3u8.MasterPlaylist)
+	info := &PlaylistInfo{URL: uri.String(), Type: "master", Version: masterpl.Version()}
+	fetch := func(ref string) *MediaInfo {
+		mUrl := concatUrl(uri, ref)
+		mediapl, err := d.FetchMediaPlaylist(ctx, mUrl, masterpl)
+		if err != nil {
+			return &MediaInfo{URL: mUrl.String(), Error: err.Error()}
+		}
+		return describeMedia(mUrl, mediapl)
+	}
+
+	seen := make(map[string]bool)
+	variants := Variants(masterpl)
+	for i, v := range append(variants, IFrameVariants(masterpl)...) {
+		vi := describeVariant(v)
+		vi.Playlist = fetch(v.URI)
+		if i >= len(variants) {
+			vi.Index = -1
+			info.IFrames = append(info.IFrames, vi)
+		} else {
+			vi.Index = i
+			info.Variants = append(info.Variants, vi)
+		}
+		for _, alt := range v.Alternatives {
+			if alt == nil {
+				continue
+			}
+			key := alt.Type + "|" + alt.GroupId + "|" + alt.Name + "|" + alt.URI
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			ai := AlternativeInfo{
+				Type:       alt.Type,
+				GroupID:    alt.GroupId,
+				Name:       alt.Name,
+				Language:   alt.Language,
+				Default:    alt.Default,
+				Autoselect: alt.Autoselect,
+				URI:        alt.URI,
+			}
+			if alt.URI != "" {
+				ai.Playlist = fetch(alt.URI)
+			}
+			info.Alternatives = append(info.Alternatives, ai)
+		}
+	}
+	return info, nil
+}
+
+func describeVariant(v *Variant) VariantInfo {
+	return VariantInfo{
+		Name:             VariantName(v.Variant),
+		URI:              v.URI,
+		Bandwidth:        v.Bandwidth,
+		AverageBandwidth: v.AverageBandwidth,
+		Codecs:           v.Codecs,
+		Resolution:       v.Resolution,
+		FrameRate:        v.FrameRate,
+		Audio:            v.Audio,
+		Video:            v.Video,
+		Subtitles:        v.Subtitles,
+		Captions:         v.Captions,
+		Mirrors:          len(v.Mirrors),
+	}
+}
+
+func describeMedia(uri *url.URL, p *m3u8.MediaPlaylist) *MediaInfo {
+	mi := &MediaInfo{
+		URL:            uri.String(),
+		Version:        p.Version(),
+		Live:           !p.Closed,
+		IFramesOnly:    p.Iframe,
+		TargetDuration: p.TargetDuration,
+		MediaSequence:  p.SeqNo,
+	}
+	switch p.MediaType {
+	case m3u8.VOD:
+		mi.PlaylistType = "VOD"
+	case m3u8.EVENT:
+		mi.PlaylistType = "EVENT"
+	}
+
+	var key *m3u8.Key
+	seenMaps := make(map[string]bool)
+	addMap := func(m *m3u8.Map) {
+		if m != nil && m.URI != "" && !seenMaps[m.URI] {
+			seenMaps[m.URI] = true
+			mi.InitSections = append(mi.InitSections, m.URI)
+		}
+	}
+	addMap(p.Map)
+	for i, s := range p.Segments {
+		if s == nil {
+			break
+		}
+		if s.Key != nil && (key == nil || *s.Key != *key) {
+			key = s.Key
+			if key.Method != "" && key.Method != "NONE" {
+				mi.Keys = append(mi.Keys, KeyInfo{
+					Method:    key.Method,
+					URI:       key.URI,
+					IV:        key.IV,
+					KeyFormat: key.Keyformat,
+					First:     i,
+				})
+			}
+		}
+		if n := len(mi.Keys); n > 0 && key != nil && key.Method != "NONE" {
+			mi.Keys[n-1].Last = i
+		}
+		addMap(s.Map)
+		if s.Limit > 0 {
+			mi.ByteRanges++
+		}
+		if s.Discontinuity {
+			mi.Discontinuities++
+		}
+		if s.SCTE != nil {
+			mi.SCTE = append(mi.SCTE, describeSCTE(i, mi.Duration, s.SCTE))
+		}
+		mi.Segments++
+		mi.Duration += s.Duration
+	}
+	return mi
+}
+
+func describeSCTE(i int, offset float64, scte *m3u8.SCTE) SCTEInfo {
+	si := SCTEInfo{
+		Segment: i,
+		Offset:  offset,
+		Syntax:  "scte67",
+		Cue:     "start",
+		ID:      scte.ID,
+		Time:    scte.Time,
+		Elapsed: scte.Elapsed,
+		Data:    scte.Cue,
+	}
+	if scte.Syntax == m3u8.SCTE35_OATCLS {
+		si.Syntax = "oatcls"
+	}
+	switch scte.CueType {
+	case m3u8.SCTE35Cue_Mid:
+		si.Cue = "mid"
+	case m3u8.SCTE35Cue_End:
+		si.Cue = "end"
+	}
+	return si
+}
@@ -0,0 +1,593 @@
+package downloader
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/grafov/m3u8"
+)
+
+// Severity ranks a lint Finding.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"   // breaks a MUST of RFC 8216
+	SeverityWarning Severity = "warning" // breaks a SHOULD of RFC 8216
+)
+
+// Finding is a problem found by Lint or LintReload.
+type Finding struct {
+	Line     int      `json:"line,omitempty"` // 1-based, 0 when it is about the whole playlist
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%d: %s: %s", f.Line, f.Severity, f.Message)
+}
+
+// tags that only belong to one kind of playlist
+var (
+	masterTags = map[string]bool{
+		"EXT-X-MEDIA":              true,
+		"EXT-X-STREAM-INF":         true,
+		"EXT-X-I-FRAME-STREAM-INF": true,
+		"EXT-X-SESSION-DATA":       true,
+		"EXT-X-SESSION-KEY":        true,
+		"EXT-X-CONTENT-STEERING":   true,
+	}
+	mediaTags = map[string]bool{
+		"EXTINF":                       true,
+		"EXT-X-TARGETDURATION":         true,
+		"EXT-X-MEDIA-SEQUENCE":         true,
+		"EXT-X-DISCONTINUITY-SEQUENCE": true,
+		"EXT-X-ENDLIST":                true,
+		"EXT-X-PLAYLIST-TYPE":          true,
+		"EXT-X-I-FRAMES-ONLY":          true,
+		"EXT-X-BYTERANGE":              true,
+		"EXT-X-DISCONTINUITY":          true,
+		"EXT-X-KEY":                    true,
+		"EXT-X-MAP":                    true,
+		"EXT-X-PROGRAM-DATE-TIME":      true,
+		"EXT-X-GAP":                    true,
+		"EXT-X-BITRATE":                true,
+	}
+)
+
+// linter holds the state of Lint along the lines of a playlist.
+type linter struct {
+	findings []Finding
+	line     int
+
+	kind        string // master or media, from the first tag of either kind
+	version     int
+	versionLine int
+	needs       []versionNeed
+
+	// media playlist
+	targetDuration  int
+	targetLine      int
+	segments        int
+	extinf          int // line of the EXTINF waiting for its URI, 0 if none
+	extinfDuration  float64
+	byterange       int    // line of the EXT-X-BYTERANGE waiting for its URI
+	byterangeOffset bool   // the pending EXT-X-BYTERANGE has an offset
+	lastURI         string // URI of the previous segment
+	playlistType    string
+	endList         bool
+	iframesOnly     bool
+	maps            []int // lines of EXT-X-MAP
+
+	// master playlist
+	streamInf int                        // line of the EXT-X-STREAM-INF waiting for its URI
+	groups    map[string]map[string]bool // EXT-X-MEDIA group ids by type
+	groupUses []groupUse
+	variants  int
+	noCodecs  []int
+	seenOnce  map[string]int // lines of the tags allowed once
+}
+
+// versionNeed is a feature requiring a minimum EXT-X-VERSION.
+type versionNeed struct {
+	version int
+	line    int
+	feature string
+}
+
+// groupUse is a reference of a variant to an EXT-X-MEDIA group.
+type groupUse struct {
+	line      int
+	mediaType string
+	group     string
+}
+
+// Lint checks a master or media playlist against the rules of RFC 8216:
+// the tag placement and attributes, the EXTINF durations against the
+// EXT-X-TARGETDURATION, the EXT-X-VERSION the features need, the
+// EXT-X-ENDLIST of VOD playlists and the CODECS of the variants. A
+// playlist the m3u8 package can't decode in strict mode gets an error at
+// the line the decoding stopped. The findings are sorted by line.
+func Lint(data []byte) []Finding {
+	l := &linter{
+		groups:   make(map[string]map[string]bool),
+		seenOnce: make(map[string]int),
+		version:  1,
+	}
+	lines := strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	for i, raw := range lines {
+		l.line = i + 1
+		line := strings.TrimSpace(raw)
+		if i == 0 && line != "#EXTM3U" {
+			l.add(SeverityError, "the playlist must start with #EXTM3U")
+		}
+		switch {
+		case i == 0 && line == "#EXTM3U":
+		case line == "":
+		case strings.HasPrefix(line, "#EXT"):
+			l.tag(line)
+		case strings.HasPrefix(line, "#"):
+			// comment
+		default:
+			l.uri(line)
+		}
+	}
+	l.line = len(lines)
+	l.finish()
+	if _, _, err := m3u8.DecodeWithVariables(bytes.NewReader(data), true, lintVariables(lines)); err != nil {
+		var pe *m3u8.ParseError
+		if errors.As(err, &pe) && pe.Line > 0 {
+			// the finding has the line, the message only the problem
+			if pe.Tag != "" {
+				l.addAt(pe.Line, SeverityError, "strict decoding failed: %s: %s", pe.Tag, pe.Err)
+			} else {
+				l.addAt(pe.Line, SeverityError, "strict decoding failed: %s", pe.Err)
+			}
+		} else {
+			l.addAt(0, SeverityError, "strict decoding failed: %s", err)
+		}
+	}
+	sortFindings(l.findings)
+	return l.findings
+}
+
+// lintVariables returns placeholder values for the variables the
+// EXT-X-DEFINE tags of lines import, the playlist alone doesn't tell them.
+func lintVariables(lines []string) m3u8.Variables {
+	vars := m3u8.Variables{Imports: make(map[string]string), Query: make(url.Values)}
+	for _, line := range lines {
+		if attrs, ok := strings.CutPrefix(strings.TrimSpace(line), "#EXT-X-DEFINE:"); ok {
+			params := parseAttributes(attrs)
+			if name := params["IMPORT"]; name != "" {
+				vars.Imports[name] = name
+			}
+			if name := params["QUERYPARAM"]; name != "" {
+				vars.Query.Set(name, name)
+			}
+		}
+	}
+	return vars
+}
+
+func (l *linter) add(severity Severity, format string, args ...interface{}) {
+	l.addAt(l.line, severity, format, args...)
+}
+
+func (l *linter) addAt(line int, severity Severity, format string, args ...interface{}) {
+	l.findings = append(l.findings, Finding{Line: line, Severity: severity, Message: fmt.Sprintf(format, args...)})
+}
+
+func (l *linter) need(version int, feature string) {
+	l.needs = append(l.needs, versionNeed{version: version, line: l.line, feature: feature})
+}
+
+// once reports a tag appearing more than once in the playlist.
+func (l *linter) once(name string) {
+	if first, ok := l.seenOnce[name]; ok {
+		l.add(SeverityError, "%s already appears on line %d", name, first)
+		return
+	}
+	l.seenOnce[name] = l.line
+}
+
+func (l *linter) tag(line string) {
+	name, value, _ := strings.Cut(strings.TrimPrefix(line, "#"), ":")
+
+	kind := ""
+	switch {
+	case masterTags[name]:
+		kind = "master"
+	case mediaTags[name]:
+		kind = "media"
+	}
+	if kind != "" {
+		if l.kind == "" {
+			l.kind = kind
+		} else if l.kind != kind {
+			l.add(SeverityError, "%s is a %s playlist tag in a %s playlist", name, kind, l.kind)
+			return
+		}
+	}
+	if kind == "media" && l.segments > 0 && isHeaderTag(name) {
+		l.add(SeverityError, "%s must appear before the first media segment", name)
+	}
+
+	switch name {
+	case "EXTM3U":
+		if l.line != 1 {
+			l.add(SeverityError, "EXTM3U must be the first line")
+		}
+	case "EXT-X-VERSION":
+		l.once(name)
+		v, err := strconv.Atoi(value)
+		if err != nil || v < 1 {
+			l.add(SeverityError, "invalid EXT-X-VERSION %q", value)
+			return
+		}
+		l.version, l.versionLine = v, l.line
+	case "EXT-X-TARGETDURATION":
+		l.once(name)
+		d, err := strconv.Atoi(value)
+		if err != nil || d < 0 {
+			l.add(SeverityError, "EXT-X-TARGETDURATION must be a decimal integer, got %q", value)
+			return
+		}
+		l.targetDuration, l.targetLine = d, l.line
+	case "EXT-X-MEDIA-SEQUENCE", "EXT-X-DISCONTINUITY-SEQUENCE":
+		l.once(name)
+		if _, err := strconv.ParseUint(value, 10, 64); err != nil {
+			l.add(SeverityError, "%s must be a decimal integer, got %q", name, value)
+		}
+	case "EXT-X-PLAYLIST-TYPE":
+		l.once(name)
+		if value != "VOD" && value != "EVENT" {
+			l.add(SeverityError, "EXT-X-PLAYLIST-TYPE must be VOD or EVENT, got %q", value)
+		}
+		l.playlistType = value
+	case "EXT-X-I-FRAMES-ONLY":
+		l.once(name)
+		l.iframesOnly = true
+		l.need(4, "EXT-X-I-FRAMES-ONLY")
+	case "EXT-X-ENDLIST":
+		l.once(name)
+		l.endList = true
+	case "EXT-X-DEFINE":
+		l.need(8, "EXT-X-DEFINE")
+	case "EXTINF":
+		l.extinfTag(value)
+	case "EXT-X-BYTERANGE":
+		l.need(4, "EXT-X-BYTERANGE")
+		length, offset, hasOffset := strings.Cut(value, "@")
+		if _, err := strconv.ParseUint(length, 10, 64); err != nil {
+			l.add(SeverityError, "invalid EXT-X-BYTERANGE %q", value)
+		} else if hasOffset {
+			if _, err := strconv.ParseUint(offset, 10, 64); err != nil {
+				l.add(SeverityError, "invalid EXT-X-BYTERANGE offset %q", offset)
+			}
+		}
+		l.byterange, l.byterangeOffset = l.line, hasOffset
+	case "EXT-X-KEY", "EXT-X-SESSION-KEY":
+		l.keyTag(name, parseAttributes(value))
+	case "EXT-X-MAP":
+		attrs := parseAttributes(value)
+		if attrs["URI"] == "" {
+			l.add(SeverityError, "EXT-X-MAP must have a URI")
+		}
+		if attrs["BYTERANGE"] != "" {
+			l.need(4, "BYTERANGE of EXT-X-MAP")
+		}
+		l.maps = append(l.maps, l.line)
+	case "EXT-X-STREAM-INF":
+		l.streamInfTag(parseAttributes(value))
+	case "EXT-X-I-FRAME-STREAM-INF":
+		attrs := parseAttributes(value)
+		if attrs["BANDWIDTH"] == "" {
+			l.add(SeverityError, "EXT-X-I-FRAME-STREAM-INF must have a BANDWIDTH")
+		}
+		if attrs["URI"] == "" {
+			l.add(SeverityError, "EXT-X-I-FRAME-STREAM-INF must have a URI")
+		}
+		l.groupRefs(attrs, "VIDEO")
+	case "EXT-X-MEDIA":
+		l.mediaTag(parseAttributes(value))
+	case "EXT-X-SESSION-DATA":
+		attrs := parseAttributes(value)
+		if attrs["DATA-ID"] == "" {
+			l.add(SeverityError, "EXT-X-SESSION-DATA must have a DATA-ID")
+		}
+		if (attrs["VALUE"] == "") == (attrs["URI"] == "") {
+			l.add(SeverityError, "EXT-X-SESSION-DATA must have either a VALUE or a URI")
+		}
+	}
+}
+
+// isHeaderTag reports whether the media playlist tag applies to the whole
+// playlist, so it must come before the segments.
+func isHeaderTag(name string) bool {
+	switch name {
+	case "EXT-X-TARGETDURATION", "EXT-X-MEDIA-SEQUENCE", "EXT-X-DISCONTINUITY-SEQUENCE",
+		"EXT-X-PLAYLIST-TYPE", "EXT-X-I-FRAMES-ONLY":
+		return true
+	}
+	return false
+}
+
+func (l *linter) extinfTag(value string) {
+	if l.extinf != 0 {
+		l.addAt(l.extinf, SeverityError, "EXTINF without a segment URI")
+	}
+	l.extinf = l.line
+	durationStr, _, _ := strings.Cut(value, ",")
+	d, err := strconv.ParseFloat(strings.TrimSpace(durationStr), 64)
+	if err != nil || d < 0 {
+		l.add(SeverityError, "invalid EXTINF duration %q", durationStr)
+		l.extinfDuration = 0
+		return
+	}
+	l.extinfDuration = d
+	if strings.Contains(durationStr, ".") {
+		l.need(3, "floating point EXTINF durations")
+	}
+}
+
+func (l *linter) keyTag(name string, attrs map[string]string) {
+	method := attrs["METHOD"]
+	switch method {
+	case "":
+		l.add(SeverityError, "%s must have a METHOD", name)
+	case "NONE":
+		if name == "EXT-X-SESSION-KEY" {
+			l.add(SeverityError, "EXT-X-SESSION-KEY must not have METHOD NONE")
+		}
+		if attrs["URI"] != "" || attrs["IV"] != "" || attrs["KEYFORMAT"] != "" {
+			l.add(SeverityError, "%s with METHOD NONE must not have other attributes", name)
+		}
+	case "AES-128", "SAMPLE-AES", "SAMPLE-AES-CTR":
+		if attrs["URI"] == "" {
+			l.add(SeverityError, "%s with METHOD %s must have a URI", name, method)
+		}
+	default:
+		l.add(SeverityWarning, "unknown %s METHOD %q", name, method)
+	}
+	if iv := attrs["IV"]; iv != "" {
+		l.need(2, "IV of "+name)
+		hex := strings.TrimPrefix(strings.TrimPrefix(iv, "0x"), "0X")
+		if len(hex) != 32 {
+			l.add(SeverityError, "IV must be a 128-bit hexadecimal integer, got %q", iv)
+		}
+	}
+	if attrs["KEYFORMAT"] != "" || attrs["KEYFORMATVERSIONS"] != "" {
+		l.need(5, "KEYFORMAT of "+name)
+	}
+}
+
+func (l *linter) streamInfTag(attrs map[string]string) {
+	if l.streamInf != 0 {
+		l.addAt(l.streamInf, SeverityError, "EXT-X-STREAM-INF without a URI")
+	}
+	l.streamInf = l.line
+	l.variants++
+	if attrs["BANDWIDTH"] == "" {
+		l.add(SeverityError, "EXT-X-STREAM-INF must have a BANDWIDTH")
+	} else if _, err := strconv.ParseUint(attrs["BANDWIDTH"], 10, 64); err != nil {
+		l.add(SeverityError, "invalid BANDWIDTH %q", attrs["BANDWIDTH"])
+	}
+	if attrs["CODECS"] == "" {
+		l.noCodecs = append(l.noCodecs, l.line)
+	}
+	if res := attrs["RESOLUTION"]; res != "" {
+		w, h, ok := strings.Cut(res, "x")
+		if _, err := strconv.Atoi(w); !ok || err != nil {
+			l.add(SeverityError, "invalid RESOLUTION %q", res)
+		} else if _, err := strconv.Atoi(h); err != nil {
+			l.add(SeverityError, "invalid RESOLUTION %q", res)
+		}
+	}
+	l.groupRefs(attrs, "AUDIO", "VIDEO", "SUBTITLES", "CLOSED-CAPTIONS")
+}
+
+// groupRefs records the EXT-X-MEDIA groups referenced by a variant, they
+// are checked once every EXT-X-MEDIA is known.
+func (l *linter) groupRefs(attrs map[string]string, types ...string) {
+	for _, t := range types {
+		if g := attrs[t]; g != "" && !(t == "CLOSED-CAPTIONS" && g == "NONE") {
+			l.groupUses = append(l.groupUses, groupUse{line: l.line, mediaType: t, group: g})
+		}
+	}
+}
+
+func (l *linter) mediaTag(attrs map[string]string) {
+	t := attrs["TYPE"]
+	switch t {
+	case "AUDIO", "VIDEO", "SUBTITLES", "CLOSED-CAPTIONS":
+	case "":
+		l.add(SeverityError, "EXT-X-MEDIA must have a TYPE")
+	default:
+		l.add(SeverityError, "invalid EXT-X-MEDIA TYPE %q", t)
+	}
+	if attrs["GROUP-ID"] == "" {
+		l.add(SeverityError, "EXT-X-MEDIA must have a GROUP-ID")
+	}
+	if attrs["NAME"] == "" {
+		l.add(SeverityError, "EXT-X-MEDIA must have a NAME")
+	}
+	if t == "CLOSED-CAPTIONS" {
+		if attrs["URI"] != "" {
+			l.add(SeverityError, "EXT-X-MEDIA of TYPE CLOSED-CAPTIONS must not have a URI")
+		}
+		id := attrs["INSTREAM-ID"]
+		if id == "" {
+			l.add(SeverityError, "EXT-X-MEDIA of TYPE CLOSED-CAPTIONS must have an INSTREAM-ID")
+		} else if strings.HasPrefix(id, "SERVICE") {
+			l.need(7, "INSTREAM-ID "+id)
+		}
+	}
+	if t == "SUBTITLES" && attrs["URI"] == "" {
+		l.add(SeverityError, "EXT-X-MEDIA of TYPE SUBTITLES must have a URI")
+	}
+	if attrs["DEFAULT"] == "YES" && attrs["AUTOSELECT"] == "NO" {
+		l.add(SeverityError, "AUTOSELECT must be YES when DEFAULT is YES")
+	}
+	if l.groups[t] == nil {
+		l.groups[t] = make(map[string]bool)
+	}
+	l.groups[t][attrs["GROUP-ID"]] = true
+}
+
+func (l *linter) uri(uri string) {
+	switch {
+	case l.streamInf != 0:
+		l.streamInf = 0
+	case l.extinf != 0:
+		l.segment(uri)
+	default:
+		l.add(SeverityError, "URI %q without EXTINF or EXT-X-STREAM-INF", uri)
+	}
+}
+
+func (l *linter) segment(uri string) {
+	if l.targetLine != 0 && int(math.Round(l.extinfDuration)) > l.targetDuration {
+		l.addAt(l.extinf, SeverityError, "EXTINF duration %g exceeds EXT-X-TARGETDURATION %d", l.extinfDuration, l.targetDuration)
+	}
+	if l.byterange != 0 && !l.byterangeOffset && uri != l.lastURI {
+		l.addAt(l.byterange, SeverityError, "EXT-X-BYTERANGE without an offset must follow a segment of the same resource")
+	}
+	l.extinf, l.byterange = 0, 0
+	l.lastURI = uri
+	l.segments++
+}
+
+// finish runs the checks that need the whole playlist.
+func (l *linter) finish() {
+	if l.extinf != 0 {
+		l.addAt(l.extinf, SeverityError, "EXTINF without a segment URI")
+	}
+	if l.streamInf != 0 {
+		l.addAt(l.streamInf, SeverityError, "EXT-X-STREAM-INF without a URI")
+	}
+
+	switch l.kind {
+	case "media":
+		if l.targetLine == 0 {
+			l.addAt(0, SeverityError, "EXT-X-TARGETDURATION is missing")
+		}
+		if l.playlistType == "VOD" && !l.endList {
+			l.addAt(l.seenOnce["EXT-X-PLAYLIST-TYPE"], SeverityError, "VOD playlist without EXT-X-ENDLIST")
+		}
+		if !l.iframesOnly {
+			for _, line := range l.maps {
+				l.needs = append(l.needs, versionNeed{version: 6, line: line, feature: "EXT-X-MAP outside of an I-frames only playlist"})
+			}
+		} else {
+			for _, line := range l.maps {
+				l.needs = append(l.needs, versionNeed{version: 5, line: line, feature: "EXT-X-MAP"})
+			}
+		}
+	case "master":
+		for _, use := range l.groupUses {
+			if !l.groups[use.mediaType][use.group] {
+				l.addAt(use.line, SeverityError, "%s group %q has no EXT-X-MEDIA", use.mediaType, use.group)
+			}
+		}
+		for _, line := range l.noCodecs {
+			l.addAt(line, SeverityWarning, "EXT-X-STREAM-INF should have a CODECS attribute")
+		}
+	}
+
+	for _, n := range l.needs {
+		if n.version > l.version {
+			line := n.line
+			if line == 0 {
+				line = l.versionLine
+			}
+			l.addAt(line, SeverityError, "%s needs EXT-X-VERSION %d or higher, the playlist has %d", n.feature, n.version, l.version)
+		}
+	}
+}
+
+func sortFindings(findings []Finding) {
+	// insertion sort keeps the order of the findings of a line
+	for i := 1; i < len(findings); i++ {
+		for j := i; j > 0 && findings[j].Line < findings[j-1].Line; j-- {
+			findings[j], findings[j-1] = findings[j-1], findings[j]
+		}
+	}
+}
+
+// reloadState is what LintReload compares between two versions of a live
+// media playlist.
+type reloadState struct {
+	mediaSequence         uint64
+	discontinuitySequence uint64
+	targetDuration        string
+	endList               bool
+	segments              []string // URIs by position from mediaSequence
+}
+
+func scanReload(data []byte) reloadState {
+	var s reloadState
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		name, value, _ := strings.Cut(strings.TrimPrefix(line, "#"), ":")
+		switch {
+		case line == "":
+		case !strings.HasPrefix(line, "#"):
+			s.segments = append(s.segments, line)
+		case name == "EXT-X-MEDIA-SEQUENCE":
+			s.mediaSequence, _ = strconv.ParseUint(value, 10, 64)
+		case name == "EXT-X-DISCONTINUITY-SEQUENCE":
+			s.discontinuitySequence, _ = strconv.ParseUint(value, 10, 64)
+		case name == "EXT-X-TARGETDURATION":
+			s.targetDuration = value
+		case name == "EXT-X-ENDLIST":
+			s.endList = true
+		}
+	}
+	return s
+}
+
+// LintReload checks that next, a reload of the live media playlist prev,
+// follows the update rules of RFC 8216: the media and discontinuity
+// sequence numbers don't go back, the target duration doesn't change, the
+// segments keep their sequence numbers and EXT-X-ENDLIST isn't removed.
+// The findings have no line.
+func LintReload(prev, next []byte) []Finding {
+	var findings []Finding
+	add := func(format string, args ...interface{}) {
+		findings = append(findings, Finding{Severity: SeverityError, Message: fmt.Sprintf(format, args...)})
+	}
+	p, n := scanReload(prev), scanReload(next)
+
+	if n.mediaSequence < p.mediaSequence {
+		add("EXT-X-MEDIA-SEQUENCE went back from %d to %d", p.mediaSequence, n.mediaSequence)
+	}
+	if n.discontinuitySequence < p.discontinuitySequence {
+		add("EXT-X-DISCONTINUITY-SEQUENCE went back from %d to %d", p.discontinuitySequence, n.discontinuitySequence)
+	}
+	if n.targetDuration != p.targetDuration {
+		add("EXT-X-TARGETDURATION changed from %s to %s", p.targetDuration, n.targetDuration)
+	}
+	if p.endList && !n.endList {
+		add("EXT-X-ENDLIST was removed")
+	}
+	if p.endList && n.endList && string(prev) != string(next) {
+		add("the playlist changed after EXT-X-ENDLIST")
+	}
+	if n.mediaSequence > p.mediaSequence+uint64(len(p.segments)) {
+		add("EXT-X-MEDIA-SEQUENCE skipped from %d to %d, segments were never listed", p.mediaSequence, n.mediaSequence)
+	}
+	for i, uri := range n.segments {
+		seq := n.mediaSequence + uint64(i)
+		if seq < p.mediaSequence || seq >= p.mediaSequence+uint64(len(p.segments)) {
+			continue
+		}
+		if prevURI := p.segments[seq-p.mediaSequence]; prevURI != uri {
+			add("segment %d changed from %s to %s", seq, prevURI, uri)
+		}
+	}
+	return findings
+}
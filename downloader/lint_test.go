@@ -0,0 +1,216 @@
+package downloader
+
+import (
+	"strings"
+	"testing"
+)
+
+// wantFinding is a finding expected from a playlist, matched by line,
+// severity and a part of the message.
+type wantFinding struct {
+	line     int
+	severity Severity
+	message  string
+}
+
+func checkFindings(t *testing.T, name string, got []Finding, want []wantFinding) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s: got findings %v, want %d", name, got, len(want))
+		return
+	}
+	for i, w := range want {
+		f := got[i]
+		if f.Line != w.line || f.Severity != w.severity || !strings.Contains(f.Message, w.message) {
+			t.Errorf("%s: finding %d is %q, want %d: %s: ...%s...", name, i, f, w.line, w.severity, w.message)
+		}
+	}
+}
+
+func TestLint(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		playlist string
+		want     []wantFinding
+	}{
+		{
+			name: "valid media playlist",
+			playlist: `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:10
+#EXT-X-PLAYLIST-TYPE:VOD
+#EXTINF:9.009,
+a.ts
+#EXTINF:10,
+b.ts
+#EXT-X-ENDLIST
+`,
+		},
+		{
+			name: "EXTINF over the target duration",
+			playlist: `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:6
+#EXTINF:6.4,
+a.ts
+#EXTINF:6.6,
+b.ts
+#EXT-X-ENDLIST
+`,
+			want: []wantFinding{{6, SeverityError, "EXTINF duration 6.6 exceeds EXT-X-TARGETDURATION 6"}},
+		},
+		{
+			name: "EXT-X-BYTERANGE needs version 4",
+			playlist: `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:10
+#EXTINF:10,
+#EXT-X-BYTERANGE:1000@0
+all.ts
+#EXTINF:10,
+#EXT-X-BYTERANGE:1000
+all.ts
+#EXT-X-ENDLIST
+`,
+			want: []wantFinding{
+				{5, SeverityError, "EXT-X-BYTERANGE needs EXT-X-VERSION 4 or higher, the playlist has 3"},
+				{8, SeverityError, "EXT-X-BYTERANGE needs EXT-X-VERSION 4"},
+			},
+		},
+		{
+			name: "EXT-X-BYTERANGE without offset after another resource",
+			playlist: `#EXTM3U
+#EXT-X-VERSION:4
+#EXT-X-TARGETDURATION:10
+#EXTINF:10,
+a.ts
+#EXTINF:10,
+#EXT-X-BYTERANGE:1000
+b.ts
+#EXT-X-ENDLIST
+`,
+			want: []wantFinding{{7, SeverityError, "must follow a segment of the same resource"}},
+		},
+		{
+			name: "VOD without EXT-X-ENDLIST",
+			playlist: `#EXTM3U
+#EXT-X-TARGETDURATION:10
+#EXT-X-PLAYLIST-TYPE:VOD
+#EXTINF:10,
+a.ts
+`,
+			want: []wantFinding{{3, SeverityError, "VOD playlist without EXT-X-ENDLIST"}},
+		},
+		{
+			name: "missing EXT-X-TARGETDURATION",
+			playlist: `#EXTM3U
+#EXTINF:10,
+a.ts
+#EXT-X-ENDLIST
+`,
+			want: []wantFinding{
+				{0, SeverityError, "EXT-X-TARGETDURATION is missing"},
+			},
+		},
+		{
+			name: "missing EXTM3U",
+			playlist: `#EXT-X-TARGETDURATION:10
+#EXTINF:10,
+a.ts
+`,
+			want: []wantFinding{
+				{0, SeverityError, "strict decoding failed"},
+				{1, SeverityError, "the playlist must start with #EXTM3U"},
+			},
+		},
+		{
+			name: "strict decoding failure",
+			playlist: `#EXTM3U
+#EXT-X-TARGETDURATION:10
+#EXTINF:10,
+a.ts
+#EXT-X-BITRATE:fast
+#EXTINF:10,
+b.ts
+#EXT-X-ENDLIST
+`,
+			want: []wantFinding{{5, SeverityError, "strict decoding failed: EXT-X-BITRATE"}},
+		},
+		{
+			name: "media tag after a segment",
+			playlist: `#EXTM3U
+#EXT-X-TARGETDURATION:10
+#EXTINF:10,
+a.ts
+#EXT-X-MEDIA-SEQUENCE:3
+#EXT-X-ENDLIST
+`,
+			want: []wantFinding{{5, SeverityError, "EXT-X-MEDIA-SEQUENCE must appear before the first media segment"}},
+		},
+		{
+			name: "master playlist",
+			playlist: `#EXTM3U
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="English",URI="en.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=1280000,CODECS="avc1.4d401f,mp4a.40.2",AUDIO="aac"
+low.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=2560000,AUDIO="ac3"
+high.m3u8
+#EXTINF:10,
+`,
+			want: []wantFinding{
+				{5, SeverityError, `AUDIO group "ac3" has no EXT-X-MEDIA`},
+				{5, SeverityWarning, "EXT-X-STREAM-INF should have a CODECS attribute"},
+				{7, SeverityError, "EXTINF is a media playlist tag in a master playlist"},
+			},
+		},
+	} {
+		checkFindings(t, tc.name, Lint([]byte(tc.playlist)), tc.want)
+	}
+}
+
+func TestLintReload(t *testing.T) {
+	const prev = `#EXTM3U
+#EXT-X-TARGETDURATION:6
+#EXT-X-MEDIA-SEQUENCE:10
+#EXTINF:6,
+s10.ts
+#EXTINF:6,
+s11.ts
+`
+	for _, tc := range []struct {
+		name string
+		next string
+		want []wantFinding
+	}{
+		{
+			name: "next segment",
+			next: "#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXT-X-MEDIA-SEQUENCE:11\n#EXTINF:6,\ns11.ts\n#EXTINF:6,\ns12.ts\n",
+		},
+		{
+			name: "media sequence going back",
+			next: "#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXT-X-MEDIA-SEQUENCE:9\n#EXTINF:6,\ns9.ts\n#EXTINF:6,\ns10.ts\n",
+			want: []wantFinding{{0, SeverityError, "EXT-X-MEDIA-SEQUENCE went back from 10 to 9"}},
+		},
+		{
+			name: "target duration changed",
+			next: "#EXTM3U\n#EXT-X-TARGETDURATION:8\n#EXT-X-MEDIA-SEQUENCE:10\n#EXTINF:6,\ns10.ts\n#EXTINF:6,\ns11.ts\n",
+			want: []wantFinding{{0, SeverityError, "EXT-X-TARGETDURATION changed from 6 to 8"}},
+		},
+		{
+			name: "segment changed",
+			next: "#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXT-X-MEDIA-SEQUENCE:10\n#EXTINF:6,\ns10.ts\n#EXTINF:6,\nother.ts\n",
+			want: []wantFinding{{0, SeverityError, "segment 11 changed from s11.ts to other.ts"}},
+		},
+		{
+			name: "segments never listed",
+			next: "#EXTM3U\n#EXT-X-TARGETDURATION:6\n#EXT-X-MEDIA-SEQUENCE:14\n#EXTINF:6,\ns14.ts\n",
+			want: []wantFinding{{0, SeverityError, "EXT-X-MEDIA-SEQUENCE skipped from 10 to 14"}},
+		},
+	} {
+		checkFindings(t, tc.name, LintReload([]byte(prev), []byte(tc.next)), tc.want)
+	}
+
+	ended := prev + "#EXT-X-ENDLIST\n"
+	checkFindings(t, "EXT-X-ENDLIST removed", LintReload([]byte(ended), []byte(prev)),
+		[]wantFinding{{0, SeverityError, "EXT-X-ENDLIST was removed"}})
+}
@@ -0,0 +1,373 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/grafov/m3u8"
+)
+
+// liveRecording follows a live media playlist and records its segments as
+// they are published. When the server supports blocking reloads the
+// playlist is requested with _HLS_msn and _HLS_part and answered as soon as
+// the next part (or segment) is available, the EXT-X-PART partial segments
+// are downloaded as they appear and assembled into full segments.
+type liveRecording struct {
+	*download
+	uri       *url.URL          // media playlist
+	vars      map[string]string // variables of the master playlist
+	tmpDir    string
+	msn       uint64  // media sequence number of the segment being recorded
+	part      int     // parts of segment msn already downloaded
+	duration  float64 // seconds recorded
+	updated   bool    // the last playlist version had something new
+	segmentSt time.Time
+	files     []string // empty for the gaps
+	segments  []*m3u8.MediaSegment
+	inits     map[string][]byte // init sections by URI and byte range
+}
+
+// recordLive records the live media playlist p fetched from uri, which
+// imports vars, see Options.Live, and muxes the recording into dest.
+func (dl *download) recordLive(ctx context.Context, uri *url.URL, p *m3u8.MediaPlaylist, vars map[string]string, tmpDir, dest string) error {
+	if dl.opts.Start.set || dl.opts.End.set {
+		return errors.New("a time range can't be recorded from a live stream")
+	}
+	lowLatency := p.PartTarget > 0 && p.ServerControl != nil && p.ServerControl.CanBlockReload
+	if lowLatency {
+		dl.log.Println("Recording Low-Latency HLS stream...")
+	} else {
+		dl.log.Println("Recording live stream...")
+	}
+	dl.transfer = newTransferProgress(nil, 0, dl.emit)
+	runs, err := dl.record(ctx, uri, p, vars, tmpDir)
+	dl.transfer.finish()
+	if err != nil {
+		return err
+	}
+	if len(runs) == 0 {
+		return errors.New("no segment recorded")
+	}
+	if ctx.Err() != nil {
+		// the recording was stopped, what was recorded is still muxed
+		ctx = context.Background()
+	}
+	return dl.stitch(ctx, runs, dest, tmpDir, nil)
+}
+
+// record records the live media playlist p fetched from uri, starting with
+// the segments p lists, until the stream ends, Options.LiveDuration is
+// recorded or ctx is canceled. It returns the runs of the recorded segment
+// files.
+func (dl *download) record(ctx context.Context, uri *url.URL, p *m3u8.MediaPlaylist, vars map[string]string, tmpDir string) ([]*run, error) {
+	rec := &liveRecording{
+		download: dl,
+		uri:      uri,
+		vars:     vars,
+		tmpDir:   tmpDir,
+		msn:      p.SeqNo,
+		inits:    make(map[string][]byte),
+	}
+	defer func() {
+		dl.mu.Lock()
+		dl.result.Segments = len(rec.files)
+		dl.mu.Unlock()
+	}()
+	for {
+		err := rec.update(ctx, p)
+		if err == nil && !p.Closed && !rec.done() {
+			p, err = rec.reload(ctx, p)
+			if err == nil {
+				continue
+			}
+		}
+		if ctx.Err() != nil {
+			err = nil
+		}
+		dl.wall = wallClocks(rec.segments)
+		return dl.runs(rec.files, rec.segments), err
+	}
+}
+
+// done reports whether Options.LiveDuration has been recorded.
+func (rec *liveRecording) done() bool {
+	return rec.opts.LiveDuration > 0 && rec.duration >= rec.opts.LiveDuration.Seconds()
+}
+
+// update records the segments and the parts of p that aren't recorded yet.
+func (rec *liveRecording) update(ctx context.Context, p *m3u8.MediaPlaylist) error {
+	rec.updated = false
+	var (
+		key  *m3u8.Key
+		xmap *m3u8.Map
+		next = p.SeqNo // media sequence number of the segment being produced
+	)
+	for _, seg := range p.Segments {
+		if seg == nil {
+			break
+		}
+		if seg.Key != nil {
+			key = seg.Key
+		}
+		if seg.Map != nil {
+			xmap = seg.Map
+		}
+		next = seg.SeqId + 1
+		if seg.SeqId < rec.msn {
+			continue
+		}
+		if seg.SeqId > rec.msn {
+			rec.log.Printf("Segments %d to %d left the playlist before being recorded\n", rec.msn, seg.SeqId-1)
+			rec.msn, rec.part = seg.SeqId, 0
+		}
+
+		if rec.part > 0 && rec.part <= len(seg.Parts) {
+			for _, part := range seg.Parts[rec.part:] {
+				if err := rec.appendPart(ctx, part); err != nil {
+					return err
+				}
+			}
+		} else if seg.Gap {
+			// finish skips the segment, it has no file
+			rec.part = 0
+			os.Remove(rec.partName())
+		} else {
+			// the parts downloaded so far were removed from the playlist,
+			// the full segment replaces them
+			rec.part = 0
+			rec.begin(seg.URI)
+			data, err := rec.fetchMedia(ctx, seg.URI, seg.Limit, seg.Offset)
+			if err != nil {
+				return fmt.Errorf("segment %d: %w", seg.SeqId, err)
+			}
+			if err := rec.write(data); err != nil {
+				return err
+			}
+		}
+		if err := rec.finish(ctx, seg, key, xmap); err != nil {
+			return err
+		}
+		if rec.done() {
+			return nil
+		}
+	}
+
+	if next == rec.msn && rec.part < len(p.Parts) {
+		for _, part := range p.Parts[rec.part:] {
+			if err := rec.appendPart(ctx, part); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// partName returns the file the segment being recorded is assembled in.
+func (rec *liveRecording) partName() string {
+	return filepath.Join(rec.tmpDir, fmt.Sprintf("%d.ts.part", len(rec.files)))
+}
+
+// begin reports the start of the segment being recorded.
+func (rec *liveRecording) begin(ref string) {
+	rec.segmentSt = time.Now()
+	rec.emit(Event{Type: EventSegmentStarted, Segment: intPtr(len(rec.files)), URL: concatUrl(rec.uri, ref).String()})
+}
+
+// write adds data to the segment being recorded, replacing what was
+// written before when it is a full segment.
+func (rec *liveRecording) write(data []byte) error {
+	flag := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if rec.part == 0 {
+		flag |= os.O_TRUNC
+	}
+	f, err := os.OpenFile(rec.partName(), flag, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	rec.updated = true
+	return f.Close()
+}
+
+// appendPart downloads part and adds it to the segment being recorded.
+// The content of a GAP part is not available and is left out.
+func (rec *liveRecording) appendPart(ctx context.Context, part *m3u8.Part) error {
+	if rec.part == 0 {
+		rec.begin(part.URI)
+	}
+	if !part.Gap {
+		if rec.opts.Verbose {
+			rec.log.Printf("Downloading part %d of segment %d: %s\n", rec.part, rec.msn, part.URI)
+		}
+		data, err := rec.fetchMedia(ctx, part.URI, part.Limit, part.Offset)
+		if err != nil {
+			return fmt.Errorf("part %d of segment %d: %w", rec.part, rec.msn, err)
+		}
+		if err := rec.write(data); err != nil {
+			return err
+		}
+	}
+	rec.part++
+	return nil
+}
+
+// finish completes the recording of seg: the assembled file is decrypted,
+// prefixed with its init section and added to the recorded files.
+func (rec *liveRecording) finish(ctx context.Context, seg *m3u8.MediaSegment, key *m3u8.Key, xmap *m3u8.Map) error {
+	data, err := os.ReadFile(rec.partName())
+	if os.IsNotExist(err) {
+		// the segment or every part of it was a gap
+		if rec.opts.Verbose {
+			rec.log.Printf("Segment %d has no content, skipped\n", seg.SeqId)
+		}
+		rec.files = append(rec.files, "")
+		rec.segments = append(rec.segments, seg)
+		rec.msn, rec.part = seg.SeqId+1, 0
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if key != nil && key.Method == "AES-128" {
+		keyBody, err := rec.key(ctx, concatUrl(rec.uri, key.URI))
+		if err != nil {
+			return err
+		}
+		iv := key.IV
+		if iv == "" {
+			// the media sequence number is the IV when the key has none
+			iv = fmt.Sprintf("%032x", seg.SeqId)
+		}
+		if data, err = decryptAES128CBC(keyBody, iv, data); err != nil {
+			return fmt.Errorf("decrypt segment %d: %w", seg.SeqId, err)
+		}
+	}
+	if xmap != nil {
+		section, err := rec.initSection(ctx, xmap)
+		if err != nil {
+			return err
+		}
+		data = append(append([]byte{}, section...), data...)
+	}
+
+	fName := filepath.Join(rec.tmpDir, fmt.Sprintf("%d.ts", len(rec.files)))
+	if err := os.WriteFile(fName, data, 0644); err != nil {
+		return err
+	}
+	os.Remove(rec.partName())
+	rec.emit(Event{
+		Type:     EventSegmentFinished,
+		Segment:  intPtr(len(rec.files)),
+		Bytes:    int64(len(data)),
+		Duration: seconds(time.Since(rec.segmentSt)),
+	})
+	if rec.opts.Verbose {
+		rec.log.Printf("Segment %d recorded\n", seg.SeqId)
+	}
+	rec.files = append(rec.files, fName)
+	rec.segments = append(rec.segments, seg)
+	rec.duration += seg.Duration
+	rec.msn, rec.part = seg.SeqId+1, 0
+	rec.updated = true
+	return nil
+}
+
+// initSection returns the EXT-X-MAP init section, fetched once.
+func (rec *liveRecording) initSection(ctx context.Context, xmap *m3u8.Map) ([]byte, error) {
+	id := fmt.Sprintf("%s@%d/%d", xmap.URI, xmap.Offset, xmap.Limit)
+	if data, ok := rec.inits[id]; ok {
+		return data, nil
+	}
+	data, err := rec.fetchMedia(ctx, xmap.URI, xmap.Limit, xmap.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("init section: %w", err)
+	}
+	rec.inits[id] = data
+	return data, nil
+}
+
+// fetchMedia downloads the limit bytes at offset of the segment, part or
+// init section ref, the whole resource when limit is 0, retrying with the
+// retry policy.
+func (rec *liveRecording) fetchMedia(ctx context.Context, ref string, limit, offset int64) ([]byte, error) {
+	data, err := rec.fetchBytes(ctx, concatUrl(rec.uri, ref), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	rec.transfer.add(int64(len(data)))
+	return data, nil
+}
+
+// reload fetches the next version of the playlist p. A server supporting
+// blocking reloads is asked for the version listing the next part (or
+// segment) to record and holds the request until it is published, other
+// servers are polled every part target, or target duration, and half as
+// long when the last version brought nothing new.
+func (rec *liveRecording) reload(ctx context.Context, p *m3u8.MediaPlaylist) (*m3u8.MediaPlaylist, error) {
+	target := time.Duration(p.TargetDuration * float64(time.Second))
+	uri := *rec.uri
+	timeout := time.Minute
+	if sc := p.ServerControl; sc != nil && sc.CanBlockReload {
+		query := uri.Query()
+		query.Set("_HLS_msn", strconv.FormatUint(rec.msn, 10))
+		if p.PartTarget > 0 {
+			query.Set("_HLS_part", strconv.Itoa(rec.part))
+		}
+		uri.RawQuery = query.Encode()
+		// the server answers within three target durations
+		if target > 0 {
+			timeout = 3*target + time.Second
+		}
+	} else {
+		wait := target
+		if p.PartTarget > 0 {
+			wait = time.Duration(p.PartTarget * float64(time.Second))
+		}
+		if !rec.updated {
+			wait /= 2
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if rec.opts.Verbose {
+		rec.log.Println("Reloading playlist:", uri.String())
+	}
+
+	var next *m3u8.MediaPlaylist
+	err := backoff.Retry(func() error {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		resp, err := rec.fetch(ctx, &uri)
+		if err != nil {
+			if permanent(err) {
+				return backoff.Permanent(err)
+			}
+			return err
+		}
+		defer resp.Body.Close()
+		// the blocking reload parameters are not imported by QUERYPARAM
+		pl, listType, err := decodePlaylist(resp.Body, rec.uri, rec.vars)
+		if err != nil {
+			return err
+		}
+		if listType != m3u8.MEDIA {
+			return backoff.Permanent(errors.New("media playlist expected, master playlist found"))
+		}
+		next = pl.(*m3u8.MediaPlaylist)
+		return nil
+	}, rec.opts.Retry.backOff(ctx))
+	return next, err
+}
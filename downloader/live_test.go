@@ -0,0 +1,184 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// llOrigin is a Low-Latency HLS origin publishing a stream of segments
+// made of parts. A part is published when a blocking reload asks for it,
+// the stream ends once every part is published.
+type llOrigin struct {
+	perSegment int  // parts of a segment
+	segments   int  // segments of the stream
+	delta      bool // answer the blocking reloads with delta updates
+
+	mu        sync.Mutex
+	published int      // parts published
+	blocked   int      // blocking reloads held until their part was published
+	requests  []string // request URIs
+}
+
+func partBody(msn, part int) string {
+	return fmt.Sprintf("[%d.%d]", msn, part)
+}
+
+func (o *llOrigin) segmentBody(msn int) string {
+	var b strings.Builder
+	for part := 0; part < o.perSegment; part++ {
+		b.WriteString(partBody(msn, part))
+	}
+	return b.String()
+}
+
+func (o *llOrigin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.requests = append(o.requests, r.URL.RequestURI())
+	var msn, part int
+	switch {
+	case r.URL.Path == "/master.m3u8":
+		fmt.Fprint(w, "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=100000\nmedia.m3u8\n")
+	case r.URL.Path == "/media.m3u8":
+		query := r.URL.Query()
+		blocking := query.Has("_HLS_msn")
+		if blocking {
+			msn, _ = strconv.Atoi(query.Get("_HLS_msn"))
+			part, _ = strconv.Atoi(query.Get("_HLS_part"))
+			want := msn*o.perSegment + part + 1
+			if total := o.segments * o.perSegment; want > total {
+				want = total
+			}
+			if want > o.published {
+				// the request is held until the part is produced
+				o.blocked++
+				time.Sleep(5 * time.Millisecond)
+				o.published = want
+			}
+		}
+		fmt.Fprint(w, o.playlist(blocking && o.delta))
+	case strings.HasPrefix(r.URL.Path, "/s"):
+		msn, err := strconv.Atoi(strings.TrimSuffix(r.URL.Path[2:], ".ts"))
+		if err != nil || msn >= o.published/o.perSegment {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, o.segmentBody(msn))
+	case strings.HasPrefix(r.URL.Path, "/p"):
+		msnPart, partPart, _ := strings.Cut(strings.TrimSuffix(r.URL.Path[2:], ".ts"), ".")
+		msn, err := strconv.Atoi(msnPart)
+		if err == nil {
+			part, err = strconv.Atoi(partPart)
+		}
+		if err != nil || msn*o.perSegment+part >= o.published {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, partBody(msn, part))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// playlist returns the media playlist of the parts published, without the
+// older segments when delta is true.
+func (o *llOrigin) playlist(delta bool) string {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:9\n#EXT-X-TARGETDURATION:1\n")
+	b.WriteString("#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,CAN-SKIP-UNTIL=6,PART-HOLD-BACK=0.06\n")
+	b.WriteString("#EXT-X-PART-INF:PART-TARGET=0.02\n#EXT-X-MEDIA-SEQUENCE:0\n")
+	complete := o.published / o.perSegment
+	first := 0
+	if delta && complete > 1 {
+		first = complete - 1
+		fmt.Fprintf(&b, "#EXT-X-SKIP:SKIPPED-SEGMENTS=%d\n", first)
+	}
+	for msn := first; msn <= complete && msn < o.segments; msn++ {
+		parts := o.perSegment
+		if msn == complete {
+			parts = o.published % o.perSegment
+		}
+		for part := 0; part < parts; part++ {
+			fmt.Fprintf(&b, "#EXT-X-PART:DURATION=0.02,URI=\"p%d.%d.ts\"\n", msn, part)
+		}
+		if msn < complete {
+			fmt.Fprintf(&b, "#EXTINF:%.2f,\ns%d.ts\n", 0.02*float64(o.perSegment), msn)
+		}
+	}
+	if complete == o.segments {
+		b.WriteString("#EXT-X-ENDLIST\n")
+	}
+	return b.String()
+}
+
+// recordingMuxer keeps the content of the segments it is given.
+type recordingMuxer struct {
+	segments []string
+}
+
+func (m *recordingMuxer) Mux(ctx context.Context, input *MuxInput) error {
+	for _, name := range input.Segments {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		m.segments = append(m.segments, string(data))
+	}
+	return os.WriteFile(input.Output, nil, 0644)
+}
+
+func TestRecordLowLatency(t *testing.T) {
+	for _, delta := range []bool{false, true} {
+		origin := &llOrigin{perSegment: 4, segments: 4, delta: delta, published: 6}
+		srv := httptest.NewServer(origin)
+		muxer := &recordingMuxer{}
+		d := New(Options{Live: true, Muxer: muxer, TempDir: t.TempDir()})
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		_, err := d.Download(ctx, srv.URL+"/master.m3u8", filepath.Join(t.TempDir(), "out.mp4"))
+		cancel()
+		srv.Close()
+		if err != nil {
+			t.Fatalf("delta %t: %s", delta, err)
+		}
+
+		if len(muxer.segments) != origin.segments {
+			t.Fatalf("delta %t: recorded %d segments, want %d", delta, len(muxer.segments), origin.segments)
+		}
+		for msn, got := range muxer.segments {
+			if want := origin.segmentBody(msn); got != want {
+				t.Errorf("delta %t: segment %d is %q, want %q", delta, msn, got, want)
+			}
+		}
+		// the segment complete in the first playlist is downloaded whole,
+		// the others are assembled from their parts
+		var segmentRequests, reloads []string
+		for _, uri := range origin.requests {
+			switch {
+			case strings.HasPrefix(uri, "/s"):
+				segmentRequests = append(segmentRequests, uri)
+			case strings.HasPrefix(uri, "/media.m3u8?"):
+				reloads = append(reloads, uri)
+			}
+		}
+		if len(segmentRequests) != 1 || segmentRequests[0] != "/s0.ts" {
+			t.Errorf("delta %t: got segment requests %q, want only /s0.ts", delta, segmentRequests)
+		}
+		// every reload asks for the next part, published while it waits
+		wantReloads := origin.segments*origin.perSegment - 6
+		if len(reloads) != wantReloads || origin.blocked != wantReloads {
+			t.Errorf("delta %t: got %d reloads, %d blocked, want %d", delta, len(reloads), origin.blocked, wantReloads)
+		}
+		if len(reloads) > 0 && reloads[0] != "/media.m3u8?_HLS_msn=1&_HLS_part=2" {
+			t.Errorf("delta %t: first reload %s, want part 2 of segment 1", delta, reloads[0])
+		}
+	}
+}
@@ -0,0 +1,406 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/grafov/m3u8"
+)
+
+// Mirror copies the stream at rawUrl into dir as a playable HLS tree. The
+// playlists, segments, keys and initialization sections keep their paths
+// relative to the master playlist (resources of other hosts go under
+// dir/_/<host>), and the playlists are rewritten to point to the local
+// copies. The EXT-X-DEFINE variables are substituted in the copies, which
+// don't depend on the query of their URL. Every variant is copied, or only
+// the one picked by Options.SelectVariant with its renditions when set.
+// Files already in dir are not downloaded again, so an interrupted mirror
+// resumes.
+func (d *Downloader) Mirror(ctx context.Context, rawUrl string, dir string) (*Result, error) {
+	m := &localMirror{
+		Downloader: d,
+		dir:        dir,
+		files:      make(map[string]string),
+		result:     Result{URL: rawUrl},
+	}
+	err := m.run(ctx, rawUrl)
+	return &m.result, err
+}
+
+type localMirror struct {
+	*Downloader
+	dir   string
+	root  *url.URL          // master playlist
+	files map[string]string // url to local path of the files to download
+	order []string          // urls of files in discovery order
+
+	mu     sync.Mutex
+	result Result
+}
+
+func (m *localMirror) run(ctx context.Context, rawUrl string) error {
+	uri, err := url.Parse(rawUrl)
+	if err != nil {
+		return err
+	}
+	m.root = uri
+	data, err := m.get(ctx, uri)
+	if err != nil {
+		return err
+	}
+	p, listType, err := decodePlaylist(bytes.NewReader(data), uri, nil)
+	if err != nil {
+		return err
+	}
+	vars := definedVariables(p)
+
+	name := path.Base(uri.Path)
+	if path.Ext(name) != ".m3u8" {
+		name = "index.m3u8"
+	}
+	m.result.Output = filepath.Join(m.dir, name)
+
+	if listType == m3u8.MEDIA {
+		m.log.Println("Mirroring media playlist...")
+		if err := m.writePlaylist(name, m.rewrite(data, uri, name, vars)); err != nil {
+			return err
+		}
+		return m.downloadFiles(ctx)
+	}
+
+	// the variant playlists and renditions to copy
+	keep := func(tag, ref string) bool { return true }
+	if m.opts.SelectVariant != nil {
+		variants := Variants(p.(*m3u8.MasterPlaylist))
+		i, err := m.opts.SelectVariant(variants)
+		if err != nil {
+			return err
+		}
+		if i < 0 || i >= len(variants) {
+			return fmt.Errorf("invalid variant id %d", i)
+		}
+		variant := variants[i]
+		m.result.Variant = VariantName(variant.Variant)
+		groups := map[string]bool{
+			variant.Audio:     true,
+			variant.Video:     true,
+			variant.Subtitles: true,
+		}
+		keep = func(tag, ref string) bool {
+			switch {
+			case strings.HasPrefix(tag, "#EXT-X-STREAM-INF"):
+				return ref == variant.URI
+			case strings.HasPrefix(tag, "#EXT-X-MEDIA:"):
+				id := parseAttributes(strings.TrimPrefix(tag, "#EXT-X-MEDIA:"))["GROUP-ID"]
+				return id != "" && groups[id]
+			case strings.HasPrefix(tag, "#EXT-X-I-FRAME-STREAM-INF"):
+				return false
+			}
+			return true
+		}
+	}
+
+	var playlists []string      // urls of the media playlists to copy
+	refs := map[string]string{} // url to local path of the media playlists
+	master := m.rewriteMaster(data, uri, vars, keep, func(u *url.URL) string {
+		local := m.localPath(u)
+		if _, ok := refs[u.String()]; !ok {
+			refs[u.String()] = local
+			playlists = append(playlists, u.String())
+		}
+		return relativeRef(name, local)
+	})
+
+	for _, ref := range playlists {
+		local := refs[ref]
+		u, _ := url.Parse(ref)
+		if m.opts.Verbose {
+			m.log.Println("Mirroring media playlist:", u)
+		}
+		data, err := m.get(ctx, u)
+		if err != nil {
+			return err
+		}
+		media, _, err := decodePlaylist(bytes.NewReader(data), u, vars)
+		if err != nil {
+			return err
+		}
+		if err := m.writePlaylist(local, m.rewrite(data, u, local, definedVariables(media))); err != nil {
+			return err
+		}
+	}
+	if err := m.downloadFiles(ctx); err != nil {
+		return err
+	}
+	return m.writePlaylist(name, master)
+}
+
+// rewriteMaster replaces the URIs of a master playlist with the result of
+// local. The entries keep rejects are dropped with their tag. The
+// variables of vars are substituted, see substituteLine.
+func (m *localMirror) rewriteMaster(data []byte, base *url.URL, vars map[string]string, keep func(tag, ref string) bool, local func(u *url.URL) string) []byte {
+	lines := strings.Split(string(data), "\n")
+	out := make([]string, 0, len(lines))
+	for _, raw := range lines {
+		line, ok := substituteLine(raw, vars)
+		if !ok {
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			out = append(out, line)
+		case !strings.HasPrefix(trimmed, "#"):
+			// the URI line of the previous EXT-X-STREAM-INF
+			tag := ""
+			if len(out) > 0 {
+				tag = out[len(out)-1]
+			}
+			if !keep(tag, trimmed) {
+				if strings.HasPrefix(tag, "#EXT-X-STREAM-INF") {
+					out = out[:len(out)-1]
+				}
+				continue
+			}
+			out = append(out, local(ResolveURL(base, trimmed)))
+		case strings.Contains(trimmed, `URI="`):
+			ref := parseAttributes(trimmed[strings.IndexByte(trimmed, ':')+1:])["URI"]
+			if !remoteRef(ref) {
+				out = append(out, line)
+				continue
+			}
+			if !keep(trimmed, ref) {
+				continue
+			}
+			if strings.HasPrefix(trimmed, "#EXT-X-MEDIA:") || strings.HasPrefix(trimmed, "#EXT-X-I-FRAME-STREAM-INF:") {
+				out = append(out, replaceURIAttr(trimmed, local(ResolveURL(base, ref))))
+			} else {
+				out = append(out, replaceURIAttr(trimmed, m.addFile(ResolveURL(base, ref), "")))
+			}
+		default:
+			out = append(out, line)
+		}
+	}
+	return []byte(strings.Join(out, "\n"))
+}
+
+// rewrite replaces the URIs of the media playlist written at local with
+// the local copies of the files it references, queued for download. The
+// variables of vars are substituted, see substituteLine.
+func (m *localMirror) rewrite(data []byte, base *url.URL, local string, vars map[string]string) []byte {
+	lines := strings.Split(string(data), "\n")
+	out := make([]string, 0, len(lines))
+	for _, raw := range lines {
+		line, ok := substituteLine(raw, vars)
+		if !ok {
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+		case !strings.HasPrefix(trimmed, "#"):
+			line = m.addFile(ResolveURL(base, trimmed), local)
+		case strings.Contains(trimmed, `URI="`):
+			ref := parseAttributes(trimmed[strings.IndexByte(trimmed, ':')+1:])["URI"]
+			if remoteRef(ref) {
+				line = replaceURIAttr(trimmed, m.addFile(ResolveURL(base, ref), local))
+			}
+		}
+		out = append(out, line)
+	}
+	return []byte(strings.Join(out, "\n"))
+}
+
+// substituteLine replaces the variable references of a playlist line with
+// the values of vars, the EXT-X-DEFINE variables of the decoded playlist.
+// It reports false for the EXT-X-DEFINE tags, left out of the copy: once
+// substituted nothing refers to them, and a QUERYPARAM one would require
+// the query of the original URL.
+func substituteLine(line string, vars map[string]string) (string, bool) {
+	if strings.HasPrefix(strings.TrimSpace(line), "#EXT-X-DEFINE:") {
+		return "", false
+	}
+	return m3u8.SubstituteVariables(line, vars), true
+}
+
+// definedVariables returns the EXT-X-DEFINE variables of a decoded
+// playlist.
+func definedVariables(p m3u8.Playlist) map[string]string {
+	switch p := p.(type) {
+	case *m3u8.MasterPlaylist:
+		return p.DefinedVariables()
+	case *m3u8.MediaPlaylist:
+		return p.DefinedVariables()
+	}
+	return nil
+}
+
+// addFile queues u for download and returns its local path relative to
+// the playlist written at from.
+func (m *localMirror) addFile(u *url.URL, from string) string {
+	local, ok := m.files[u.String()]
+	if !ok {
+		local = m.localPath(u)
+		m.files[u.String()] = local
+		m.order = append(m.order, u.String())
+	}
+	return relativeRef(from, local)
+}
+
+// remoteRef reports whether ref can be fetched, unlike the skd: or data:
+// URIs of DRM keys.
+func remoteRef(ref string) bool {
+	u, err := url.Parse(ref)
+	return err == nil && (u.Scheme == "" || u.Scheme == "http" || u.Scheme == "https")
+}
+
+// localPath returns the path in the mirror of u, relative to dir.
+func (m *localMirror) localPath(u *url.URL) string {
+	rootDir := path.Dir(m.root.Path)
+	if !strings.HasSuffix(rootDir, "/") {
+		rootDir += "/"
+	}
+	var p string
+	if u.Host == m.root.Host && strings.HasPrefix(u.Path, rootDir) {
+		p = strings.TrimPrefix(u.Path, rootDir)
+	} else {
+		p = path.Join("_", u.Host, u.Path)
+	}
+	p = strings.TrimLeft(path.Clean("/"+p), "/")
+	if u.RawQuery != "" {
+		// URIs differing by their query only are different files
+		sum := sha1.Sum([]byte(u.RawQuery))
+		ext := path.Ext(p)
+		p = strings.TrimSuffix(p, ext) + "-" + hex.EncodeToString(sum[:4]) + ext
+	}
+	return p
+}
+
+// relativeRef returns the URI of target in the playlist written at from,
+// both relative to the mirror directory.
+func relativeRef(from, target string) string {
+	rel, err := filepath.Rel(filepath.Dir(filepath.FromSlash(from)), filepath.FromSlash(target))
+	if err != nil {
+		return target
+	}
+	return filepath.ToSlash(rel)
+}
+
+// replaceURIAttr replaces the URI attribute of a tag line.
+func replaceURIAttr(line, uri string) string {
+	start := strings.Index(line, `URI="`) + len(`URI="`)
+	end := strings.IndexByte(line[start:], '"')
+	if end < 0 {
+		return line
+	}
+	return line[:start] + uri + line[start+end:]
+}
+
+func (m *localMirror) writePlaylist(local string, data []byte) error {
+	fName := filepath.Join(m.dir, filepath.FromSlash(local))
+	if err := os.MkdirAll(filepath.Dir(fName), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(fName, data, 0644)
+}
+
+// downloadFiles downloads the queued files, skipping the ones already in
+// the mirror.
+func (m *localMirror) downloadFiles(ctx context.Context) error {
+	m.result.Segments = len(m.order)
+	m.log.Printf("Mirroring %d files...\n", len(m.order))
+
+	workers := m.opts.Workers
+	if workers <= 0 {
+		workers = 4
+	}
+	urls := make(chan string)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ref := range urls {
+				size, err := m.downloadFile(ctx, ref, filepath.Join(m.dir, filepath.FromSlash(m.files[ref])))
+				m.mu.Lock()
+				if err != nil {
+					m.result.Failed++
+					if !errors.Is(err, context.Canceled) {
+						m.log.Printf("download %s failed: %s\n", ref, err)
+					}
+				} else {
+					m.result.Downloaded++
+					m.result.Bytes += size
+				}
+				m.mu.Unlock()
+			}
+		}()
+	}
+	for _, ref := range m.order {
+		select {
+		case urls <- ref:
+		case <-ctx.Done():
+		}
+	}
+	close(urls)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if m.result.Failed > 0 {
+		return fmt.Errorf("%d of %d files failed", m.result.Failed, len(m.order))
+	}
+	return nil
+}
+
+// downloadFile downloads ref to fName unless it exists, through a part
+// file renamed once complete.
+func (m *localMirror) downloadFile(ctx context.Context, ref, fName string) (int64, error) {
+	if info, err := os.Stat(fName); err == nil {
+		return info.Size(), nil
+	}
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(fName), 0755); err != nil {
+		return 0, err
+	}
+	u, err := url.Parse(ref)
+	if err != nil {
+		return 0, err
+	}
+	if m.opts.Verbose {
+		m.log.Println("Downloading:", u)
+	}
+	partName := fName + ".part"
+	err = backoff.Retry(func() error {
+		err := m.downloadSegment(ctx, u, partName, nil, 0)
+		if permanent(err) {
+			return backoff.Permanent(err)
+		}
+		return err
+	}, m.opts.Retry.backOff(ctx))
+	if err != nil {
+		os.Remove(partName)
+		return 0, err
+	}
+	if err := os.Rename(partName, fName); err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(fName)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
@@ -0,0 +1,111 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// defineOrigin serves a stream whose URIs are built from EXT-X-DEFINE
+// variables, every request needing the token of the master playlist URL.
+func defineOrigin(t *testing.T) *httptest.Server {
+	files := map[string]string{
+		"/live/master.m3u8": `#EXTM3U
+#EXT-X-VERSION:8
+#EXT-X-DEFINE:NAME="variant",VALUE="v1"
+#EXT-X-DEFINE:QUERYPARAM="token"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="en",URI="audio/{$variant}.m3u8?token={$token}"
+#EXT-X-STREAM-INF:BANDWIDTH=1000000,AUDIO="aac"
+{$variant}/media.m3u8?token={$token}
+`,
+		"/live/v1/media.m3u8": `#EXTM3U
+#EXT-X-VERSION:8
+#EXT-X-TARGETDURATION:4
+#EXT-X-DEFINE:IMPORT="variant"
+#EXT-X-DEFINE:QUERYPARAM="token"
+#EXT-X-MAP:URI="init-{$variant}.mp4?token={$token}"
+#EXTINF:4,
+seg0-{$variant}.m4s?token={$token}
+#EXTINF:4,
+seg1-{$variant}.m4s?token={$token}
+#EXT-X-ENDLIST
+`,
+		"/live/audio/v1.m3u8": `#EXTM3U
+#EXT-X-VERSION:8
+#EXT-X-TARGETDURATION:4
+#EXT-X-DEFINE:NAME="dir",VALUE="aac"
+#EXTINF:4,
+{$dir}/a0.aac
+#EXT-X-ENDLIST
+`,
+		"/live/v1/init-v1.mp4":   "init",
+		"/live/v1/seg0-v1.m4s":   "segment 0",
+		"/live/v1/seg1-v1.m4s":   "segment 1",
+		"/live/audio/aac/a0.aac": "audio 0",
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := files[r.URL.Path]
+		// the audio segments don't take the token
+		if !ok || (r.URL.Query().Get("token") != "abc" && !strings.HasSuffix(r.URL.Path, ".aac")) {
+			t.Errorf("unexpected request %s", r.URL)
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, body)
+	}))
+}
+
+func TestMirrorDefines(t *testing.T) {
+	srv := defineOrigin(t)
+	defer srv.Close()
+	dir := t.TempDir()
+	res, err := New(Options{}).Mirror(context.Background(), srv.URL+"/live/master.m3u8?token=abc", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Downloaded != 4 || res.Failed != 0 {
+		t.Errorf("downloaded %d files, %d failed, want 4", res.Downloaded, res.Failed)
+	}
+
+	// the copies refer to the local files, without variables
+	playlists, files := 0, 0
+	err = filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil || filepath.Ext(name) != ".m3u8" {
+			return err
+		}
+		playlists++
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if strings.Contains(string(data), "{$") || strings.Contains(string(data), "EXT-X-DEFINE") {
+			t.Errorf("%s keeps variables:\n%s", name, data)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			ref := line
+			if strings.Contains(line, `URI="`) {
+				ref = parseAttributes(line[strings.IndexByte(line, ':')+1:])["URI"]
+			} else if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			files++
+			if _, err := os.Stat(filepath.Join(filepath.Dir(name), filepath.FromSlash(ref))); err != nil {
+				t.Errorf("%s: %s", filepath.Base(name), err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the master playlist refers to the 2 media playlists, which refer to
+	// the initialization section and the 3 segments
+	if playlists != 3 || files != 6 {
+		t.Errorf("got %d playlists referring to %d files, want 3 and 6", playlists, files)
+	}
+}
@@ -0,0 +1,87 @@
+package downloader
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/grafov/m3u8"
+)
+
+// groupVariants folds variants with identical parameters into groups,
+// preserving the order in which they first appear.
+func groupVariants(variants []*m3u8.Variant) []*Variant {
+	groups := []*Variant{}
+	byKey := make(map[string]*Variant)
+	for _, v := range variants {
+		key := variantKey(v)
+		if g, ok := byKey[key]; ok {
+			if v.URI != g.URI {
+				g.Mirrors = append(g.Mirrors, v)
+			}
+			continue
+		}
+		g := &Variant{Variant: v}
+		byKey[key] = g
+		groups = append(groups, g)
+	}
+	return groups
+}
+
+func variantKey(v *m3u8.Variant) string {
+	p := v.VariantParams
+	return fmt.Sprintf(
+		"%t|%d|%d|%s|%s|%s|%s|%s|%s|%s|%g|%s|%s",
+		p.Iframe,
+		p.Bandwidth,
+		p.AverageBandwidth,
+		p.Codecs,
+		p.Resolution,
+		p.Audio,
+		p.Video,
+		p.Subtitles,
+		p.Captions,
+		p.Name,
+		p.FrameRate,
+		p.VideoRange,
+		p.HDCPLevel,
+	)
+}
+
+// withHost returns a copy of uri pointing to another host. The host may
+// be given with a scheme (https://cdn2.example.com) to switch it too.
+func withHost(uri *url.URL, host string) *url.URL {
+	rewritten := *uri
+	if strings.Contains(host, "://") {
+		if h, err := url.Parse(host); err == nil {
+			rewritten.Scheme = h.Scheme
+			rewritten.Host = h.Host
+			return &rewritten
+		}
+	}
+	rewritten.Host = host
+	return &rewritten
+}
+
+// segmentUrls resolves a segment URI against the variant playlist and each
+// of its mirrors, followed by the hosts of Options.Mirrors, without duplicates.
+// The first url is always the primary one.
+func segmentUrls(input *downloadInput, path string) []*url.URL {
+	primary := concatUrl(input.variantUrl, path)
+	urls := []*url.URL{primary}
+	seen := map[string]bool{primary.String(): true}
+	add := func(u *url.URL) {
+		if u == nil || seen[u.String()] {
+			return
+		}
+		seen[u.String()] = true
+		urls = append(urls, u)
+	}
+	for _, base := range input.mirrorUrls {
+		add(concatUrl(base, path))
+	}
+	for _, host := range input.mirrorHosts {
+		add(withHost(primary, host))
+	}
+	return urls
+}
@@ -0,0 +1,177 @@
+package downloader
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/grafov/m3u8"
+)
+
+func TestGroupVariants(t *testing.T) {
+	variant := func(uri string, bandwidth uint32, resolution string) *m3u8.Variant {
+		return &m3u8.Variant{URI: uri, VariantParams: m3u8.VariantParams{Bandwidth: bandwidth, Resolution: resolution}}
+	}
+	iframe := variant("a/iframes.m3u8", 1000, "1280x720")
+	iframe.Iframe = true
+	for _, tc := range []struct {
+		name     string
+		variants []*m3u8.Variant
+		want     map[string][]string // the URI of each group and of its mirrors, in order
+	}{
+		{
+			name: "redundant streams",
+			variants: []*m3u8.Variant{
+				variant("a/720.m3u8", 1000, "1280x720"),
+				variant("a/360.m3u8", 500, "640x360"),
+				variant("b/720.m3u8", 1000, "1280x720"),
+				variant("b/360.m3u8", 500, "640x360"),
+				variant("c/720.m3u8", 1000, "1280x720"),
+			},
+			want: map[string][]string{
+				"a/720.m3u8": {"b/720.m3u8", "c/720.m3u8"},
+				"a/360.m3u8": {"b/360.m3u8"},
+			},
+		},
+		{
+			// a variant listed twice isn't its own mirror
+			name: "same URI",
+			variants: []*m3u8.Variant{
+				variant("a/720.m3u8", 1000, "1280x720"),
+				variant("a/720.m3u8", 1000, "1280x720"),
+			},
+			want: map[string][]string{"a/720.m3u8": nil},
+		},
+		{
+			name: "different parameters",
+			variants: []*m3u8.Variant{
+				variant("a/720.m3u8", 1000, "1280x720"),
+				variant("b/720.m3u8", 1001, "1280x720"),
+				variant("c/720.m3u8", 1000, "1920x1080"),
+				iframe,
+			},
+			want: map[string][]string{"a/720.m3u8": nil, "b/720.m3u8": nil, "c/720.m3u8": nil, "a/iframes.m3u8": nil},
+		},
+	} {
+		groups := groupVariants(tc.variants)
+		got := make(map[string][]string)
+		var order []string
+		for _, g := range groups {
+			var mirrors []string
+			for _, m := range g.Mirrors {
+				mirrors = append(mirrors, m.URI)
+			}
+			got[g.URI] = mirrors
+			order = append(order, g.URI)
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got groups %v, want %v", tc.name, got, tc.want)
+		}
+		// the groups keep the order of their first variant
+		var want []string
+		for _, v := range tc.variants {
+			if _, ok := tc.want[v.URI]; ok && !contains(want, v.URI) {
+				want = append(want, v.URI)
+			}
+		}
+		if !reflect.DeepEqual(order, want) {
+			t.Errorf("%s: got order %v, want %v", tc.name, order, want)
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func TestWithHost(t *testing.T) {
+	uri, _ := url.Parse("https://cdn1.example.com/live/seg1.ts?token=x")
+	for host, want := range map[string]string{
+		"cdn2.example.com":          "https://cdn2.example.com/live/seg1.ts?token=x",
+		"cdn2.example.com:8443":     "https://cdn2.example.com:8443/live/seg1.ts?token=x",
+		"http://cdn2.example.com":   "http://cdn2.example.com/live/seg1.ts?token=x",
+		"https://cdn2.example.com/": "https://cdn2.example.com/live/seg1.ts?token=x",
+	} {
+		if got := withHost(uri, host).String(); got != want {
+			t.Errorf("%s: got %s, want %s", host, got, want)
+		}
+	}
+	if uri.Host != "cdn1.example.com" {
+		t.Errorf("the url was changed to %s", uri)
+	}
+}
+
+func TestSegmentUrls(t *testing.T) {
+	parse := func(s string) *url.URL {
+		u, _ := url.Parse(s)
+		return u
+	}
+	for _, tc := range []struct {
+		name  string
+		input *downloadInput
+		path  string
+		want  []string
+	}{
+		{
+			name:  "primary only",
+			input: &downloadInput{variantUrl: parse("https://a.example.com/v/720.m3u8")},
+			path:  "seg1.ts",
+			want:  []string{"https://a.example.com/v/seg1.ts"},
+		},
+		{
+			// the redundant streams, then the hosts
+			name: "mirrors",
+			input: &downloadInput{
+				variantUrl:  parse("https://a.example.com/v/720.m3u8"),
+				mirrorUrls:  []*url.URL{parse("https://b.example.com/w/720.m3u8"), parse("https://c.example.com/v/720.m3u8")},
+				mirrorHosts: []string{"d.example.com", "http://e.example.com"},
+			},
+			path: "seg1.ts",
+			want: []string{
+				"https://a.example.com/v/seg1.ts",
+				"https://b.example.com/w/seg1.ts",
+				"https://c.example.com/v/seg1.ts",
+				"https://d.example.com/v/seg1.ts",
+				"http://e.example.com/v/seg1.ts",
+			},
+		},
+		{
+			name: "duplicates",
+			input: &downloadInput{
+				variantUrl:  parse("https://a.example.com/v/720.m3u8"),
+				mirrorUrls:  []*url.URL{parse("https://a.example.com/v/other.m3u8"), parse("https://b.example.com/v/720.m3u8")},
+				mirrorHosts: []string{"a.example.com", "b.example.com", "c.example.com"},
+			},
+			path: "seg1.ts",
+			want: []string{
+				"https://a.example.com/v/seg1.ts",
+				"https://b.example.com/v/seg1.ts",
+				"https://c.example.com/v/seg1.ts",
+			},
+		},
+		{
+			// an absolute segment URI is the same on every redundant stream
+			name: "absolute segment",
+			input: &downloadInput{
+				variantUrl:  parse("https://a.example.com/v/720.m3u8"),
+				mirrorUrls:  []*url.URL{parse("https://b.example.com/v/720.m3u8")},
+				mirrorHosts: []string{"c.example.com"},
+			},
+			path: "https://s.example.com/seg1.ts",
+			want: []string{"https://s.example.com/seg1.ts", "https://c.example.com/seg1.ts"},
+		},
+	} {
+		var got []string
+		for _, u := range segmentUrls(tc.input, tc.path) {
+			got = append(got, u.String())
+		}
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Muxer joins the downloaded segments into the output file.
+type Muxer interface {
+	Mux(ctx context.Context, input *MuxInput) error
+}
+
+// MuxInput describes the files to join.
+type MuxInput struct {
+	Segments  []string  // segment files in playback order
+	Durations []float64 // seconds each segment spans in the output, longer than the segment over a gap; taken from the files when nil
+	Breaks    []int     // indexes of the segments after a discontinuity, whose timestamps don't follow the ones before
+	Output    string
+	TempDir   string            // scratch directory, removed after the download
+	Start     float64           // seconds to cut from the beginning, set with Options.Precise
+	Length    float64           // seconds to keep, 0 keeps everything
+	Metadata  map[string]string // global metadata of the output, like title
+	Chapters  []Chapter         // chapters of the output, after trimming to Start
+}
+
+// runs returns the runs of segments between the breaks, with their
+// durations when given.
+func (input *MuxInput) runs() (files [][]string, durations [][]float64) {
+	start := 0
+	ends := append(append([]int{}, input.Breaks...), len(input.Segments))
+	for _, end := range ends {
+		files = append(files, input.Segments[start:end])
+		if len(input.Durations) > 0 {
+			durations = append(durations, input.Durations[start:end])
+		} else {
+			durations = append(durations, nil)
+		}
+		start = end
+	}
+	return files, durations
+}
+
+// FFmpeg muxes the segments with the ffmpeg concat demuxer.
+type FFmpeg struct {
+	// Path of the ffmpeg executable, looked up in PATH when empty.
+	Path string
+}
+
+func (f *FFmpeg) path() string {
+	if f.Path == "" {
+		return "ffmpeg"
+	}
+	return f.Path
+}
+
+// Mux concatenates the segments into the output. Each run of segments
+// between discontinuities is first joined on its own into a MPEG-TS file
+// whose timestamps start at zero, and the runs are then concatenated one
+// after the other.
+func (f *FFmpeg) Mux(ctx context.Context, input *MuxInput) error {
+	var (
+		list string
+		err  error
+	)
+	if len(input.Breaks) == 0 {
+		list, err = writeConcatList(input.TempDir, input.Segments, input.Durations)
+	} else {
+		list, err = f.muxRuns(ctx, input)
+	}
+	if err != nil {
+		return err
+	}
+
+	// concat segments using ffmpeg
+	args := []string{"-v", "error", "-y"}
+	if input.Start > 0 {
+		// an input option, as an output option it would also shift the
+		// chapters, already in output time
+		args = append(args, "-ss", strconv.FormatFloat(input.Start, 'f', 3, 64))
+	}
+	args = append(args, "-f", "concat", "-safe", "0", "-i", list)
+	if len(input.Chapters) > 0 {
+		meta, err := writeChapters(input.TempDir, input.Chapters)
+		if err != nil {
+			return err
+		}
+		args = append(args, "-f", "ffmetadata", "-i", meta, "-map_chapters", "1")
+	}
+	if input.Start > 0 || input.Length > 0 {
+		// cutting between keyframes needs the video to be encoded again
+		if input.Length > 0 {
+			args = append(args, "-t", strconv.FormatFloat(input.Length, 'f', 3, 64))
+		}
+	} else {
+		args = append(args, "-c", "copy")
+	}
+	keys := make([]string, 0, len(input.Metadata))
+	for k := range input.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		args = append(args, "-metadata", k+"="+input.Metadata[k])
+	}
+	args = append(args, input.Output)
+	return f.run(ctx, args)
+}
+
+// muxRuns joins every run of segments of input into a MPEG-TS file and
+// returns the concat list of these files.
+func (f *FFmpeg) muxRuns(ctx context.Context, input *MuxInput) (string, error) {
+	runs, durations := input.runs()
+	runFiles := make([]string, len(runs))
+	for i, files := range runs {
+		list, err := writeConcatList(input.TempDir, files, durations[i])
+		if err != nil {
+			return "", err
+		}
+		runFiles[i] = filepath.Join(input.TempDir, fmt.Sprintf("run%d.ts", i))
+		// the timestamps of every run start at zero, the concat demuxer
+		// then places each run right after the previous one
+		args := []string{"-v", "error", "-y", "-f", "concat", "-safe", "0", "-i", list,
+			"-c", "copy", "-avoid_negative_ts", "make_zero", "-f", "mpegts", runFiles[i]}
+		if err := f.run(ctx, args); err != nil {
+			return "", fmt.Errorf("run %d: %w", i, err)
+		}
+	}
+	return writeConcatList(input.TempDir, runFiles, nil)
+}
+
+func (f *FFmpeg) run(ctx context.Context, args []string) error {
+	output, err := exec.CommandContext(ctx, f.path(), args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ffmpeg: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// frame writes the first video frame of input into the JPEG output, scaled
+// to width x height.
+func (f *FFmpeg) frame(ctx context.Context, input, output string, width, height int) error {
+	return f.run(ctx, []string{"-v", "error", "-y", "-i", input, "-frames:v", "1",
+		"-vf", fmt.Sprintf("scale=%d:%d", width, height), "-q:v", "3", "-update", "1", output})
+}
+
+// tile writes into the JPEG output a sheet of columns x rows images, read
+// from the image2 pattern from its number start on.
+func (f *FFmpeg) tile(ctx context.Context, pattern string, start, columns, rows int, output string) error {
+	return f.run(ctx, []string{"-v", "error", "-y", "-start_number", strconv.Itoa(start), "-i", pattern,
+		"-frames:v", "1", "-vf", fmt.Sprintf("tile=%dx%d", columns, rows), "-q:v", "3", "-update", "1", output})
+}
+
+// writeConcatList writes the list of files read by the ffmpeg concat
+// demuxer in dir and returns its name.
+func writeConcatList(dir string, files []string, durations []float64) (string, error) {
+	listF, err := os.CreateTemp(dir, "list")
+	if err != nil {
+		return "", err
+	}
+	defer listF.Close()
+	str := ""
+	for i, fileName := range files {
+		str += fmt.Sprintf("file '%s'\n", fileName)
+		if i < len(durations) {
+			// the next file starts after the duration, leaving a gap in
+			// the timestamps when it is longer than the file
+			str += fmt.Sprintf("duration %.3f\n", durations[i])
+		}
+	}
+	if _, err := listF.WriteString(str); err != nil {
+		return "", err
+	}
+	return listF.Name(), nil
+}
+
+// writeChapters writes chapters in a ffmetadata file in dir and returns
+// its name.
+func writeChapters(dir string, chapters []Chapter) (string, error) {
+	metaF, err := os.CreateTemp(dir, "chapters")
+	if err != nil {
+		return "", err
+	}
+	defer metaF.Close()
+	escape := strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`, "#", `\#`, "\n", `\`+"\n")
+	str := ";FFMETADATA1\n"
+	for _, c := range chapters {
+		str += fmt.Sprintf("[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n",
+			int64(c.Start*1000), int64(c.End*1000), escape.Replace(c.Title))
+	}
+	if _, err := metaF.WriteString(str); err != nil {
+		return "", err
+	}
+	return metaF.Name(), nil
+}
@@ -0,0 +1,144 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/grafov/m3u8"
+)
+
+// FetchPlaylist fetches and decodes the master or media playlist at uri.
+// Its EXT-X-DEFINE QUERYPARAM variables take the query parameters of uri.
+func (d *Downloader) FetchPlaylist(ctx context.Context, uri *url.URL) (m3u8.Playlist, m3u8.ListType, error) {
+	return d.fetchPlaylist(ctx, uri, nil, nil)
+}
+
+// FetchMediaPlaylist fetches and decodes the media playlist at uri of a
+// variant or rendition of masterpl, whose variables it may import.
+func (d *Downloader) FetchMediaPlaylist(ctx context.Context, uri *url.URL, masterpl *m3u8.MasterPlaylist) (*m3u8.MediaPlaylist, error) {
+	p, listType, err := d.fetchPlaylist(ctx, uri, masterpl.DefinedVariables(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if listType != m3u8.MEDIA {
+		return nil, errNotMedia
+	}
+	return p.(*m3u8.MediaPlaylist), nil
+}
+
+// fetchPlaylist fetches the playlist at uri and decodes it as it is
+// received, the body is never held whole. The values of its EXT-X-DEFINE
+// variables are imported from vars, the variables of the master playlist,
+// and the query parameters of uri. When watch is not nil the body is read
+// through it.
+func (d *Downloader) fetchPlaylist(ctx context.Context, uri *url.URL, vars map[string]string, watch *tagReader) (m3u8.Playlist, m3u8.ListType, error) {
+	resp, err := d.fetch(ctx, uri)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	var body io.Reader = resp.Body
+	if watch != nil {
+		watch.r = body
+		body = watch
+	}
+	return decodePlaylist(body, uri, vars)
+}
+
+// decodePlaylist decodes the playlist fetched from uri, see fetchPlaylist.
+func decodePlaylist(r io.Reader, uri *url.URL, vars map[string]string) (m3u8.Playlist, m3u8.ListType, error) {
+	return m3u8.DecodeWithVariables(r, false, m3u8.Variables{Imports: vars, Query: uri.Query()})
+}
+
+// tagReader reads r and records whether tag, like a tag the decoder
+// ignores, appears in what was read.
+type tagReader struct {
+	r     io.Reader
+	tag   []byte
+	tail  []byte // end of the previous read, for a tag split between reads
+	found bool
+}
+
+func (t *tagReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if !t.found && n > 0 {
+		buf := append(t.tail, p[:n]...)
+		t.found = bytes.Contains(buf, t.tag)
+		if keep := len(t.tag) - 1; len(buf) > keep {
+			buf = buf[len(buf)-keep:]
+		}
+		t.tail = append(t.tail[:0], buf...)
+	}
+	return n, err
+}
+
+// parseAttributes parses the attribute list of a tag, KEY=VALUE pairs
+// separated by commas with quoted strings unquoted, for the tags the m3u8
+// package doesn't decode or that are read from the raw playlist.
+func parseAttributes(line string) map[string]string {
+	params := make(map[string]string)
+	for line != "" {
+		key, rest, ok := strings.Cut(line, "=")
+		if !ok {
+			break
+		}
+		var value string
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				value, rest = rest[1:], ""
+			} else {
+				value, rest = rest[1:end+1], rest[end+2:]
+			}
+			_, rest, _ = strings.Cut(rest, ",")
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+		}
+		params[strings.TrimSpace(key)] = value
+		line = rest
+	}
+	return params
+}
+
+// Variants groups the redundant variants of a master playlist and sorts
+// them by descending bandwidth, the order SelectVariant indexes. The
+// EXT-X-I-FRAME-STREAM-INF variants, which can't be played on their own,
+// are left out, see IFrameVariants.
+func Variants(masterpl *m3u8.MasterPlaylist) []*Variant {
+	return sortedVariants(masterpl, false)
+}
+
+// IFrameVariants is Variants for the EXT-X-I-FRAME-STREAM-INF variants of
+// a master playlist.
+func IFrameVariants(masterpl *m3u8.MasterPlaylist) []*Variant {
+	return sortedVariants(masterpl, true)
+}
+
+func sortedVariants(masterpl *m3u8.MasterPlaylist, iframe bool) []*Variant {
+	var list []*m3u8.Variant
+	for _, v := range masterpl.Variants {
+		if v != nil && v.Iframe == iframe {
+			list = append(list, v)
+		}
+	}
+	variants := groupVariants(list)
+	sort.SliceStable(variants, func(i, j int) bool {
+		return variants[i].VariantParams.Bandwidth > variants[j].VariantParams.Bandwidth
+	})
+	return variants
+}
+
+// ResolveURL resolves the URI of a playlist entry against the playlist
+// URL, the way the downloader does.
+func ResolveURL(base *url.URL, ref string) *url.URL {
+	return concatUrl(base, ref)
+}
+
+// Get returns the body of uri, requested like the playlists and segments.
+func (d *Downloader) Get(ctx context.Context, uri *url.URL) ([]byte, error) {
+	return d.get(ctx, uri)
+}
@@ -0,0 +1,57 @@
+package downloader
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestTagReader(t *testing.T) {
+	const tag = "#EXT-X-CONTENT-STEERING"
+	for _, tc := range []struct {
+		name  string
+		body  string
+		found bool
+	}{
+		{"absent", "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1\na.m3u8\n", false},
+		{"present", "#EXTM3U\n" + tag + ":SERVER-URI=\"s\"\n#EXT-X-STREAM-INF:BANDWIDTH=1\na.m3u8\n", true},
+		{"prefix only", "#EXTM3U\n#EXT-X-CONTENT\n", false},
+	} {
+		// one byte at a time, the tag is split between all the reads
+		for _, r := range []io.Reader{strings.NewReader(tc.body), iotest.OneByteReader(strings.NewReader(tc.body))} {
+			tr := &tagReader{r: r, tag: []byte(tag)}
+			data, err := io.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tc.body {
+				t.Errorf("%s: read %q, want %q", tc.name, data, tc.body)
+			}
+			if tr.found != tc.found {
+				t.Errorf("%s: found %t, want %t", tc.name, tr.found, tc.found)
+			}
+		}
+	}
+}
+
+func TestParseAttributes(t *testing.T) {
+	for _, tc := range []struct {
+		line string
+		want map[string]string
+	}{
+		{`METHOD=AES-128,URI="key.bin",IV=0x01`, map[string]string{"METHOD": "AES-128", "URI": "key.bin", "IV": "0x01"}},
+		// the commas of quoted strings don't separate attributes
+		{`BANDWIDTH=1280000,CODECS="avc1.4d401f,mp4a.40.2",RESOLUTION=640x360`,
+			map[string]string{"BANDWIDTH": "1280000", "CODECS": "avc1.4d401f,mp4a.40.2", "RESOLUTION": "640x360"}},
+		{`TYPE=AUDIO, GROUP-ID="aac",NAME=""`, map[string]string{"TYPE": "AUDIO", "GROUP-ID": "aac", "NAME": ""}},
+		{`URI="unterminated`, map[string]string{"URI": "unterminated"}},
+		{`NOVALUE`, map[string]string{}},
+		{``, map[string]string{}},
+	} {
+		if got := parseAttributes(tc.line); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.line, got, tc.want)
+		}
+	}
+}
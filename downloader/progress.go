@@ -0,0 +1,171 @@
+package downloader
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/grafov/m3u8"
+)
+
+// EventType identifies the step of the download an Event reports.
+type EventType string
+
+const (
+	EventPlaylistFetched  EventType = "playlist_fetched"
+	EventVariantChosen    EventType = "variant_chosen"
+	EventSegmentStarted   EventType = "segment_started"
+	EventSegmentFinished  EventType = "segment_finished"
+	EventSegmentFailed    EventType = "segment_failed"
+	EventProgress         EventType = "progress"
+	EventStitchingStarted EventType = "stitching_started"
+	EventDone             EventType = "done"
+)
+
+// Event reports the progress of a download. Fields that don't apply to
+// the event type are left empty.
+type Event struct {
+	Type       EventType `json:"event"`
+	Time       time.Time `json:"time"`
+	URL        string    `json:"url,omitempty"`
+	Variants   int       `json:"variants,omitempty"`
+	Variant    *int      `json:"variant,omitempty"`
+	Name       string    `json:"name,omitempty"`
+	Bandwidth  uint32    `json:"bandwidth,omitempty"`
+	Resolution string    `json:"resolution,omitempty"`
+	Segments   int       `json:"segments,omitempty"`
+	Segment    *int      `json:"segment,omitempty"`
+	Bytes      int64     `json:"bytes,omitempty"`
+	Total      int64     `json:"total,omitempty"`    // estimated bytes of the download, EventProgress only
+	Reused     int64     `json:"reused,omitempty"`   // bytes of Bytes found in the work directory, EventProgress only
+	Duration   *float64  `json:"duration,omitempty"` // seconds
+	Output     string    `json:"output,omitempty"`
+	Size       int64     `json:"size,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Resumed    bool      `json:"resumed,omitempty"` // segment found in the work directory
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func seconds(d time.Duration) *float64 {
+	s := d.Seconds()
+	return &s
+}
+
+// progressInterval throttles the EventProgress events.
+const progressInterval = 200 * time.Millisecond
+
+// transferProgress tracks the downloaded bytes of the segments against an
+// estimated total and reports them as EventProgress events. The estimate
+// of a segment starts from the variant bandwidth and its duration, and is
+// replaced by the Content-Length once the server sends it.
+type transferProgress struct {
+	mu       sync.Mutex
+	sizes    []int64
+	total    int64
+	done     int64
+	reused   int64 // bytes of done that weren't transferred
+	lastEmit time.Time
+	emit     func(Event)
+}
+
+func newTransferProgress(segments []*m3u8.MediaSegment, bandwidth uint32, emit func(Event)) *transferProgress {
+	tp := &transferProgress{
+		sizes: make([]int64, len(segments)),
+		emit:  emit,
+	}
+	for i, s := range segments {
+		if s.Gap {
+			continue
+		}
+		tp.sizes[i] = int64(float64(bandwidth) / 8 * s.Duration)
+		tp.total += tp.sizes[i]
+	}
+	return tp
+}
+
+// setSize replaces the estimated size of segment i with its actual size.
+func (tp *transferProgress) setSize(i int, size int64) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.total += size - tp.sizes[i]
+	tp.sizes[i] = size
+	tp.resize()
+}
+
+// add records n downloaded bytes, n is negative when a failed attempt is
+// rolled back before retrying.
+func (tp *transferProgress) add(n int64) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.done += n
+	tp.resize()
+	if time.Since(tp.lastEmit) >= progressInterval {
+		tp.report()
+	}
+}
+
+// reuse records segment i as found complete in the work directory. Its
+// size counts as downloaded but not as transferred, so resumed downloads
+// don't report a burst of throughput.
+func (tp *transferProgress) reuse(i int, size int64) {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.total += size - tp.sizes[i]
+	tp.sizes[i] = size
+	tp.done += size
+	tp.reused += size
+	tp.resize()
+}
+
+// resize keeps the total from falling behind the downloaded bytes when
+// the estimate was too low. The caller must hold tp.mu.
+func (tp *transferProgress) resize() {
+	if tp.total < tp.done {
+		tp.total = tp.done
+	}
+}
+
+// report emits the current progress. The caller must hold tp.mu.
+func (tp *transferProgress) report() {
+	tp.lastEmit = time.Now()
+	tp.emit(Event{Type: EventProgress, Bytes: tp.done, Total: tp.total, Reused: tp.reused})
+}
+
+// finish reports the final progress.
+func (tp *transferProgress) finish() {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.report()
+}
+
+// HumanBytes formats n bytes with a binary unit, like 1.5 MiB.
+func HumanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// progressReader reports the bytes read from the wrapped reader.
+type progressReader struct {
+	io.Reader
+	onRead func(n int64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.onRead(int64(n))
+	}
+	return n, err
+}
@@ -0,0 +1,52 @@
+package downloader
+
+import (
+	"testing"
+
+	"github.com/grafov/m3u8"
+)
+
+func TestTransferProgress(t *testing.T) {
+	segments := []*m3u8.MediaSegment{{Duration: 4}, {Duration: 4, Gap: true}, {Duration: 2}, {Duration: 4}}
+	var last Event
+	tp := newTransferProgress(segments, 8000, func(ev Event) { last = ev })
+	// 1000 bytes a second, nothing for the gap
+	if tp.total != 10000 {
+		t.Fatalf("estimated %d bytes, want 10000", tp.total)
+	}
+
+	tp.reuse(0, 3000)
+	tp.setSize(2, 2500)
+	tp.add(2500)
+	tp.setSize(3, 4000)
+	tp.add(1000)
+	tp.add(-1000) // the attempt failed
+	tp.finish()
+	want := Event{Type: EventProgress, Bytes: 5500, Total: 9500, Reused: 3000}
+	if last.Type != want.Type || last.Bytes != want.Bytes || last.Total != want.Total || last.Reused != want.Reused {
+		t.Errorf("got %+v, want %+v", last, want)
+	}
+
+	// an estimate too low grows with the downloaded bytes
+	tp.add(5000)
+	tp.finish()
+	if last.Bytes != 10500 || last.Total != 10500 {
+		t.Errorf("got %d of %d bytes, want 10500 of 10500", last.Bytes, last.Total)
+	}
+}
+
+func TestHumanBytes(t *testing.T) {
+	for n, want := range map[int64]string{
+		0:             "0 B",
+		1023:          "1023 B",
+		1024:          "1.0 KiB",
+		1536:          "1.5 KiB",
+		5 << 20:       "5.0 MiB",
+		3 << 30:       "3.0 GiB",
+		1<<40 + 1<<39: "1.5 TiB",
+	} {
+		if got := HumanBytes(n); got != want {
+			t.Errorf("%d: got %s, want %s", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package downloader
+
+import (
+	"context"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// RetryPolicy controls how a failed segment request is retried before
+// moving on to the next mirror. Zero fields keep the defaults of the
+// exponential backoff: 500ms growing to 1m between attempts, for up to
+// 15m.
+type RetryPolicy struct {
+	// MaxRetries bounds the retries of a request, 0 retries until
+	// MaxElapsed and a negative value doesn't retry.
+	MaxRetries int
+	// InitialInterval is the wait before the first retry.
+	InitialInterval time.Duration
+	// MaxInterval caps the wait between two retries.
+	MaxInterval time.Duration
+	// MaxElapsed gives up on a request after this long.
+	MaxElapsed time.Duration
+}
+
+func (p RetryPolicy) backOff(ctx context.Context) backoff.BackOff {
+	eb := backoff.NewExponentialBackOff()
+	if p.InitialInterval > 0 {
+		eb.InitialInterval = p.InitialInterval
+	}
+	if p.MaxInterval > 0 {
+		eb.MaxInterval = p.MaxInterval
+	}
+	if p.MaxElapsed > 0 {
+		eb.MaxElapsedTime = p.MaxElapsed
+	}
+	eb.Reset()
+	var b backoff.BackOff = eb
+	switch {
+	case p.MaxRetries > 0:
+		b = backoff.WithMaxRetries(b, uint64(p.MaxRetries))
+	case p.MaxRetries < 0:
+		b = backoff.WithMaxRetries(b, 0)
+	}
+	return backoff.WithContext(b, ctx)
+}
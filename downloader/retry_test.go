@@ -0,0 +1,33 @@
+package downloader
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		policy RetryPolicy
+		want   int // attempts of an operation that keeps failing
+	}{
+		{RetryPolicy{MaxRetries: 2, InitialInterval: time.Millisecond}, 3},
+		{RetryPolicy{MaxRetries: -1, InitialInterval: time.Millisecond}, 1},
+		// until MaxElapsed
+		{RetryPolicy{InitialInterval: 20 * time.Millisecond, MaxInterval: 20 * time.Millisecond, MaxElapsed: 50 * time.Millisecond}, -1},
+	} {
+		attempts := 0
+		backoff.Retry(func() error {
+			attempts++
+			return context.DeadlineExceeded
+		}, tc.policy.backOff(context.Background()))
+		switch {
+		case tc.want < 0 && attempts < 2:
+			t.Errorf("%+v: got %d attempts, want retries until the timeout", tc.policy, attempts)
+		case tc.want > 0 && attempts != tc.want:
+			t.Errorf("%+v: got %d attempts, want %d", tc.policy, attempts, tc.want)
+		}
+	}
+}
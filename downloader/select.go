@@ -0,0 +1,82 @@
+package downloader
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// VariantSelector returns a SelectVariant function picking the variant
+// described by spec:
+//
+//	highest, "" the highest bandwidth (default)
+//	lowest      the lowest bandwidth
+//	2           the index in the list sorted by descending bandwidth
+//	1280x720    the resolution
+//	720p        the height of the resolution
+//	anything    the NAME attribute of the variant
+func VariantSelector(spec string) (func(variants []*Variant) (int, error), error) {
+	spec = strings.TrimSpace(spec)
+	switch strings.ToLower(spec) {
+	case "", "highest":
+		return func(variants []*Variant) (int, error) {
+			return 0, nil
+		}, nil
+	case "lowest":
+		return func(variants []*Variant) (int, error) {
+			return len(variants) - 1, nil
+		}, nil
+	}
+	if i, err := strconv.Atoi(spec); err == nil {
+		if i < 0 {
+			return nil, fmt.Errorf("invalid variant index %d", i)
+		}
+		return func(variants []*Variant) (int, error) {
+			return i, nil
+		}, nil
+	}
+	return func(variants []*Variant) (int, error) {
+		for i, v := range variants {
+			if matchVariant(v, spec) {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("no variant matches %q", spec)
+	}, nil
+}
+
+// VariantRules returns a SelectVariant function trying the specs of
+// VariantSelector in order, like ["1080p", "720p", "lowest"]. The first
+// one matching a variant wins.
+func VariantRules(specs []string) (func(variants []*Variant) (int, error), error) {
+	if len(specs) == 0 {
+		return VariantSelector("")
+	}
+	selectors := make([]func([]*Variant) (int, error), len(specs))
+	for i, spec := range specs {
+		sel, err := VariantSelector(spec)
+		if err != nil {
+			return nil, err
+		}
+		selectors[i] = sel
+	}
+	return func(variants []*Variant) (int, error) {
+		for _, sel := range selectors {
+			if i, err := sel(variants); err == nil && i < len(variants) {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("no variant matches %s", strings.Join(specs, ", "))
+	}, nil
+}
+
+func matchVariant(v *Variant, spec string) bool {
+	if strings.EqualFold(v.Resolution, spec) || strings.EqualFold(v.Name, spec) {
+		return true
+	}
+	lower := strings.ToLower(spec)
+	if !strings.HasSuffix(lower, "p") || v.Resolution == "" {
+		return false
+	}
+	return strings.HasSuffix(strings.ToLower(v.Resolution), "x"+strings.TrimSuffix(lower, "p"))
+}
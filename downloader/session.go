@@ -0,0 +1,148 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/grafov/m3u8"
+)
+
+// metadataKeys are the session data names written as metadata of the
+// output file, matched with the last component of the DATA-ID like
+// com.apple.hls.title.
+var metadataKeys = map[string]bool{
+	"title":       true,
+	"artist":      true,
+	"album":       true,
+	"genre":       true,
+	"date":        true,
+	"comment":     true,
+	"description": true,
+	"synopsis":    true,
+	"copyright":   true,
+}
+
+// SessionData returns the EXT-X-SESSION-DATA values of masterpl by
+// DATA-ID, the first one wins when several languages are given. Data
+// given by URI is fetched relative to uri: a JSON string gives its value,
+// other JSON values are kept as compact JSON and RAW data as text. Data
+// that can't be fetched is logged and left out.
+func (d *Downloader) SessionData(ctx context.Context, uri *url.URL, masterpl *m3u8.MasterPlaylist) map[string]string {
+	values := make(map[string]string)
+	for _, data := range masterpl.SessionData {
+		if _, ok := values[data.DataID]; ok {
+			continue
+		}
+		if data.URI == "" {
+			values[data.DataID] = data.Value
+			continue
+		}
+		value, err := d.fetchSessionData(ctx, concatUrl(uri, data.URI), data.Format)
+		if err != nil {
+			d.log.Printf("session data %s: %s\n", data.DataID, err)
+			continue
+		}
+		values[data.DataID] = value
+	}
+	return values
+}
+
+// fetchSessionData fetches the session data at uri in format, JSON when
+// empty.
+func (d *Downloader) fetchSessionData(ctx context.Context, uri *url.URL, format string) (string, error) {
+	body, err := d.get(ctx, uri)
+	if err != nil {
+		return "", err
+	}
+	if format == "RAW" {
+		return strings.TrimSpace(string(body)), nil
+	}
+	var s string
+	if err := json.Unmarshal(body, &s); err == nil {
+		return s, nil
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, body); err != nil {
+		return "", fmt.Errorf("invalid JSON: %w", err)
+	}
+	return buf.String(), nil
+}
+
+// sessionMetadata returns the session data values usable as metadata of
+// the output file, keyed by their ffmpeg name.
+func sessionMetadata(values map[string]string) map[string]string {
+	meta := make(map[string]string)
+	for id, value := range values {
+		name := id[strings.LastIndexByte(id, '.')+1:]
+		if metadataKeys[name] && value != "" {
+			meta[name] = value
+		}
+	}
+	return meta
+}
+
+// prefetchSessionKeys fetches the AES-128 EXT-X-SESSION-KEY keys of
+// masterpl, relative to uri, into the key cache so the segments don't wait
+// for them. Failures are only logged, the keys are requested again when a
+// segment needs them.
+func (dl *download) prefetchSessionKeys(ctx context.Context, uri *url.URL, masterpl *m3u8.MasterPlaylist) {
+	for _, key := range masterpl.SessionKeys {
+		if key.Method != "AES-128" {
+			continue
+		}
+		if _, err := dl.key(ctx, concatUrl(uri, key.URI)); err != nil {
+			dl.log.Printf("session key %s: %s\n", key.URI, err)
+		}
+	}
+}
+
+// keyFetch is a request of a decryption key, shared by the segments
+// needing the key while it is in flight.
+type keyFetch struct {
+	done chan struct{} // closed once body and err are set
+	body []byte
+	err  error
+}
+
+// key returns the body of the decryption key at uri, fetched once per
+// download. The lock is only held to find the fetch of uri, so a slow key
+// server doesn't hold back the segments of other keys. A failed fetch is
+// forgotten, the next segment requests the key again.
+func (dl *download) key(ctx context.Context, uri *url.URL) ([]byte, error) {
+	ref := uri.String()
+	dl.keysMu.Lock()
+	f, ok := dl.keys[ref]
+	if !ok {
+		f = &keyFetch{done: make(chan struct{})}
+		if dl.keys == nil {
+			dl.keys = make(map[string]*keyFetch)
+		}
+		dl.keys[ref] = f
+	}
+	dl.keysMu.Unlock()
+
+	if ok {
+		select {
+		case <-f.done:
+			return f.body, f.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	f.body, f.err = dl.get(ctx, uri)
+	if f.err != nil {
+		f.err = fmt.Errorf("failed to get decryption key: %w", f.err)
+		dl.keysMu.Lock()
+		delete(dl.keys, ref)
+		dl.keysMu.Unlock()
+	} else if dl.opts.Verbose {
+		dl.log.Printf("Decryption key fetched from %s\n", ref)
+	}
+	close(f.done)
+	return f.body, f.err
+}
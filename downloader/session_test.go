@@ -0,0 +1,112 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKey(t *testing.T) {
+	gate := make(chan struct{})
+	var requests sync.Map // path to *atomic.Int32
+	var failing atomic.Bool
+	failing.Store(true)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := requests.LoadOrStore(r.URL.Path, new(atomic.Int32))
+		n.(*atomic.Int32).Add(1)
+		switch r.URL.Path {
+		case "/slow.key":
+			<-gate
+		case "/flaky.key":
+			if failing.Load() {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer srv.Close()
+	defer func() {
+		select {
+		case <-gate:
+		default:
+			close(gate)
+		}
+	}()
+	count := func(path string) int32 {
+		n, ok := requests.Load(path)
+		if !ok {
+			return 0
+		}
+		return n.(*atomic.Int32).Load()
+	}
+	keyURL := func(path string) *url.URL {
+		u, _ := url.Parse(srv.URL + path)
+		return u
+	}
+	dl := &download{Downloader: New(Options{})}
+	ctx := context.Background()
+
+	// the segments of the slow key share its request
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if body, err := dl.key(ctx, keyURL("/slow.key")); err != nil || string(body) != "/slow.key" {
+				t.Errorf("slow key: got %q, %v", body, err)
+			}
+		}()
+	}
+
+	// while it is in flight, the other keys don't wait
+	for count("/slow.key") == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	fast := make(chan error)
+	go func() {
+		_, err := dl.key(ctx, keyURL("/fast.key"))
+		fast <- err
+	}()
+	select {
+	case err := <-fast:
+		if err != nil {
+			t.Errorf("fast key: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the fast key waits for the slow one")
+	}
+
+	// a waiting segment gives up with its context
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := dl.key(cctx, keyURL("/slow.key")); err != context.Canceled {
+		t.Errorf("canceled wait: got %v", err)
+	}
+
+	close(gate)
+	wg.Wait()
+	if _, err := dl.key(ctx, keyURL("/slow.key")); err != nil {
+		t.Error(err)
+	}
+	if n := count("/slow.key"); n != 1 {
+		t.Errorf("slow key requested %d times, want once", n)
+	}
+
+	// a failure isn't kept
+	if _, err := dl.key(ctx, keyURL("/flaky.key")); err == nil {
+		t.Error("flaky key: got no error")
+	}
+	failing.Store(false)
+	if body, err := dl.key(ctx, keyURL("/flaky.key")); err != nil || string(body) != "/flaky.key" {
+		t.Errorf("flaky key after the failure: got %q, %v", body, err)
+	}
+	if n := count("/flaky.key"); n != 2 {
+		t.Errorf("flaky key requested %d times, want 2", n)
+	}
+}
@@ -0,0 +1,113 @@
+package downloader
+
+import (
+	"github.com/grafov/m3u8"
+)
+
+// run is a sequence of segments without discontinuity, whose timestamps
+// follow each other.
+type run struct {
+	first     int // index of the first segment in the playlist
+	files     []string
+	segments  []*m3u8.MediaSegment // segment of each file
+	durations []float64            // seconds each file spans, a gap after it included
+	duration  float64
+	gap       bool // follows skipped EXT-X-GAP segments rather than a discontinuity
+}
+
+// runs splits the segment files, in playback order, into the runs between
+// EXT-X-DISCONTINUITY tags and removed ad breaks. The EXT-X-GAP segments,
+// which have no file, are left out: the run is split at them like at a
+// discontinuity, or with Options.FillGaps their time is added to the
+// segment before them in the run. The runs shorter than
+// Options.MinRunDuration are dropped.
+func (dl *download) runs(files []string, segments []*m3u8.MediaSegment) []*run {
+	var (
+		runs    []*run
+		cur     *run
+		gaps    int
+		gapTime float64
+		split   bool
+		skipped bool // gap segments left out since the last file
+	)
+	for i, file := range files {
+		seg := segments[i]
+		if seg.Discontinuity || dl.adSplits[seg] {
+			split = true
+		}
+		if file == "" {
+			gaps++
+			gapTime += seg.Duration
+			if !dl.opts.FillGaps {
+				skipped = true
+			} else if cur != nil && !split {
+				cur.durations[len(cur.durations)-1] += seg.Duration
+				cur.duration += seg.Duration
+			}
+			continue
+		}
+		if cur == nil || split || skipped {
+			cur = &run{first: i, gap: skipped && !split && cur != nil}
+			runs = append(runs, cur)
+			split, skipped = false, false
+		}
+		cur.files = append(cur.files, file)
+		cur.segments = append(cur.segments, seg)
+		cur.durations = append(cur.durations, seg.Duration)
+		cur.duration += seg.Duration
+	}
+	if gaps > 0 {
+		if dl.opts.FillGaps {
+			dl.log.Printf("%d gap segments (%.1fs) filled with the segments before them\n", gaps, gapTime)
+		} else {
+			dl.log.Printf("%d gap segments (%.1fs) skipped\n", gaps, gapTime)
+		}
+	}
+	if len(runs) > 1 {
+		dl.log.Printf("%d discontinuities or gaps, the segments are stitched in %d runs\n", len(runs)-1, len(runs))
+	}
+	return dl.dropShortRuns(runs)
+}
+
+// dropShortRuns removes the runs shorter than Options.MinRunDuration, like
+// the ad slates between discontinuities. They are all kept when none is
+// long enough.
+func (dl *download) dropShortRuns(runs []*run) []*run {
+	minDuration := dl.opts.MinRunDuration.Seconds()
+	if minDuration <= 0 {
+		return runs
+	}
+	var kept []*run
+	for _, r := range runs {
+		if r.duration >= minDuration {
+			kept = append(kept, r)
+		}
+	}
+	if len(kept) == 0 {
+		dl.log.Printf("Every run is shorter than %s, none dropped\n", dl.opts.MinRunDuration)
+		return runs
+	}
+	for _, r := range runs {
+		if r.duration < minDuration {
+			dl.log.Printf("Dropped run of %d segments (%.1fs) from segment %d\n", len(r.files), r.duration, r.first)
+		}
+	}
+	return kept
+}
+
+// muxInput returns the input of the muxer joining runs, the durations are
+// only given when gaps are filled.
+func (dl *download) muxInput(runs []*run) *MuxInput {
+	input := &MuxInput{}
+	for i, r := range runs {
+		if i > 0 {
+			input.Breaks = append(input.Breaks, len(input.Segments))
+		}
+		input.Segments = append(input.Segments, r.files...)
+		input.Durations = append(input.Durations, r.durations...)
+	}
+	if !dl.opts.FillGaps {
+		input.Durations = nil
+	}
+	return input
+}
@@ -0,0 +1,222 @@
+package downloader
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/grafov/m3u8"
+)
+
+// stitchSegment describes a playlist segment of a runs test.
+type stitchSegment struct {
+	duration float64
+	disc     bool // EXT-X-DISCONTINUITY
+	gap      bool // EXT-X-GAP, without file
+	adSplit  bool // follows a removed ad break
+}
+
+// stitchInput returns the segments and their files, named after their
+// index, with the ad splits of the download.
+func stitchInput(specs []stitchSegment) ([]string, []*m3u8.MediaSegment, map[*m3u8.MediaSegment]bool) {
+	var (
+		files    []string
+		segments []*m3u8.MediaSegment
+		splits   = make(map[*m3u8.MediaSegment]bool)
+	)
+	for i, s := range specs {
+		seg := &m3u8.MediaSegment{Duration: s.duration, Discontinuity: s.disc, Gap: s.gap}
+		file := fmt.Sprintf("%d.ts", i)
+		if s.gap {
+			file = ""
+		}
+		if s.adSplit {
+			splits[seg] = true
+		}
+		files = append(files, file)
+		segments = append(segments, seg)
+	}
+	return files, segments, splits
+}
+
+// stitchRun is the part of a run a test compares.
+type stitchRun struct {
+	first     int
+	files     []string
+	durations []float64
+	gap       bool
+}
+
+func summarizeRuns(runs []*run) []stitchRun {
+	var got []stitchRun
+	for _, r := range runs {
+		got = append(got, stitchRun{r.first, r.files, r.durations, r.gap})
+	}
+	return got
+}
+
+func TestRunsGaps(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		fillGaps bool
+		segments []stitchSegment
+		want     []stitchRun
+	}{
+		{
+			// the timestamps after a gap don't follow the ones before
+			name:     "split at a gap",
+			segments: []stitchSegment{{duration: 4}, {duration: 4, gap: true}, {duration: 4, gap: true}, {duration: 4}},
+			want: []stitchRun{
+				{0, []string{"0.ts"}, []float64{4}, false},
+				{3, []string{"3.ts"}, []float64{4}, true},
+			},
+		},
+		{
+			name:     "filled gap",
+			fillGaps: true,
+			segments: []stitchSegment{{duration: 4}, {duration: 4, gap: true}, {duration: 2, gap: true}, {duration: 4}},
+			want: []stitchRun{
+				{0, []string{"0.ts", "3.ts"}, []float64{10, 4}, false},
+			},
+		},
+		{
+			name:     "gap before a discontinuity",
+			segments: []stitchSegment{{duration: 4}, {duration: 4, gap: true}, {duration: 4, disc: true}},
+			want: []stitchRun{
+				{0, []string{"0.ts"}, []float64{4}, false},
+				{2, []string{"2.ts"}, []float64{4}, false},
+			},
+		},
+		{
+			// the gap belongs to the next run, it can't extend the one before
+			name:     "filled gap after a discontinuity",
+			fillGaps: true,
+			segments: []stitchSegment{{duration: 4}, {duration: 4, gap: true, disc: true}, {duration: 4}},
+			want: []stitchRun{
+				{0, []string{"0.ts"}, []float64{4}, false},
+				{2, []string{"2.ts"}, []float64{4}, false},
+			},
+		},
+		{
+			name:     "leading gap",
+			segments: []stitchSegment{{duration: 4, gap: true}, {duration: 4}, {duration: 4}},
+			want: []stitchRun{
+				{1, []string{"1.ts", "2.ts"}, []float64{4, 4}, false},
+			},
+		},
+	} {
+		files, segments, splits := stitchInput(tc.segments)
+		dl := &download{Downloader: New(Options{FillGaps: tc.fillGaps}), adSplits: splits}
+		if got := summarizeRuns(dl.runs(files, segments)); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got runs %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestRuns(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		minDuration time.Duration
+		segments    []stitchSegment
+		want        []stitchRun
+	}{
+		{
+			name:     "no break",
+			segments: []stitchSegment{{duration: 4}, {duration: 4}, {duration: 2}},
+			want:     []stitchRun{{0, []string{"0.ts", "1.ts", "2.ts"}, []float64{4, 4, 2}, false}},
+		},
+		{
+			name:     "discontinuities",
+			segments: []stitchSegment{{duration: 4}, {duration: 4, disc: true}, {duration: 4}, {duration: 2, disc: true}},
+			want: []stitchRun{
+				{0, []string{"0.ts"}, []float64{4}, false},
+				{1, []string{"1.ts", "2.ts"}, []float64{4, 4}, false},
+				{3, []string{"3.ts"}, []float64{2}, false},
+			},
+		},
+		{
+			// the first segment has nothing to split from
+			name:     "leading discontinuity",
+			segments: []stitchSegment{{duration: 4, disc: true}, {duration: 4}},
+			want:     []stitchRun{{0, []string{"0.ts", "1.ts"}, []float64{4, 4}, false}},
+		},
+		{
+			name:     "removed ad break",
+			segments: []stitchSegment{{duration: 4}, {duration: 4}, {duration: 4, adSplit: true}},
+			want: []stitchRun{
+				{0, []string{"0.ts", "1.ts"}, []float64{4, 4}, false},
+				{2, []string{"2.ts"}, []float64{4}, false},
+			},
+		},
+		{
+			name:     "ad break at a discontinuity",
+			segments: []stitchSegment{{duration: 4}, {duration: 4, disc: true, adSplit: true}},
+			want: []stitchRun{
+				{0, []string{"0.ts"}, []float64{4}, false},
+				{1, []string{"1.ts"}, []float64{4}, false},
+			},
+		},
+		{
+			name:        "short run dropped",
+			minDuration: 6 * time.Second,
+			segments:    []stitchSegment{{duration: 4}, {duration: 4}, {duration: 2, disc: true}, {duration: 4, disc: true}, {duration: 2}},
+			want: []stitchRun{
+				{0, []string{"0.ts", "1.ts"}, []float64{4, 4}, false},
+				{3, []string{"3.ts", "4.ts"}, []float64{4, 2}, false},
+			},
+		},
+		{
+			name:        "every run short",
+			minDuration: time.Minute,
+			segments:    []stitchSegment{{duration: 4}, {duration: 4, disc: true}},
+			want: []stitchRun{
+				{0, []string{"0.ts"}, []float64{4}, false},
+				{1, []string{"1.ts"}, []float64{4}, false},
+			},
+		},
+	} {
+		files, segments, splits := stitchInput(tc.segments)
+		dl := &download{Downloader: New(Options{MinRunDuration: tc.minDuration}), adSplits: splits}
+		if got := summarizeRuns(dl.runs(files, segments)); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got runs %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestMuxInput(t *testing.T) {
+	runs := []*run{
+		{files: []string{"0.ts", "1.ts"}, durations: []float64{4, 10}},
+		{files: []string{"3.ts"}, durations: []float64{4}},
+		{files: []string{"4.ts", "5.ts"}, durations: []float64{2, 4}},
+	}
+	for _, fillGaps := range []bool{false, true} {
+		dl := &download{Downloader: New(Options{FillGaps: fillGaps})}
+		got := dl.muxInput(runs)
+		want := &MuxInput{
+			Segments: []string{"0.ts", "1.ts", "3.ts", "4.ts", "5.ts"},
+			Breaks:   []int{2, 3},
+		}
+		// the durations are only needed to hold a segment over a gap
+		if fillGaps {
+			want.Durations = []float64{4, 10, 4, 2, 4}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("fill gaps %t: got %+v, want %+v", fillGaps, got, want)
+		}
+
+		// the muxer gets the runs back
+		files, durations := got.runs()
+		if !reflect.DeepEqual(files, [][]string{{"0.ts", "1.ts"}, {"3.ts"}, {"4.ts", "5.ts"}}) {
+			t.Errorf("fill gaps %t: got runs %v", fillGaps, files)
+		}
+		if fillGaps && !reflect.DeepEqual(durations, [][]float64{{4, 10}, {4}, {2, 4}}) {
+			t.Errorf("fill gaps %t: got durations %v", fillGaps, durations)
+		}
+	}
+
+	dl := &download{Downloader: New(Options{})}
+	if got := dl.muxInput(runs[:1]); got.Breaks != nil {
+		t.Errorf("single run: got breaks %v", got.Breaks)
+	}
+}
@@ -0,0 +1,112 @@
+package downloader
+
+import (
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// TemplateData fills the placeholders of an output template.
+type TemplateData struct {
+	URL     *url.URL  // master playlist
+	Variant *Variant  // chosen variant
+	Title   string    // EXT-X-SESSION-DATA title of the playlist
+	Date    time.Time // start of the download
+}
+
+// IsTemplate reports whether dest has placeholders to expand.
+func IsTemplate(dest string) bool {
+	return strings.Contains(dest, "{") && strings.Contains(dest, "}")
+}
+
+// ExpandTemplate fills the placeholders of tmpl:
+//
+//	{host}       host of the playlist URL
+//	{name}       NAME attribute of the variant, or the playlist file name
+//	{resolution} resolution of the variant, like 1280x720
+//	{bandwidth}  bandwidth of the variant in bits per second
+//	{title}      title from the EXT-X-SESSION-DATA of the playlist, or {name}
+//	{date}       start of the download, like 20060102_150405
+//
+// The values are sanitized to be safe file names, so only the slashes of
+// tmpl itself create directories. Unknown placeholders are left as is.
+func ExpandTemplate(tmpl string, data TemplateData) string {
+	name := ""
+	if data.Variant != nil {
+		name = data.Variant.Name
+	}
+	if name == "" && data.URL != nil {
+		name = strings.TrimSuffix(path.Base(data.URL.Path), path.Ext(data.URL.Path))
+	}
+	title := data.Title
+	if title == "" {
+		title = name
+	}
+	values := map[string]string{
+		"name":  name,
+		"title": title,
+		"date":  data.Date.Format("20060102_150405"),
+	}
+	if data.URL != nil {
+		values["host"] = data.URL.Hostname()
+	}
+	if data.Variant != nil {
+		values["resolution"] = data.Variant.Resolution
+		values["bandwidth"] = strconv.FormatUint(uint64(data.Variant.Bandwidth), 10)
+	}
+
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(tmpl, '{')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(tmpl[start:], '}')
+		if end < 0 {
+			break
+		}
+		end += start
+		b.WriteString(tmpl[:start])
+		if value, ok := values[tmpl[start+1:end]]; ok {
+			b.WriteString(sanitizeFileName(value))
+		} else {
+			b.WriteString(tmpl[start : end+1])
+		}
+		tmpl = tmpl[end+1:]
+	}
+	b.WriteString(tmpl)
+	return b.String()
+}
+
+// sanitizeFileName replaces the characters of s that are unsafe in a file
+// name on common file systems, so s can't escape its directory either.
+func sanitizeFileName(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, s)
+	s = strings.Trim(s, " .")
+	if s == "" {
+		return "_"
+	}
+	return s
+}
+
+// sessionTitle returns the session data whose DATA-ID is a title, like
+// com.apple.hls.title, see Downloader.SessionData.
+func sessionTitle(values map[string]string) string {
+	if title := values["com.apple.hls.title"]; title != "" {
+		return title
+	}
+	for id, value := range values {
+		if (id == "title" || strings.HasSuffix(id, ".title")) && value != "" {
+			return value
+		}
+	}
+	return ""
+}
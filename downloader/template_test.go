@@ -0,0 +1,120 @@
+package downloader
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/grafov/m3u8"
+)
+
+func TestExpandTemplate(t *testing.T) {
+	uri, _ := url.Parse("https://cdn.example.com:8443/shows/episode-1/master.m3u8?token=x")
+	variant := &Variant{Variant: &m3u8.Variant{VariantParams: m3u8.VariantParams{
+		Name:       "720p",
+		Resolution: "1280x720",
+		Bandwidth:  2560000,
+	}}}
+	date := time.Date(2026, 3, 1, 10, 4, 5, 0, time.UTC)
+	for _, tc := range []struct {
+		tmpl string
+		data TemplateData
+		want string
+	}{
+		{
+			tmpl: "{host}/{name}_{resolution}_{bandwidth}_{date}.mp4",
+			data: TemplateData{URL: uri, Variant: variant, Date: date},
+			want: "cdn.example.com/720p_1280x720_2560000_20260301_100405.mp4",
+		},
+		{
+			// without NAME, the playlist file name
+			tmpl: "{name}.mp4",
+			data: TemplateData{URL: uri, Variant: &Variant{Variant: &m3u8.Variant{}}},
+			want: "master.mp4",
+		},
+		{
+			tmpl: "{title}.mp4",
+			data: TemplateData{URL: uri, Variant: variant, Title: "Episode 1"},
+			want: "Episode 1.mp4",
+		},
+		{
+			// without title, the name
+			tmpl: "{title}.mp4",
+			data: TemplateData{URL: uri, Variant: variant},
+			want: "720p.mp4",
+		},
+		{
+			// the values can't create directories or escape them
+			tmpl: "out/{title}.mp4",
+			data: TemplateData{URL: uri, Variant: variant, Title: "../../etc/passwd"},
+			want: "out/_.._etc_passwd.mp4",
+		},
+		{
+			tmpl: "{title}.mp4",
+			data: TemplateData{URL: uri, Variant: variant, Title: `a:b*c?"d"<e>|f\g`},
+			want: "a_b_c__d__e__f_g.mp4",
+		},
+		{
+			tmpl: "{unknown}_{name}.mp4",
+			data: TemplateData{URL: uri, Variant: variant},
+			want: "{unknown}_720p.mp4",
+		},
+		{
+			tmpl: "{name.mp4",
+			data: TemplateData{URL: uri, Variant: variant},
+			want: "{name.mp4",
+		},
+	} {
+		if got := ExpandTemplate(tc.tmpl, tc.data); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.tmpl, got, tc.want)
+		}
+	}
+}
+
+func TestSanitizeFileName(t *testing.T) {
+	for _, tc := range []struct {
+		name, want string
+	}{
+		{"Episode 1", "Episode 1"},
+		{"a/b\\c", "a_b_c"},
+		{"tab\there", "tab_here"},
+		{" .hidden. ", "hidden"},
+		{"..", "_"},
+		{"", "_"},
+		{"Émission n°1", "Émission n°1"},
+	} {
+		if got := sanitizeFileName(tc.name); got != tc.want {
+			t.Errorf("%q: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestIsTemplate(t *testing.T) {
+	for dest, want := range map[string]bool{
+		"out.mp4":           false,
+		"{name}.mp4":        true,
+		"{name.mp4":         false,
+		"dir/{title}/x.mp4": true,
+	} {
+		if got := IsTemplate(dest); got != want {
+			t.Errorf("%s: got %t, want %t", dest, got, want)
+		}
+	}
+}
+
+func TestSessionTitle(t *testing.T) {
+	for _, tc := range []struct {
+		values map[string]string
+		want   string
+	}{
+		{map[string]string{"com.apple.hls.title": "Apple", "com.example.title": "Example"}, "Apple"},
+		{map[string]string{"com.example.title": "Example", "com.example.lang": "en"}, "Example"},
+		{map[string]string{"title": "Plain"}, "Plain"},
+		{map[string]string{"com.example.subtitle": "Not a title"}, ""},
+		{nil, ""},
+	} {
+		if got := sessionTitle(tc.values); got != tc.want {
+			t.Errorf("%v: got %q, want %q", tc.values, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,368 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafov/m3u8"
+)
+
+// ThumbnailOptions configures Downloader.Thumbnails.
+type ThumbnailOptions struct {
+	Interval time.Duration // time between thumbnails, 10s when 0
+	Width    int           // width of the thumbnails in pixels, 160 when 0
+	Columns  int           // thumbnails per row of a sprite sheet, 5 when 0
+	Rows     int           // rows of a sprite sheet, 5 when 0
+}
+
+// ThumbnailTrack is the name of the WebVTT thumbnail track written by
+// Thumbnails.
+const ThumbnailTrack = "thumbnails.vtt"
+
+// thumbnail is an image of the track, shown from start to end.
+type thumbnail struct {
+	start, end float64
+	frame      int // index of the I-frame segment
+}
+
+// Thumbnails writes into dir a JPEG thumbnail of the stream at rawUrl every
+// opts.Interval, thumb_<n>.jpg, the sprite sheets tiling them,
+// sprite_<n>.jpg, and a WebVTT track pointing at their place in the sheets,
+// ThumbnailTrack. Only the byte ranges of the I-frames shown are
+// downloaded, from the EXT-X-I-FRAME-STREAM-INF variant with the smallest
+// resolution at least opts.Width wide, or from rawUrl itself when it is an
+// I-frame playlist. The frames are scaled with ffmpeg, the one of the
+// muxer when it is a FFmpeg.
+func (d *Downloader) Thumbnails(ctx context.Context, rawUrl, dir string, opts ThumbnailOptions) (*Result, error) {
+	res := &Result{URL: rawUrl, Output: filepath.Join(dir, ThumbnailTrack)}
+	uri, err := url.Parse(rawUrl)
+	if err != nil {
+		return res, err
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 10 * time.Second
+	}
+	if opts.Width <= 0 {
+		opts.Width = 160
+	}
+	if opts.Columns <= 0 {
+		opts.Columns = 5
+	}
+	if opts.Rows <= 0 {
+		opts.Rows = 5
+	}
+
+	p, listType, err := d.FetchPlaylist(ctx, uri)
+	if err != nil {
+		return res, err
+	}
+	var (
+		mediapl    *m3u8.MediaPlaylist
+		mUrl       = uri
+		resolution string
+	)
+	if listType == m3u8.MASTER {
+		masterpl := p.(*m3u8.MasterPlaylist)
+		variant := thumbnailVariant(IFrameVariants(masterpl), opts.Width)
+		if variant == nil {
+			return res, ErrNoIFrameVariants
+		}
+		res.Variant = VariantName(variant.Variant)
+		resolution = variant.Resolution
+		d.log.Println("I-frame variant:", res.Variant)
+		mUrl = concatUrl(uri, variant.URI)
+		if mediapl, err = d.FetchMediaPlaylist(ctx, mUrl, masterpl); err != nil {
+			return res, err
+		}
+	} else {
+		mediapl = p.(*m3u8.MediaPlaylist)
+	}
+	if !mediapl.Iframe {
+		return res, errors.New("I-frame playlist expected, media playlist found")
+	}
+
+	var (
+		segments []*m3u8.MediaSegment
+		maps     []*m3u8.Map // init section of each segment
+		xmap     = mediapl.Map
+	)
+	for _, seg := range mediapl.Segments {
+		if seg == nil {
+			break
+		}
+		if seg.Key != nil && seg.Key.Method != "" && seg.Key.Method != "NONE" {
+			return res, errors.New("encrypted I-frame playlists are not supported")
+		}
+		if seg.Map != nil {
+			xmap = seg.Map
+		}
+		segments = append(segments, seg)
+		maps = append(maps, xmap)
+	}
+	if k := mediapl.Key; k != nil && k.Method != "" && k.Method != "NONE" {
+		return res, errors.New("encrypted I-frame playlists are not supported")
+	}
+	thumbs := thumbnailFrames(segments, opts.Interval.Seconds())
+	if len(thumbs) == 0 {
+		return res, errors.New("no I-frames found in playlist")
+	}
+
+	width, height := opts.Width, thumbnailHeight(opts.Width, resolution)
+	ffmpeg, ok := d.muxer.(*FFmpeg)
+	if !ok {
+		ffmpeg = &FFmpeg{}
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return res, err
+	}
+	tmpDir, err := os.MkdirTemp(d.opts.TempDir, "hls_downloader")
+	if err != nil {
+		return res, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// the frames are extracted once, consecutive thumbnails may show the
+	// same one when the I-frames are further apart than the interval
+	var frames []int
+	for i, t := range thumbs {
+		if i == 0 || t.frame != thumbs[i-1].frame {
+			frames = append(frames, t.frame)
+		}
+	}
+	res.Segments = len(frames)
+	d.log.Printf("Extracting %d I-frames for %d thumbnails...\n", len(frames), len(thumbs))
+
+	var (
+		mu    sync.Mutex
+		inits = make(map[string][]byte) // init sections by URI and byte range
+	)
+	initSection := func(xmap *m3u8.Map) ([]byte, error) {
+		id := fmt.Sprintf("%s@%d/%d", xmap.URI, xmap.Offset, xmap.Limit)
+		mu.Lock()
+		defer mu.Unlock()
+		if data, ok := inits[id]; ok {
+			return data, nil
+		}
+		data, err := d.fetchBytes(ctx, concatUrl(mUrl, xmap.URI), xmap.Limit, xmap.Offset)
+		if err != nil {
+			return nil, fmt.Errorf("init section: %w", err)
+		}
+		inits[id] = data
+		return data, nil
+	}
+	extract := func(i int) (int64, error) {
+		seg := segments[i]
+		var data []byte
+		if maps[i] != nil {
+			init, err := initSection(maps[i])
+			if err != nil {
+				return 0, err
+			}
+			data = append(data, init...)
+		}
+		body, err := d.fetchBytes(ctx, concatUrl(mUrl, seg.URI), seg.Limit, seg.Offset)
+		if err != nil {
+			return 0, err
+		}
+		data = append(data, body...)
+		fName := filepath.Join(tmpDir, fmt.Sprintf("%d.ts", i))
+		if err := os.WriteFile(fName, data, 0644); err != nil {
+			return 0, err
+		}
+		return int64(len(body)), ffmpeg.frame(ctx, fName, frameName(tmpDir, i), width, height)
+	}
+
+	workers := d.opts.Workers
+	if workers <= 0 {
+		workers = 4
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				size, err := extract(i)
+				mu.Lock()
+				if err != nil {
+					res.Failed++
+					if !errors.Is(err, context.Canceled) {
+						d.log.Printf("I-frame %d failed: %s\n", i, err)
+					}
+				} else {
+					res.Downloaded++
+					res.Bytes += size
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, i := range frames {
+		select {
+		case indexes <- i:
+		case <-ctx.Done():
+		}
+	}
+	close(indexes)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return res, err
+	}
+	if res.Failed > 0 {
+		return res, fmt.Errorf("%d of %d I-frames failed", res.Failed, len(frames))
+	}
+
+	// the sheets are tiled from a copy of the thumbnails in tmpDir, the
+	// thumbnails of an earlier run may follow them in dir
+	for n, t := range thumbs {
+		data, err := os.ReadFile(frameName(tmpDir, t.frame))
+		if err != nil {
+			return res, err
+		}
+		for _, dst := range []string{tmpDir, dir} {
+			if err := os.WriteFile(filepath.Join(dst, thumbName(n)), data, 0644); err != nil {
+				return res, err
+			}
+		}
+	}
+	perSheet := opts.Columns * opts.Rows
+	for first := 0; first < len(thumbs); first += perSheet {
+		sheet := filepath.Join(dir, fmt.Sprintf("sprite_%d.jpg", first/perSheet))
+		if err := ffmpeg.tile(ctx, filepath.Join(tmpDir, "thumb_%05d.jpg"), first+1, opts.Columns, opts.Rows, sheet); err != nil {
+			return res, err
+		}
+	}
+	track := thumbnailTrack(thumbs, width, height, opts.Columns, opts.Rows)
+	if err := os.WriteFile(res.Output, []byte(track), 0644); err != nil {
+		return res, err
+	}
+	d.log.Printf("%d thumbnails in %d sprite sheets\n", len(thumbs), (len(thumbs)+perSheet-1)/perSheet)
+	return res, nil
+}
+
+// thumbnailVariant returns the I-frame variant with the lowest bandwidth
+// at least width wide, or the widest one when none is. variants are sorted
+// by descending bandwidth.
+func thumbnailVariant(variants []*Variant, width int) *Variant {
+	var widest *Variant
+	widestW := -1
+	for i := len(variants) - 1; i >= 0; i-- {
+		w, _ := parseResolution(variants[i].Resolution)
+		if w >= width {
+			return variants[i]
+		}
+		if w > widestW {
+			widest, widestW = variants[i], w
+		}
+	}
+	return widest
+}
+
+// parseResolution parses a RESOLUTION attribute, 0x0 when invalid.
+func parseResolution(resolution string) (int, int) {
+	ws, hs, ok := strings.Cut(strings.ToLower(resolution), "x")
+	if !ok {
+		return 0, 0
+	}
+	w, err := strconv.Atoi(ws)
+	if err != nil {
+		return 0, 0
+	}
+	h, err := strconv.Atoi(hs)
+	if err != nil {
+		return 0, 0
+	}
+	return w, h
+}
+
+// thumbnailHeight returns the even height of a thumbnail width wide with
+// the aspect ratio of resolution, 16:9 when it is unknown.
+func thumbnailHeight(width int, resolution string) int {
+	w, h := parseResolution(resolution)
+	if w <= 0 || h <= 0 {
+		w, h = 16, 9
+	}
+	height := (width*h + w/2) / w
+	if height%2 == 1 {
+		height++
+	}
+	return height
+}
+
+// thumbnailFrames returns a thumbnail every interval seconds of the
+// I-frame segments, showing the last I-frame before its start. The
+// EXT-X-GAP segments are never shown, the first I-frame stands for the
+// time before it.
+func thumbnailFrames(segments []*m3u8.MediaSegment, interval float64) []thumbnail {
+	var (
+		starts = make([]float64, len(segments))
+		total  float64
+		first  = -1
+	)
+	for i, seg := range segments {
+		starts[i] = total
+		total += seg.Duration
+		if first < 0 && !seg.Gap {
+			first = i
+		}
+	}
+	if first < 0 {
+		return nil
+	}
+	var thumbs []thumbnail
+	frame := first
+	for n := 0; float64(n)*interval < total; n++ {
+		start := float64(n) * interval
+		for i := frame + 1; i < len(segments) && starts[i] <= start; i++ {
+			if !segments[i].Gap {
+				frame = i
+			}
+		}
+		end := start + interval
+		if end > total {
+			end = total
+		}
+		thumbs = append(thumbs, thumbnail{start: start, end: end, frame: frame})
+	}
+	return thumbs
+}
+
+// thumbnailTrack returns the WebVTT track of thumbs, each cue pointing at
+// the place of its thumbnail in the sprite sheets with a media fragment.
+func thumbnailTrack(thumbs []thumbnail, width, height, columns, rows int) string {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	perSheet := columns * rows
+	for n, t := range thumbs {
+		cell := n % perSheet
+		fmt.Fprintf(&b, "\n%s --> %s\nsprite_%d.jpg#xywh=%d,%d,%d,%d\n",
+			vttTime(t.start), vttTime(t.end), n/perSheet,
+			cell%columns*width, cell/columns*height, width, height)
+	}
+	return b.String()
+}
+
+// vttTime formats seconds as a WebVTT timestamp, hh:mm:ss.ttt.
+func vttTime(seconds float64) string {
+	ms := int64(seconds*1000 + 0.5)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// thumbName returns the file name of the thumbnail n, numbered from 1
+// like the pattern given to ffmpeg.tile.
+func thumbName(n int) string {
+	return fmt.Sprintf("thumb_%05d.jpg", n+1)
+}
+
+func frameName(dir string, i int) string {
+	return filepath.Join(dir, fmt.Sprintf("%d.jpg", i))
+}
@@ -0,0 +1,138 @@
+package downloader
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/grafov/m3u8"
+)
+
+// iframeSegments returns I-frame segments of the durations, the negative
+// ones being EXT-X-GAP segments.
+func iframeSegments(durations ...float64) []*m3u8.MediaSegment {
+	var segments []*m3u8.MediaSegment
+	for _, d := range durations {
+		if d < 0 {
+			segments = append(segments, &m3u8.MediaSegment{Duration: -d, Gap: true})
+		} else {
+			segments = append(segments, &m3u8.MediaSegment{Duration: d})
+		}
+	}
+	return segments
+}
+
+func TestThumbnailFrames(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		segments []*m3u8.MediaSegment
+		interval float64
+		want     []thumbnail
+	}{
+		{
+			name:     "last I-frame before each thumbnail",
+			segments: iframeSegments(4, 4, 4, 4),
+			interval: 5,
+			want:     []thumbnail{{0, 5, 0}, {5, 10, 1}, {10, 15, 2}, {15, 16, 3}},
+		},
+		{
+			// the I-frames are further apart than the interval
+			name:     "repeated I-frames",
+			segments: iframeSegments(6, 6),
+			interval: 2,
+			want:     []thumbnail{{0, 2, 0}, {2, 4, 0}, {4, 6, 0}, {6, 8, 1}, {8, 10, 1}, {10, 12, 1}},
+		},
+		{
+			name:     "gap",
+			segments: iframeSegments(4, -4, 4),
+			interval: 4,
+			want:     []thumbnail{{0, 4, 0}, {4, 8, 0}, {8, 12, 2}},
+		},
+		{
+			// the first I-frame stands for the time before it
+			name:     "leading gap",
+			segments: iframeSegments(-4, 4),
+			interval: 4,
+			want:     []thumbnail{{0, 4, 1}, {4, 8, 1}},
+		},
+		{
+			name:     "only gaps",
+			segments: iframeSegments(-4, -4),
+			interval: 4,
+		},
+		{
+			name:     "empty playlist",
+			interval: 4,
+		},
+	} {
+		if got := thumbnailFrames(tc.segments, tc.interval); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestThumbnailTrack(t *testing.T) {
+	thumbs := []thumbnail{{0, 10, 0}, {10, 20, 1}, {20, 30, 2}, {30, 35, 3}, {35, 40, 4}}
+	want := `WEBVTT
+
+00:00:00.000 --> 00:00:10.000
+sprite_0.jpg#xywh=0,0,160,90
+
+00:00:10.000 --> 00:00:20.000
+sprite_0.jpg#xywh=160,0,160,90
+
+00:00:20.000 --> 00:00:30.000
+sprite_0.jpg#xywh=0,90,160,90
+
+00:00:30.000 --> 00:00:35.000
+sprite_0.jpg#xywh=160,90,160,90
+
+00:00:35.000 --> 00:00:40.000
+sprite_1.jpg#xywh=0,0,160,90
+`
+	if got := thumbnailTrack(thumbs, 160, 90, 2, 2); got != want {
+		t.Errorf("got track:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestVTTTime(t *testing.T) {
+	for seconds, want := range map[float64]string{
+		0:       "00:00:00.000",
+		1.5:     "00:00:01.500",
+		59.9996: "00:01:00.000",
+		3661.25: "01:01:01.250",
+		36000:   "10:00:00.000",
+	} {
+		if got := vttTime(seconds); got != want {
+			t.Errorf("%g: got %s, want %s", seconds, got, want)
+		}
+	}
+}
+
+func TestThumbnailSize(t *testing.T) {
+	for _, tc := range []struct {
+		width      int
+		resolution string
+		want       int
+	}{
+		{160, "1920x1080", 90},
+		{160, "640x480", 120},
+		{100, "1280x720", 56},
+		{160, "", 90},
+		{160, "wide", 90},
+	} {
+		if got := thumbnailHeight(tc.width, tc.resolution); got != tc.want {
+			t.Errorf("%d wide from %q: got height %d, want %d", tc.width, tc.resolution, got, tc.want)
+		}
+	}
+
+	variants := []*Variant{
+		{Variant: &m3u8.Variant{VariantParams: m3u8.VariantParams{Bandwidth: 300, Resolution: "1280x720"}}},
+		{Variant: &m3u8.Variant{VariantParams: m3u8.VariantParams{Bandwidth: 200, Resolution: "640x360"}}},
+		{Variant: &m3u8.Variant{VariantParams: m3u8.VariantParams{Bandwidth: 100, Resolution: "320x180"}}},
+	}
+	for width, want := range map[int]string{160: "320x180", 320: "320x180", 400: "640x360", 1920: "1280x720"} {
+		if got := thumbnailVariant(variants, width); got.Resolution != want {
+			t.Errorf("%d wide: got variant %s, want %s", width, got.Resolution, want)
+		}
+	}
+}
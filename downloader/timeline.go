@@ -0,0 +1,104 @@
+package downloader
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/grafov/m3u8"
+)
+
+// Timeline maps the playback offsets of an output to the wall-clock time
+// of its segments, written next to the output with Options.Timeline.
+type Timeline struct {
+	Output   string            `json:"output"`
+	Start    time.Time         `json:"start"` // wall-clock time of the start of the output
+	Segments []TimelineSegment `json:"segments"`
+}
+
+// TimelineSegment places a segment in the output and in time.
+type TimelineSegment struct {
+	Sequence        uint64    `json:"sequence"` // media sequence number
+	URI             string    `json:"uri"`
+	Offset          float64   `json:"offset"`   // seconds from the start of the output, negative when the segment is cut
+	Duration        float64   `json:"duration"` // seconds in the output, a filled gap included
+	ProgramDateTime time.Time `json:"program_date_time"`
+	Derived         bool      `json:"derived,omitempty"` // the segment has no EXT-X-PROGRAM-DATE-TIME, its time follows the others
+}
+
+// TimelineName returns the file the timeline of output is written to,
+// output with a .timeline.json extension instead of .mp4.
+func TimelineName(output string) string {
+	return strings.TrimSuffix(output, ".mp4") + ".timeline.json"
+}
+
+// wallClocks returns the wall-clock start time of the playlist segments,
+// see wallClock, or nil when they have no EXT-X-PROGRAM-DATE-TIME. It is
+// computed on the whole playlist, before segments are removed, for the
+// segments without their own tag to keep their place in time.
+func wallClocks(segments []*m3u8.MediaSegment) map[*m3u8.MediaSegment]time.Time {
+	starts, ok := wallClock(segments)
+	if !ok {
+		return nil
+	}
+	wall := make(map[*m3u8.MediaSegment]time.Time, len(segments))
+	for i, seg := range segments {
+		wall[seg] = starts[i]
+	}
+	return wall
+}
+
+// timeline returns the timeline of the output joining runs, trimmed to
+// start and length like the muxer does, or nil when the segments have no
+// EXT-X-PROGRAM-DATE-TIME. wall holds the time of the playlist segments,
+// see wallClocks.
+func timeline(runs []*run, wall map[*m3u8.MediaSegment]time.Time, start, length float64) *Timeline {
+	if len(wall) == 0 {
+		return nil
+	}
+	var (
+		segments  []*m3u8.MediaSegment
+		durations []float64
+	)
+	for _, r := range runs {
+		segments = append(segments, r.segments...)
+		durations = append(durations, r.durations...)
+	}
+	tl := &Timeline{}
+	var offset float64
+	for i, seg := range segments {
+		segStart, segEnd := offset-start, offset+durations[i]-start
+		offset += durations[i]
+		if segEnd <= 0 || (length > 0 && segStart >= length) {
+			continue
+		}
+		ts := TimelineSegment{
+			Sequence:        seg.SeqId,
+			URI:             seg.URI,
+			Offset:          segStart,
+			Duration:        durations[i],
+			ProgramDateTime: wall[seg],
+			Derived:         seg.ProgramDateTime.IsZero(),
+		}
+		if len(tl.Segments) == 0 {
+			tl.Start = wall[seg].Add(-secondsToDuration(segStart))
+		}
+		tl.Segments = append(tl.Segments, ts)
+	}
+	if len(tl.Segments) == 0 {
+		return nil
+	}
+	return tl
+}
+
+// writeTimeline writes tl as JSON to TimelineName(output).
+func writeTimeline(output string, tl *Timeline) (string, error) {
+	tl.Output = output
+	data, err := json.MarshalIndent(tl, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	name := TimelineName(output)
+	return name, os.WriteFile(name, append(data, '\n'), 0644)
+}
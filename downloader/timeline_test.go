@@ -0,0 +1,55 @@
+package downloader
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafov/m3u8"
+)
+
+func TestTimelineAfterRemovedSegments(t *testing.T) {
+	t0 := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	var segments []*m3u8.MediaSegment
+	for i := 0; i < 6; i++ {
+		segments = append(segments, &m3u8.MediaSegment{SeqId: uint64(i), Duration: 10})
+	}
+	segments[0].ProgramDateTime = t0
+	wall := wallClocks(segments)
+
+	// segments 2 and 3 were an ad break, removed before stitching
+	runs := []*run{
+		{segments: segments[:2], durations: []float64{10, 10}},
+		{segments: segments[4:], durations: []float64{10, 10}},
+	}
+	tl := timeline(runs, wall, 5, 0)
+	if tl == nil {
+		t.Fatal("no timeline")
+	}
+	if !tl.Start.Equal(t0.Add(5 * time.Second)) {
+		t.Errorf("got start %s, want %s", tl.Start, t0.Add(5*time.Second))
+	}
+	want := []TimelineSegment{
+		{Sequence: 0, Offset: -5, Duration: 10, ProgramDateTime: t0},
+		{Sequence: 1, Offset: 5, Duration: 10, ProgramDateTime: t0.Add(10 * time.Second), Derived: true},
+		{Sequence: 4, Offset: 15, Duration: 10, ProgramDateTime: t0.Add(40 * time.Second), Derived: true},
+		{Sequence: 5, Offset: 25, Duration: 10, ProgramDateTime: t0.Add(50 * time.Second), Derived: true},
+	}
+	if len(tl.Segments) != len(want) {
+		t.Fatalf("got %d segments, want %d", len(tl.Segments), len(want))
+	}
+	for i, got := range tl.Segments {
+		w := want[i]
+		if got.Sequence != w.Sequence || got.Offset != w.Offset || got.Duration != w.Duration ||
+			!got.ProgramDateTime.Equal(w.ProgramDateTime) || got.Derived != w.Derived {
+			t.Errorf("segment %d: got %+v, want %+v", i, got, w)
+		}
+	}
+}
+
+func TestTimelineWithoutProgramDateTime(t *testing.T) {
+	segments := []*m3u8.MediaSegment{{Duration: 10}, {Duration: 10}}
+	runs := []*run{{segments: segments, durations: []float64{10, 10}}}
+	if tl := timeline(runs, wallClocks(segments), 0, 0); tl != nil {
+		t.Errorf("got timeline %+v, want none", tl)
+	}
+}
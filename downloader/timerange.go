@@ -0,0 +1,188 @@
+package downloader
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafov/m3u8"
+)
+
+// Bound is the start or end of a range to download, either an offset from
+// the start of the playlist or a wall-clock time matched against
+// EXT-X-PROGRAM-DATE-TIME. The zero value is an unset bound. Bound
+// implements flag.Value.
+type Bound struct {
+	set    bool
+	offset float64 // seconds
+	wall   time.Time
+}
+
+// String implements flag.Value.
+func (b *Bound) String() string {
+	if !b.set {
+		return ""
+	}
+	if !b.wall.IsZero() {
+		return b.wall.Format(time.RFC3339)
+	}
+	return strconv.FormatFloat(b.offset, 'f', -1, 64)
+}
+
+// Set implements flag.Value. It accepts seconds (90.5), clock notation
+// (01:02:03.5 or 02:03), Go durations (1h2m3s) and RFC 3339 timestamps.
+func (b *Bound) Set(value string) error {
+	value = strings.TrimSpace(value)
+	if t, err := m3u8.FullTimeParse(value); err == nil {
+		*b = Bound{set: true, wall: t}
+		return nil
+	}
+	if s, err := strconv.ParseFloat(value, 64); err == nil {
+		*b = Bound{set: true, offset: s}
+		return b.validate()
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		*b = Bound{set: true, offset: d.Seconds()}
+		return b.validate()
+	}
+	parts := strings.Split(value, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return fmt.Errorf("invalid time %q", value)
+	}
+	var secs float64
+	for _, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return fmt.Errorf("invalid time %q", value)
+		}
+		secs = secs*60 + v
+	}
+	*b = Bound{set: true, offset: secs}
+	return b.validate()
+}
+
+// ParseBound parses a bound in any of the formats accepted by Set.
+func ParseBound(value string) (Bound, error) {
+	var b Bound
+	err := b.Set(value)
+	return b, err
+}
+
+// IsSet reports whether the bound was given.
+func (b Bound) IsSet() bool {
+	return b.set
+}
+
+func (b *Bound) validate() error {
+	if b.offset < 0 {
+		return errors.New("time must not be negative")
+	}
+	return nil
+}
+
+// resolve returns the bound as an offset in seconds from the start of the
+// playlist, converting wall-clock bounds with the program date-time of
+// the segments.
+func (b *Bound) resolve(segments []*m3u8.MediaSegment) (float64, error) {
+	if b.wall.IsZero() {
+		return b.offset, nil
+	}
+	starts, ok := wallClock(segments)
+	if !ok {
+		return 0, errors.New("playlist has no EXT-X-PROGRAM-DATE-TIME, use offsets instead of timestamps")
+	}
+	var offset float64
+	for i, s := range segments {
+		if b.wall.Before(starts[i].Add(secondsToDuration(s.Duration))) {
+			offset += b.wall.Sub(starts[i]).Seconds()
+			if offset < 0 {
+				offset = 0
+			}
+			return offset, nil
+		}
+		offset += s.Duration
+	}
+	return offset, nil
+}
+
+// wallClock returns the wall-clock start time of every segment. Segments
+// without their own EXT-X-PROGRAM-DATE-TIME are placed right after the
+// previous one, and those before the first tag right before the next one.
+func wallClock(segments []*m3u8.MediaSegment) ([]time.Time, bool) {
+	first := -1
+	for i, s := range segments {
+		if !s.ProgramDateTime.IsZero() {
+			first = i
+			break
+		}
+	}
+	if first < 0 {
+		return nil, false
+	}
+	starts := make([]time.Time, len(segments))
+	starts[first] = segments[first].ProgramDateTime
+	for i := first - 1; i >= 0; i-- {
+		starts[i] = starts[i+1].Add(-secondsToDuration(segments[i].Duration))
+	}
+	for i := first + 1; i < len(segments); i++ {
+		starts[i] = segments[i].ProgramDateTime
+		if starts[i].IsZero() {
+			starts[i] = starts[i-1].Add(secondsToDuration(segments[i-1].Duration))
+		}
+	}
+	return starts, true
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// clip is the part of the playlist selected with Options.Start and End.
+type clip struct {
+	segments []*m3u8.MediaSegment
+	offset   float64 // seconds from the first selected segment to the start
+	length   float64 // seconds, 0 when the clip runs to the end
+}
+
+// selectRange keeps the segments overlapping [start, end). The offset and
+// length of the clip allow ffmpeg to trim precisely within the segments.
+func selectRange(segments []*m3u8.MediaSegment, start, end Bound) (*clip, error) {
+	from, err := start.resolve(segments)
+	if err != nil {
+		return nil, err
+	}
+	to := -1.0
+	if end.set {
+		if to, err = end.resolve(segments); err != nil {
+			return nil, err
+		}
+		if to <= from {
+			return nil, errors.New("end of the range must be after its start")
+		}
+	}
+
+	c := &clip{}
+	var pos float64
+	first := -1.0
+	for _, s := range segments {
+		segStart, segEnd := pos, pos+s.Duration
+		pos = segEnd
+		if segEnd <= from || (to >= 0 && segStart >= to) {
+			continue
+		}
+		if first < 0 {
+			first = segStart
+		}
+		c.segments = append(c.segments, s)
+	}
+	if len(c.segments) == 0 {
+		return nil, fmt.Errorf("no segments in range, playlist duration is %.3fs", pos)
+	}
+	c.offset = from - first
+	if to >= 0 {
+		c.length = to - from
+	}
+	return c, nil
+}
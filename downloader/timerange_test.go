@@ -0,0 +1,156 @@
+package downloader
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafov/m3u8"
+)
+
+func TestParseBound(t *testing.T) {
+	for _, tc := range []struct {
+		value  string
+		offset float64
+		wall   string
+		err    bool
+	}{
+		{value: "90", offset: 90},
+		{value: "90.5", offset: 90.5},
+		{value: "02:03", offset: 123},
+		{value: "1:02:03.5", offset: 3723.5},
+		{value: "1h2m", offset: 3720},
+		{value: "2026-03-01T10:00:00Z", wall: "2026-03-01T10:00:00Z"},
+		{value: "2026-03-01T12:00:00+02:00", wall: "2026-03-01T10:00:00Z"},
+		{value: "-5", err: true},
+		{value: "1:2:3:4", err: true},
+		{value: "soon", err: true},
+	} {
+		b, err := ParseBound(tc.value)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%q: got bound %s, want an error", tc.value, b.String())
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", tc.value, err)
+			continue
+		}
+		if !b.IsSet() || b.offset != tc.offset {
+			t.Errorf("%q: got offset %g, want %g", tc.value, b.offset, tc.offset)
+		}
+		if tc.wall != "" {
+			want, _ := time.Parse(time.RFC3339, tc.wall)
+			if !b.wall.Equal(want) {
+				t.Errorf("%q: got time %s, want %s", tc.value, b.wall, want)
+			}
+		} else if !b.wall.IsZero() {
+			t.Errorf("%q: got time %s, want an offset", tc.value, b.wall)
+		}
+	}
+}
+
+// rangeSegments returns 10 second segments, with a program date-time on
+// the segments of pdt.
+func rangeSegments(n int, pdt map[int]time.Time) []*m3u8.MediaSegment {
+	var segments []*m3u8.MediaSegment
+	for i := 0; i < n; i++ {
+		segments = append(segments, &m3u8.MediaSegment{SeqId: uint64(i), Duration: 10, ProgramDateTime: pdt[i]})
+	}
+	return segments
+}
+
+func TestSelectRange(t *testing.T) {
+	t0 := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name           string
+		pdt            map[int]time.Time
+		start, end     string
+		first, last    uint64
+		offset, length float64
+		err            bool
+	}{
+		{name: "offsets", start: "15", end: "35", first: 1, last: 3, offset: 5, length: 20},
+		{name: "start only", start: "20", first: 2, last: 5},
+		{name: "end only", end: "10", first: 0, last: 0, length: 10},
+		{name: "segment boundaries", start: "10", end: "30", first: 1, last: 2, length: 20},
+		{
+			name:  "program date-time",
+			pdt:   map[int]time.Time{0: t0},
+			start: "2026-03-01T10:00:25Z", end: "2026-03-01T10:00:41Z",
+			first: 2, last: 4, offset: 5, length: 16,
+		},
+		{
+			// the segments before the first tag are placed before it
+			name:  "program date-time from a later segment",
+			pdt:   map[int]time.Time{2: t0.Add(20 * time.Second)},
+			start: "2026-03-01T10:00:05Z", end: "40",
+			first: 0, last: 3, offset: 5, length: 35,
+		},
+		{
+			// a time before the playlist starts at its beginning
+			name:  "program date-time before the playlist",
+			pdt:   map[int]time.Time{0: t0},
+			start: "2026-03-01T09:00:00Z", end: "20",
+			first: 0, last: 1, length: 20,
+		},
+		{
+			// the time of segment 3 jumps, the tag is followed
+			name:  "program date-time jump",
+			pdt:   map[int]time.Time{0: t0, 3: t0.Add(time.Hour)},
+			start: "2026-03-01T11:00:00Z",
+			first: 3, last: 5,
+		},
+		{name: "program date-time missing", start: "2026-03-01T10:00:00Z", err: true},
+		{name: "end before start", start: "30", end: "20", err: true},
+		{name: "after the end", start: "70", err: true},
+	} {
+		var start, end Bound
+		if tc.start != "" {
+			start, _ = ParseBound(tc.start)
+		}
+		if tc.end != "" {
+			end, _ = ParseBound(tc.end)
+		}
+		c, err := selectRange(rangeSegments(6, tc.pdt), start, end)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%s: got %d segments, want an error", tc.name, len(c.segments))
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", tc.name, err)
+			continue
+		}
+		first, last := c.segments[0].SeqId, c.segments[len(c.segments)-1].SeqId
+		if first != tc.first || last != tc.last || c.offset != tc.offset || c.length != tc.length {
+			t.Errorf("%s: got segments %d to %d, offset %g, length %g, want %d to %d, %g, %g", tc.name,
+				first, last, c.offset, c.length, tc.first, tc.last, tc.offset, tc.length)
+		}
+	}
+}
+
+func TestWallClock(t *testing.T) {
+	t0 := time.Date(2026, 3, 1, 10, 0, 0, 0, time.UTC)
+	segments := rangeSegments(5, map[int]time.Time{1: t0, 3: t0.Add(time.Minute)})
+	got, ok := wallClock(segments)
+	if !ok {
+		t.Fatal("no wall clock")
+	}
+	want := []time.Time{
+		t0.Add(-10 * time.Second), // before the first tag
+		t0,
+		t0.Add(10 * time.Second), // after the previous segment
+		t0.Add(time.Minute),      // its own tag
+		t0.Add(time.Minute + 10*time.Second),
+	}
+	for i := range want {
+		if !got[i].Equal(want[i]) {
+			t.Errorf("segment %d starts at %s, want %s", i, got[i], want[i])
+		}
+	}
+	if _, ok := wallClock(rangeSegments(2, nil)); ok {
+		t.Error("wall clock without program date-time")
+	}
+}
@@ -0,0 +1,429 @@
+package downloader
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/grafov/m3u8"
+)
+
+var (
+	ErrEmptySegment = fmt.Errorf("empty segment")
+)
+
+// StatusError is returned when the server answers with a non 200
+// status code.
+type StatusError struct {
+	URL        string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("failed to get %s: status code %d", e.URL, e.StatusCode)
+}
+
+// permanent reports whether retrying the request can't help, so the
+// download should move on to the next mirror right away.
+func permanent(err error) bool {
+	var sErr *StatusError
+	if !errors.As(err, &sErr) {
+		return false
+	}
+	code := sErr.StatusCode
+	return code >= 400 && code < 500 && code != http.StatusRequestTimeout && code != http.StatusTooManyRequests
+}
+
+func concatUrl(base *url.URL, path string) *url.URL {
+	if strings.Contains(path, "http") {
+		uri, _ := url.Parse(path)
+		return uri
+	}
+	// keep the query of the reference out of the path
+	query := ""
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path, query = path[:i], path[i+1:]
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = base.Path[:strings.LastIndex(base.Path, "/")] + "/" + path
+	}
+	// url with scheme and domain
+	return &url.URL{
+		Scheme:   base.Scheme,
+		Host:     base.Host,
+		Path:     path,
+		RawQuery: query,
+	}
+}
+
+// fetch sends a GET request for uri and returns the response of a 200
+// answer, the caller must close its body.
+func (d *Downloader) fetch(ctx context.Context, uri *url.URL) (*http.Response, error) {
+	return d.fetchRange(ctx, uri, 0, 0)
+}
+
+// fetchRange is fetch for the limit bytes of uri starting at offset, the
+// whole resource when limit is 0. A 206 answer is accepted for a range.
+func (d *Downloader) fetchRange(ctx context.Context, uri *url.URL, limit, offset int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "hls_downloader")
+	for name, values := range d.opts.Headers {
+		req.Header[name] = values
+	}
+	if limit > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+limit-1))
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && !(limit > 0 && resp.StatusCode == http.StatusPartialContent) {
+		resp.Body.Close()
+		return nil, &StatusError{URL: uri.String(), StatusCode: resp.StatusCode}
+	}
+	return resp, nil
+}
+
+// get returns the body of uri.
+func (d *Downloader) get(ctx context.Context, uri *url.URL) ([]byte, error) {
+	resp, err := d.fetch(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// fetchBytes downloads the limit bytes at offset of uri, the whole
+// resource when limit is 0, retrying with the retry policy.
+func (d *Downloader) fetchBytes(ctx context.Context, uri *url.URL, limit, offset int64) ([]byte, error) {
+	var data []byte
+	err := backoff.Retry(func() error {
+		ctx, cancel := context.WithTimeout(ctx, time.Minute)
+		defer cancel()
+		resp, err := d.fetchRange(ctx, uri, limit, offset)
+		if err != nil {
+			if permanent(err) {
+				return backoff.Permanent(err)
+			}
+			return err
+		}
+		defer resp.Body.Close()
+		var body io.Reader = resp.Body
+		if limit > 0 && resp.StatusCode == http.StatusOK {
+			// the server ignored the range
+			if _, err := io.CopyN(io.Discard, body, offset); err != nil {
+				return err
+			}
+			body = io.LimitReader(body, limit)
+		}
+		data, err = io.ReadAll(body)
+		if err == nil && len(data) == 0 {
+			err = ErrEmptySegment
+		}
+		return err
+	}, d.opts.Retry.backOff(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// downloadSegment streams the segment at uri into fileName. When tp is not
+// nil the segment size and the bytes are reported to it as segment index.
+func (d *Downloader) downloadSegment(ctx context.Context, uri *url.URL, fileName string, tp *transferProgress, index int) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	resp, err := d.fetch(ctx, uri)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	f, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var body io.Reader = resp.Body
+	if tp != nil {
+		if resp.ContentLength > 0 {
+			tp.setSize(index, resp.ContentLength)
+		}
+		body = &progressReader{Reader: resp.Body, onRead: tp.add}
+	}
+	n, err := io.Copy(f, body)
+	if err == nil && n == 0 {
+		err = ErrEmptySegment
+	}
+	if err != nil && tp != nil {
+		// roll back the partial download, it is fetched again on retry
+		tp.add(-n)
+	}
+	return err
+}
+
+type task struct {
+	index   int
+	segment *m3u8.MediaSegment
+}
+
+type finishTask struct {
+	task
+	fileName string
+}
+
+type downloadInput struct {
+	playlistKey  *m3u8.Key
+	variantUrl   *url.URL
+	mirrorUrls   []*url.URL // variant playlists of the redundant streams
+	mirrorHosts  []string   // hosts given in Options.Mirrors
+	segments     []*m3u8.MediaSegment
+	tmpDir       string
+	numOfWorkers int
+}
+
+// downloadSegments downloads the segments of input and returns their
+// files in playback order. EXT-X-GAP segments aren't requested, their file
+// is empty.
+func (dl *download) downloadSegments(ctx context.Context, input *downloadInput) ([]string, error) {
+	tasks := make([]task, len(input.segments))
+	for i, segment := range input.segments {
+		tasks[i] = task{
+			index:   i,
+			segment: segment,
+		}
+	}
+
+	var playlistKeyBody []byte
+	if k := input.playlistKey; k != nil && k.Method == "AES-128" {
+		body, err := dl.key(ctx, concatUrl(input.variantUrl, k.URI))
+		if err != nil {
+			return nil, err
+		}
+		playlistKeyBody = body
+	}
+
+	if dl.opts.Verbose {
+		dl.log.Printf("Total segments to download: %d\n", len(tasks))
+	}
+
+	// thread-safe map to store the finished tasks
+	cFinishedTasks := sync.Map{}
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, input.numOfWorkers)
+	for i, tsk := range tasks {
+		if tsk.segment.Gap {
+			if dl.opts.Verbose {
+				dl.log.Printf("Segment %d is a gap, skipped\n", i)
+			}
+			cFinishedTasks.Store(i, finishTask{task: tsk})
+			continue
+		}
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int, tsk task) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if slots := dl.opts.Slots; slots != nil {
+				select {
+				case slots <- struct{}{}:
+					defer func() { <-slots }()
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			fName := filepath.Join(input.tmpDir, fmt.Sprintf("%d.ts", i))
+			// the segment is processed in partName and renamed once
+			// complete, so an existing fName is left by an earlier attempt
+			// of the download sharing the work directory
+			partName := fName + ".part"
+			if info, err := os.Stat(fName); err == nil && info.Size() > 0 {
+				dl.transfer.reuse(i, info.Size())
+				if dl.opts.Verbose {
+					dl.log.Printf("Segment %d already downloaded\n", i)
+				}
+				dl.emit(Event{Type: EventSegmentFinished, Segment: intPtr(i), Bytes: info.Size(), Resumed: true})
+				cFinishedTasks.Store(i, finishTask{
+					task:     tsk,
+					fileName: fName,
+				})
+				return
+			}
+
+			urls := segmentUrls(input, tsk.segment.URI)
+			segmentSt := time.Now()
+			dl.emit(Event{Type: EventSegmentStarted, Segment: intPtr(i), URL: urls[0].String()})
+			var err error
+			for m, uri := range urls {
+				if dl.opts.Verbose {
+					if m == 0 {
+						dl.log.Printf("Downloading segment %d/%d: %s\n", i, len(input.segments), uri.String())
+					} else {
+						dl.log.Printf("Retrying segment %d from mirror: %s\n", i, uri.String())
+					}
+				}
+				err = backoff.Retry(func() error {
+					err := dl.downloadSegment(ctx, uri, partName, dl.transfer, i)
+					if permanent(err) {
+						return backoff.Permanent(err)
+					}
+					return err
+				}, dl.opts.Retry.backOff(ctx))
+				if err == nil || errors.Is(err, context.Canceled) {
+					break
+				}
+			}
+			if err != nil {
+				if errors.Is(err, context.Canceled) {
+					return
+				}
+				dl.log.Printf("download segment %d failed: %s\n", i, err)
+				dl.emit(Event{Type: EventSegmentFailed, Segment: intPtr(i), Error: err.Error()})
+				return
+			}
+
+			encKey := input.playlistKey
+			keyBody := playlistKeyBody
+			if tk := tsk.segment.Key; tk != nil {
+				encKey = tk
+				if k := input.playlistKey; k != nil && k.Method == "AES-128" {
+					body, err := dl.key(ctx, concatUrl(input.variantUrl, k.URI))
+					if err != nil {
+						dl.log.Printf("%v\n", err)
+						return
+					}
+					keyBody = body
+				}
+			}
+
+			// handle decryption if needed
+			if len(keyBody) > 0 {
+				segmentData, err := os.ReadFile(partName)
+				if err != nil {
+					dl.log.Printf("read segment %d for decryption failed: %s\n", i, err)
+					return
+				}
+				decryptedData, err := decryptAES128CBC(keyBody, encKey.IV, segmentData)
+				if err != nil {
+					dl.log.Printf("decrypt segment %d failed: %s\n", i, err)
+					return
+				}
+				if err := os.WriteFile(partName, decryptedData, 0644); err != nil {
+					dl.log.Printf("write decrypted segment %d failed: %s\n", i, err)
+					return
+				}
+				if dl.opts.Verbose {
+					dl.log.Printf("Segment %d decrypted\n", i)
+				}
+			}
+
+			if err := os.Rename(partName, fName); err != nil {
+				dl.log.Printf("save segment %d failed: %s\n", i, err)
+				return
+			}
+
+			if info, err := os.Stat(fName); err == nil {
+				dl.emit(Event{
+					Type:     EventSegmentFinished,
+					Segment:  intPtr(i),
+					Bytes:    info.Size(),
+					Duration: seconds(time.Since(segmentSt)),
+				})
+			}
+
+			cFinishedTasks.Store(i, finishTask{
+				task:     tsk,
+				fileName: fName,
+			})
+		}(i, tsk)
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	finishedTasks := make([]finishTask, len(tasks))
+	cFinishedTasks.Range(func(key, value interface{}) bool {
+		finishedTasks[key.(int)] = value.(finishTask)
+		return true
+	})
+
+	retryCount := 5
+
+sort_tasks:
+	// sort the finished tasks by index to preserve the segments order
+	sort.Slice(finishedTasks, func(i, j int) bool {
+		return finishedTasks[i].index < finishedTasks[j].index
+	})
+	// validate the segments order
+	for i, tsk := range finishedTasks {
+		if tsk.index != i {
+			if retryCount == 0 {
+				return nil, fmt.Errorf(
+					"download segments failed, %d against %d, %d retries exceeded",
+					i,
+					tsk.index,
+					retryCount,
+				)
+			}
+			retryCount--
+			goto sort_tasks
+		}
+	}
+	files := make([]string, len(finishedTasks))
+	for i, tsk := range finishedTasks {
+		files[i] = tsk.fileName
+	}
+	return files, nil
+}
+
+func decryptAES128CBC(keyBody []byte, rawIv string, segmentBody []byte) ([]byte, error) {
+	if len(rawIv) >= 2 && rawIv[0:2] == "0x" {
+		rawIv = rawIv[2:]
+	}
+	iv, err := hex.DecodeString(rawIv)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(keyBody)
+	if err != nil {
+		return nil, err
+	}
+	if len(segmentBody)%aes.BlockSize != 0 {
+		return nil, err
+	}
+
+	mode := cipher.NewCBCDecrypter(block, iv)
+	plaintext := make([]byte, len(segmentBody))
+	mode.CryptBlocks(plaintext, segmentBody)
+	// Now remove PKCS#7 padding:
+	padLen := int(plaintext[len(plaintext)-1])
+	if padLen > 0 && padLen <= aes.BlockSize {
+		plaintext = plaintext[:len(plaintext)-padLen]
+	}
+
+	return plaintext, nil
+}
@@ -6,6 +6,8 @@ require (
 	github.com/cenkalti/backoff/v4 v4.2.1
 	github.com/grafov/m3u8 v0.12.0
 	github.com/schollz/progressbar/v3 v3.13.1
+	golang.org/x/term v0.12.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -13,5 +15,6 @@ require (
 	github.com/mitchellh/colorstring v0.0.0-20190213212951-d06e56a500db // indirect
 	github.com/rivo/uniseg v0.4.4 // indirect
 	golang.org/x/sys v0.12.0 // indirect
-	golang.org/x/term v0.12.0 // indirect
 )
+
+replace github.com/grafov/m3u8 => ./m3u8
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"hls_downloader/downloader"
+)
+
+// info prints the variants, renditions and media playlists of a stream.
+func info(args []string) {
+	fs := newFlagSet("info", "[flags] <url>")
+	var (
+		client  clientFlags
+		u       string
+		jsonOut bool
+	)
+	client.register(fs)
+	fs.StringVar(&u, "url", "", "Master or media playlist url, may be given as argument")
+	fs.BoolVar(&jsonOut, "json", false, "Print the details as JSON")
+	fs.Parse(args)
+	u = playlistURL(fs, u)
+
+	cfg, err := client.load(fs)
+	if err != nil {
+		log.Panicln(err)
+	}
+	opts, err := cfg.options()
+	if err != nil {
+		log.Panicln(err)
+	}
+	pi, err := downloader.New(opts).Inspect(context.Background(), u)
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	if jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(pi)
+		return
+	}
+	printInfo(os.Stdout, pi)
+}
+
+// printInfo writes pi as tables, followed by the keys and SCTE cues of the
+// media playlists.
+func printInfo(w io.Writer, pi *downloader.PlaylistInfo) {
+	fmt.Fprintf(w, "%s playlist %s", strings.ToUpper(pi.Type[:1])+pi.Type[1:], pi.URL)
+	if pi.Version > 0 {
+		fmt.Fprintf(w, " (version %d)", pi.Version)
+	}
+	fmt.Fprintln(w)
+
+	var details []*downloader.MediaInfo
+	if pi.Media != nil {
+		fmt.Fprintln(w)
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, mediaHeader)
+		fmt.Fprintln(tw, mediaColumns(pi.Media))
+		tw.Flush()
+		details = append(details, pi.Media)
+	}
+
+	printVariants := func(title string, variants []downloader.VariantInfo) {
+		if len(variants) == 0 {
+			return
+		}
+		fmt.Fprintf(w, "\n%s:\n", title)
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "#\tNAME\tBANDWIDTH\tRESOLUTION\tFPS\tCODECS\tGROUPS\t"+mediaHeader+"\tURI")
+		for _, v := range variants {
+			index := fmt.Sprint(v.Index)
+			if v.Index < 0 {
+				index = "-"
+			}
+			var groups []string
+			for _, g := range []string{v.Audio, v.Video, v.Subtitles, v.Captions} {
+				if g != "" {
+					groups = append(groups, g)
+				}
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				index, v.Name, v.Bandwidth, dash(v.Resolution), dash(formatFloat(v.FrameRate)), dash(v.Codecs),
+				dash(strings.Join(groups, ",")), mediaColumns(v.Playlist), v.URI)
+			details = append(details, v.Playlist)
+		}
+		tw.Flush()
+	}
+	printVariants("Variants", pi.Variants)
+	printVariants("I-frame variants", pi.IFrames)
+
+	if len(pi.Alternatives) > 0 {
+		fmt.Fprintf(w, "\nAlternatives:\n")
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "TYPE\tGROUP\tNAME\tLANGUAGE\tDEFAULT\t"+mediaHeader+"\tURI")
+		for _, a := range pi.Alternatives {
+			columns := strings.Repeat("-\t", strings.Count(mediaHeader, "\t")) + "-"
+			if a.Playlist != nil {
+				columns = mediaColumns(a.Playlist)
+				details = append(details, a.Playlist)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%t\t%s\t%s\n",
+				a.Type, a.GroupID, a.Name, dash(a.Language), a.Default, columns, dash(a.URI))
+		}
+		tw.Flush()
+	}
+
+	for _, mi := range details {
+		if mi == nil || (mi.Error == "" && len(mi.Keys) == 0 && len(mi.SCTE) == 0 && len(mi.InitSections) == 0) {
+			continue
+		}
+		fmt.Fprintf(w, "\n%s:\n", mi.URL)
+		if mi.Error != "" {
+			fmt.Fprintf(w, "  error: %s\n", mi.Error)
+		}
+		for _, uri := range mi.InitSections {
+			fmt.Fprintf(w, "  init section: %s\n", uri)
+		}
+		for _, k := range mi.Keys {
+			fmt.Fprintf(w, "  key: %s %s segments %d-%d", k.Method, dash(k.URI), k.First, k.Last)
+			if k.KeyFormat != "" {
+				fmt.Fprintf(w, " keyformat %s", k.KeyFormat)
+			}
+			if k.IV != "" {
+				fmt.Fprintf(w, " iv %s", k.IV)
+			}
+			fmt.Fprintln(w)
+		}
+		for _, c := range mi.SCTE {
+			fmt.Fprintf(w, "  scte35 %s (%s): at %s, segment %d", c.Cue, c.Syntax, formatSeconds(c.Offset), c.Segment)
+			if c.ID != "" {
+				fmt.Fprintf(w, ", id %s", c.ID)
+			}
+			if c.Time > 0 {
+				fmt.Fprintf(w, ", duration %s", formatSeconds(c.Time))
+			}
+			fmt.Fprintln(w)
+		}
+	}
+}
+
+const mediaHeader = "TYPE\tSEGMENTS\tDURATION\tTARGET\tENCRYPTION\tBYTERANGES\tDISCONTINUITIES\tSCTE"
+
+// mediaColumns returns the columns of mediaHeader for mi.
+func mediaColumns(mi *downloader.MediaInfo) string {
+	if mi == nil || mi.Error != "" {
+		return "error" + strings.Repeat("\t-", strings.Count(mediaHeader, "\t"))
+	}
+	kind := mi.PlaylistType
+	if kind == "" {
+		kind = "LIVE"
+		if !mi.Live {
+			kind = "VOD"
+		}
+	}
+	methods := []string{}
+	seen := map[string]bool{}
+	for _, k := range mi.Keys {
+		if !seen[k.Method] {
+			seen[k.Method] = true
+			methods = append(methods, k.Method)
+		}
+	}
+	return fmt.Sprintf("%s\t%d\t%s\t%s\t%s\t%d\t%d\t%d",
+		kind, mi.Segments, formatSeconds(mi.Duration), formatSeconds(mi.TargetDuration),
+		dash(strings.Join(methods, ",")), mi.ByteRanges, mi.Discontinuities, len(mi.SCTE))
+}
+
+func formatSeconds(s float64) string {
+	return time.Duration(s * float64(time.Second)).Round(time.Millisecond).String()
+}
+
+func formatFloat(f float64) string {
+	if f == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%g", f)
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"hls_downloader/downloader"
+)
+
+// keys lists the encryption keys of a media playlist and optionally saves
+// them.
+func keys(args []string) {
+	fs := newFlagSet("keys", "[flags] <url>")
+	var (
+		client       clientFlags
+		u, dir       string
+		variantRules string
+	)
+	client.register(fs)
+	fs.StringVar(&u, "url", "", "Master or media playlist url, may be given as argument")
+	fs.StringVar(&dir, "o", "", "Directory the keys are saved to, as key-<n>.bin")
+	fs.StringVar(&variantRules, "variant", "", "Variant of a master playlist: highest, lowest, index, 1280x720, 720p or name (comma separated)")
+	fs.Parse(args)
+	u = playlistURL(fs, u)
+
+	cfg, err := client.load(fs)
+	if err != nil {
+		log.Panicln(err)
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "variant" {
+			cfg.Variant = splitList(variantRules)
+		}
+	})
+	opts, err := cfg.options()
+	if err != nil {
+		log.Panicln(err)
+	}
+	ctx := context.Background()
+	media, err := fetchMedia(ctx, opts, u)
+	if err != nil {
+		log.Panicln(err)
+	}
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Panicln(err)
+		}
+	}
+
+	d := downloader.New(opts)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "#\tSEGMENTS\tMETHOD\tKEYFORMAT\tIV\tURI")
+	segments := media.segments()
+	n := 0
+	key := media.Key
+	first := 0
+	// a key applies from its segment to the next key
+	flush := func(last int) {
+		if key == nil || key.Method == "" || key.Method == "NONE" || first > last {
+			return
+		}
+		fmt.Fprintf(w, "%d\t%d-%d\t%s\t%s\t%s\t%s\n", n, first, last, key.Method, key.Keyformat, key.IV, key.URI)
+		if dir != "" && remoteKey(key.URI) {
+			data, err := d.Get(ctx, downloader.ResolveURL(media.URL, key.URI))
+			if err != nil {
+				log.Printf("fetch key %d failed: %s\n", n, err)
+			} else if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("key-%d.bin", n)), data, 0600); err != nil {
+				log.Panicln(err)
+			}
+		}
+		n++
+	}
+	for i, s := range segments {
+		if s.Key != nil {
+			flush(i - 1)
+			key, first = s.Key, i
+		}
+	}
+	flush(len(segments) - 1)
+	if n == 0 {
+		fmt.Fprintln(w, "The stream is not encrypted.")
+	}
+}
+
+// remoteKey reports whether the key URI can be fetched, unlike the skd:
+// URIs of FairPlay or the data: URIs of Widevine.
+func remoteKey(uri string) bool {
+	return uri != "" && !strings.HasPrefix(uri, "skd:") && !strings.HasPrefix(uri, "data:")
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/grafov/m3u8"
+
+	"hls_downloader/downloader"
+)
+
+// lintReport holds the findings of a playlist of the linted stream.
+type lintReport struct {
+	URL      string               `json:"url"`
+	Error    string               `json:"error,omitempty"` // the playlist couldn't be fetched
+	Findings []downloader.Finding `json:"findings"`
+
+	data           []byte
+	live           bool
+	targetDuration time.Duration
+}
+
+// lint checks a playlist, and the media playlists of a master playlist,
+// against RFC 8216. It exits with status 1 when an error is found.
+func lint(args []string) {
+	fs := newFlagSet("lint", "[flags] <url>")
+	var (
+		client  clientFlags
+		u       string
+		reloads int
+		jsonOut bool
+	)
+	client.register(fs)
+	fs.StringVar(&u, "url", "", "Master or media playlist url, may be given as argument")
+	fs.IntVar(&reloads, "reloads", 0, "Reload the live media playlists this many times to check their updates")
+	fs.BoolVar(&jsonOut, "json", false, "Print the findings as JSON")
+	fs.Parse(args)
+	u = playlistURL(fs, u)
+
+	cfg, err := client.load(fs)
+	if err != nil {
+		log.Panicln(err)
+	}
+	opts, err := cfg.options()
+	if err != nil {
+		log.Panicln(err)
+	}
+	uri, err := url.Parse(u)
+	if err != nil {
+		log.Panicln(err)
+	}
+	ctx := context.Background()
+	d := downloader.New(opts)
+
+	master := lintPlaylist(ctx, d, uri)
+	if master.Error != "" {
+		log.Panicln(master.Error)
+	}
+	reports := []*lintReport{master}
+	vars := m3u8.Variables{Query: uri.Query()}
+	if p, listType, err := m3u8.DecodeWithVariables(bytes.NewReader(master.data), false, vars); err == nil && listType == m3u8.MASTER {
+		seen := map[string]bool{}
+		for _, ref := range mediaRefs(p.(*m3u8.MasterPlaylist)) {
+			mUrl := downloader.ResolveURL(uri, ref)
+			if seen[mUrl.String()] {
+				continue
+			}
+			seen[mUrl.String()] = true
+			reports = append(reports, lintPlaylist(ctx, d, mUrl))
+		}
+	}
+	lintReloads(ctx, d, reports, reloads)
+
+	errs, warnings := 0, 0
+	for _, r := range reports {
+		if r.Error != "" {
+			errs++
+		}
+		for _, f := range r.Findings {
+			if f.Severity == downloader.SeverityError {
+				errs++
+			} else {
+				warnings++
+			}
+		}
+	}
+	if jsonOut {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(reports)
+	} else {
+		for _, r := range reports {
+			if r.Error != "" {
+				fmt.Printf("%s: error: %s\n", r.URL, r.Error)
+			}
+			for _, f := range r.Findings {
+				if f.Line > 0 {
+					fmt.Printf("%s:%d: %s: %s\n", r.URL, f.Line, f.Severity, f.Message)
+				} else {
+					fmt.Printf("%s: %s: %s\n", r.URL, f.Severity, f.Message)
+				}
+			}
+		}
+		fmt.Printf("%d errors, %d warnings in %d playlists\n", errs, warnings, len(reports))
+	}
+	if errs > 0 {
+		os.Exit(1)
+	}
+}
+
+// mediaRefs returns the URIs of the variants, I-frame variants and
+// renditions of a master playlist.
+func mediaRefs(masterpl *m3u8.MasterPlaylist) []string {
+	var refs []string
+	for _, v := range masterpl.Variants {
+		if v == nil {
+			continue
+		}
+		refs = append(refs, v.URI)
+		for _, alt := range v.Alternatives {
+			if alt != nil && alt.URI != "" {
+				refs = append(refs, alt.URI)
+			}
+		}
+	}
+	return refs
+}
+
+// lintPlaylist fetches and lints the playlist at uri.
+func lintPlaylist(ctx context.Context, d *downloader.Downloader, uri *url.URL) *lintReport {
+	r := &lintReport{URL: uri.String(), Findings: []downloader.Finding{}}
+	data, err := d.Get(ctx, uri)
+	if err != nil {
+		r.Error = err.Error()
+		return r
+	}
+	r.data = data
+	r.Findings = append(r.Findings, downloader.Lint(data)...)
+	if p, listType, err := m3u8.DecodeFrom(bytes.NewReader(data), false); err == nil && listType == m3u8.MEDIA {
+		mediapl := p.(*m3u8.MediaPlaylist)
+		r.live = !mediapl.Closed
+		r.targetDuration = time.Duration(mediapl.TargetDuration * float64(time.Second))
+	}
+	return r
+}
+
+// lintReloads reloads the live media playlists of reports the given number
+// of times, a target duration apart, and checks each update against the
+// previous version.
+func lintReloads(ctx context.Context, d *downloader.Downloader, reports []*lintReport, reloads int) {
+	for i := 1; i <= reloads; i++ {
+		wait := time.Duration(0)
+		for _, r := range reports {
+			if r.live && r.targetDuration > wait {
+				wait = r.targetDuration
+			}
+		}
+		if wait == 0 {
+			return
+		}
+		time.Sleep(wait)
+		for _, r := range reports {
+			if !r.live {
+				continue
+			}
+			uri, _ := url.Parse(r.URL)
+			data, err := d.Get(ctx, uri)
+			if err != nil {
+				r.Findings = append(r.Findings, downloader.Finding{
+					Severity: downloader.SeverityError,
+					Message:  fmt.Sprintf("reload %d failed: %s", i, err),
+				})
+				r.live = false
+				continue
+			}
+			for _, f := range downloader.LintReload(r.data, data) {
+				f.Message = fmt.Sprintf("reload %d: %s", i, f.Message)
+				r.Findings = append(r.Findings, f)
+			}
+			r.data = data
+			r.live = !bytes.Contains(data, []byte("#EXT-X-ENDLIST"))
+		}
+	}
+}
@@ -12,6 +12,7 @@ package m3u8
 */
 
 import (
+	"bufio"
 	"bytes"
 	"errors"
 	"fmt"
@@ -30,6 +31,117 @@ var reKeyValue = regexp.MustCompile(`([a-zA-Z0-9_-]+)=("[^"]+"|[^",]+)`)
 //		* StrictTimeParse - implements only RFC3339 Nanoseconds format
 var TimeParse func(value string) (time.Time, error) = FullTimeParse
 
+// ParseError is a problem of a playlist line. Decoding in strict mode
+// returns the first one, decoding in non-strict mode goes on and keeps them
+// in the Warnings of the playlist. Strict decoding returns the errors of
+// custom decoders as they are.
+type ParseError struct {
+	Line int    // 1-based line number, 0 when the problem is about the whole playlist
+	Tag  string // tag of the line without '#', like EXTINF, empty for URI lines
+	Raw  string // the line as read, without its line break
+	Err  error  // the cause
+}
+
+func (e *ParseError) Error() string {
+	switch {
+	case e.Line == 0:
+		return e.Err.Error()
+	case e.Tag == "":
+		return fmt.Sprintf("line %d: %v", e.Line, e.Err)
+	}
+	return fmt.Sprintf("line %d: %s: %v", e.Line, e.Tag, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// newParseError wraps err, found decoding the line read as lineNo.
+func newParseError(lineNo int, line string, err error) *ParseError {
+	var pe *ParseError
+	if errors.As(err, &pe) {
+		return pe
+	}
+	raw := strings.TrimRight(line, "\r\n")
+	tag := strings.TrimSpace(raw)
+	if strings.HasPrefix(tag, "#") {
+		tag = tag[1:]
+		if i := strings.IndexAny(tag, ": "); i >= 0 {
+			tag = tag[:i]
+		}
+	} else {
+		tag = ""
+	}
+	return &ParseError{Line: lineNo, Tag: tag, Raw: raw, Err: err}
+}
+
+// customError marks the errors of custom decoders, which strict decoding
+// returns as they are.
+type customError struct {
+	error
+}
+
+// strictError returns the error stopping a strict decoding at the line
+// read as lineNo.
+func strictError(lineNo int, line string, err error) error {
+	if ce, ok := err.(customError); ok {
+		return ce.error
+	}
+	return newParseError(lineNo, line, err)
+}
+
+func errM3UAbsent() *ParseError {
+	return &ParseError{Tag: "EXTM3U", Err: errors.New("#EXTM3U absent")}
+}
+
+// check returns err in strict mode. Otherwise it keeps err as a warning of
+// the line being decoded and returns nil, so decoding goes on.
+func (s *decodingState) check(strict bool, err error) error {
+	if err == nil || strict {
+		return err
+	}
+	s.warnings = append(s.warnings, err)
+	return nil
+}
+
+// cueIn reports whether the SCTE35 cue pending for the next segment is an
+// EXT-X-CUE-IN. A segment holds a single cue, so a cue-out read after it
+// takes its place.
+func (s *decodingState) cueIn() bool {
+	return s.tagSCTE35 && s.scte.CueType == SCTE35Cue_End
+}
+
+// lineWarnings returns the warnings kept while decoding the line read as
+// lineNo, and the errors returned for it, once each.
+func (s *decodingState) lineWarnings(lineNo int, line string, errs ...error) []*ParseError {
+	clean := len(s.warnings) == 0
+	for _, err := range errs {
+		clean = clean && err == nil
+	}
+	if clean {
+		// most lines, spare them the allocations below
+		return nil
+	}
+	var warnings []*ParseError
+	seen := make(map[string]bool)
+	for _, err := range append(s.warnings, errs...) {
+		if err == nil || seen[err.Error()] {
+			continue
+		}
+		seen[err.Error()] = true
+		warnings = append(warnings, newParseError(lineNo, line, err))
+	}
+	s.warnings = nil
+	return warnings
+}
+
+// lineReader reads a playlist line by line: a *bytes.Buffer holding the
+// playlist, or a *bufio.Reader reading it from an io.Reader as it is
+// decoded.
+type lineReader interface {
+	ReadString(delim byte) (string, error)
+}
+
 // Decode parses a master playlist passed from the buffer. If `strict`
 // parameter is true then it returns first syntax error.
 func (p *MasterPlaylist) Decode(data bytes.Buffer, strict bool) error {
@@ -40,12 +152,7 @@ func (p *MasterPlaylist) Decode(data bytes.Buffer, strict bool) error {
 // stream.  If `strict` parameter is true then it returns first syntax
 // error.
 func (p *MasterPlaylist) DecodeFrom(reader io.Reader, strict bool) error {
-	buf := new(bytes.Buffer)
-	_, err := buf.ReadFrom(reader)
-	if err != nil {
-		return err
-	}
-	return p.decode(buf, strict)
+	return p.decode(bufio.NewReader(reader), strict)
 }
 
 // WithCustomDecoders adds custom tag decoders to the master playlist for decoding
@@ -60,26 +167,66 @@ func (p *MasterPlaylist) WithCustomDecoders(customDecoders []CustomDecoder) Play
 	return p
 }
 
+// WithVariables sets the values the EXT-X-DEFINE tags of the master
+// playlist import for decoding, QUERYPARAM reads v.Query.
+func (p *MasterPlaylist) WithVariables(v Variables) Playlist {
+	p.variables = v
+	return p
+}
+
+// DefinedVariables returns the values of the EXT-X-DEFINE variables of the
+// decoded master playlist by name, the variables its media playlists
+// import.
+func (p *MasterPlaylist) DefinedVariables() map[string]string {
+	vars := make(map[string]string)
+	for _, def := range p.Defines {
+		vars[def.Name] = def.Value
+	}
+	return vars
+}
+
+// DefinedVariables returns the values of the EXT-X-DEFINE variables of the
+// decoded media playlist by name.
+func (p *MediaPlaylist) DefinedVariables() map[string]string {
+	vars := make(map[string]string)
+	for _, def := range p.Defines {
+		vars[def.Name] = def.Value
+	}
+	return vars
+}
+
 // Parse master playlist. Internal function.
-func (p *MasterPlaylist) decode(buf *bytes.Buffer, strict bool) error {
+func (p *MasterPlaylist) decode(buf lineReader, strict bool) error {
 	var eof bool
 
-	state := new(decodingState)
+	var lineNo int
+
+	state := &decodingState{imports: p.variables}
+	p.Warnings = nil
 
 	for !eof {
 		line, err := buf.ReadString('\n')
 		if err == io.EOF {
 			eof = true
 		} else if err != nil {
-			break
+			return err
+		}
+		lineNo++
+		if line, err = state.expand(line); state.check(strict, err) != nil {
+			return strictError(lineNo, line, err)
 		}
 		err = decodeLineOfMasterPlaylist(p, state, line, strict)
 		if strict && err != nil {
-			return err
+			return strictError(lineNo, line, err)
 		}
+		p.Warnings = append(p.Warnings, state.lineWarnings(lineNo, line, err)...)
 	}
-	if strict && !state.m3u {
-		return errors.New("#EXTM3U absent")
+	p.Defines = state.defines
+	if !state.m3u {
+		if strict {
+			return errM3UAbsent()
+		}
+		p.Warnings = append(p.Warnings, errM3UAbsent())
 	}
 	return nil
 }
@@ -94,12 +241,7 @@ func (p *MediaPlaylist) Decode(data bytes.Buffer, strict bool) error {
 // stream. If `strict` parameter is true then it returns first syntax
 // error.
 func (p *MediaPlaylist) DecodeFrom(reader io.Reader, strict bool) error {
-	buf := new(bytes.Buffer)
-	_, err := buf.ReadFrom(reader)
-	if err != nil {
-		return err
-	}
-	return p.decode(buf, strict)
+	return p.decode(bufio.NewReader(reader), strict)
 }
 
 // WithCustomDecoders adds custom tag decoders to the media playlist for decoding
@@ -114,32 +256,53 @@ func (p *MediaPlaylist) WithCustomDecoders(customDecoders []CustomDecoder) Playl
 	return p
 }
 
-func (p *MediaPlaylist) decode(buf *bytes.Buffer, strict bool) error {
+// WithVariables sets the values the EXT-X-DEFINE tags of the media
+// playlist import for decoding: IMPORT reads v.Imports, usually the
+// DefinedVariables of the master playlist, and QUERYPARAM reads v.Query.
+func (p *MediaPlaylist) WithVariables(v Variables) Playlist {
+	p.variables = v
+	return p
+}
+
+func (p *MediaPlaylist) decode(buf lineReader, strict bool) error {
 	var eof bool
 	var line string
 	var err error
 
-	state := new(decodingState)
+	var lineNo int
+
+	state := &decodingState{imports: p.variables}
 	wv := new(WV)
+	p.Warnings = nil
 
 	for !eof {
 		if line, err = buf.ReadString('\n'); err == io.EOF {
 			eof = true
 		} else if err != nil {
-			break
+			return err
+		}
+		lineNo++
+		if line, err = state.expand(line); state.check(strict, err) != nil {
+			return strictError(lineNo, line, err)
 		}
 
 		err = decodeLineOfMediaPlaylist(p, wv, state, line, strict)
 		if strict && err != nil {
-			return err
+			return strictError(lineNo, line, err)
 		}
-
+		p.Warnings = append(p.Warnings, state.lineWarnings(lineNo, line, err)...)
 	}
 	if state.tagWV {
 		p.WV = wv
 	}
-	if strict && !state.m3u {
-		return errors.New("#EXTM3U absent")
+	p.Defines = state.defines
+	p.DateRanges = state.dateRanges
+	p.Parts = state.parts
+	if !state.m3u {
+		if strict {
+			return errM3UAbsent()
+		}
+		p.Warnings = append(p.Warnings, errM3UAbsent())
 	}
 	return nil
 }
@@ -147,18 +310,20 @@ func (p *MediaPlaylist) decode(buf *bytes.Buffer, strict bool) error {
 // Decode detects type of playlist and decodes it. It accepts bytes
 // buffer as input.
 func Decode(data bytes.Buffer, strict bool) (Playlist, ListType, error) {
-	return decode(&data, strict, nil)
+	return decode(&data, strict, nil, Variables{})
 }
 
 // DecodeFrom detects type of playlist and decodes it. It accepts data
 // conformed with io.Reader.
 func DecodeFrom(reader io.Reader, strict bool) (Playlist, ListType, error) {
-	buf := new(bytes.Buffer)
-	_, err := buf.ReadFrom(reader)
-	if err != nil {
-		return nil, 0, err
-	}
-	return decode(buf, strict, nil)
+	return decode(bufio.NewReader(reader), strict, nil, Variables{})
+}
+
+// DecodeWithVariables detects type of playlist and decodes it, its
+// EXT-X-DEFINE tags import the values of v. It accepts data conformed
+// with io.Reader.
+func DecodeWithVariables(reader io.Reader, strict bool, v Variables) (Playlist, ListType, error) {
+	return decode(bufio.NewReader(reader), strict, nil, v)
 }
 
 // DecodeWith detects the type of playlist and decodes it. It accepts either bytes.Buffer
@@ -166,14 +331,9 @@ func DecodeFrom(reader io.Reader, strict bool) (Playlist, ListType, error) {
 func DecodeWith(input interface{}, strict bool, customDecoders []CustomDecoder) (Playlist, ListType, error) {
 	switch v := input.(type) {
 	case bytes.Buffer:
-		return decode(&v, strict, customDecoders)
+		return decode(&v, strict, customDecoders, Variables{})
 	case io.Reader:
-		buf := new(bytes.Buffer)
-		_, err := buf.ReadFrom(v)
-		if err != nil {
-			return nil, 0, err
-		}
-		return decode(buf, strict, customDecoders)
+		return decode(bufio.NewReader(v), strict, customDecoders, Variables{})
 	default:
 		return nil, 0, errors.New("input must be bytes.Buffer or io.Reader type")
 	}
@@ -181,15 +341,17 @@ func DecodeWith(input interface{}, strict bool, customDecoders []CustomDecoder)
 
 // Detect playlist type and decode it. May be used as decoder for both
 // master and media playlists.
-func decode(buf *bytes.Buffer, strict bool, customDecoders []CustomDecoder) (Playlist, ListType, error) {
+func decode(buf lineReader, strict bool, customDecoders []CustomDecoder, variables Variables) (Playlist, ListType, error) {
 	var eof bool
 	var line string
 	var master *MasterPlaylist
 	var media *MediaPlaylist
 	var listType ListType
 	var err error
+	var lineNo int
+	var warnings []*ParseError
 
-	state := new(decodingState)
+	state := &decodingState{imports: variables}
 	wv := new(WV)
 
 	master = NewMasterPlaylist()
@@ -209,8 +371,9 @@ func decode(buf *bytes.Buffer, strict bool, customDecoders []CustomDecoder) (Pla
 		if line, err = buf.ReadString('\n'); err == io.EOF {
 			eof = true
 		} else if err != nil {
-			break
+			return nil, 0, err
 		}
+		lineNo++
 
 		// fixes the issues https://github.com/grafov/m3u8/issues/25
 		// TODO: the same should be done in decode functions of both Master- and MediaPlaylists
@@ -218,30 +381,42 @@ func decode(buf *bytes.Buffer, strict bool, customDecoders []CustomDecoder) (Pla
 		if len(line) < 1 || line == "\r" {
 			continue
 		}
-
-		err = decodeLineOfMasterPlaylist(master, state, line, strict)
-		if strict && err != nil {
-			return master, state.listType, err
+		if line, err = state.expand(line); state.check(strict, err) != nil {
+			return nil, state.listType, strictError(lineNo, line, err)
 		}
 
-		err = decodeLineOfMediaPlaylist(media, wv, state, line, strict)
-		if strict && err != nil {
-			return media, state.listType, err
+		masterErr := decodeLineOfMasterPlaylist(master, state, line, strict)
+		if strict && masterErr != nil {
+			return master, state.listType, strictError(lineNo, line, masterErr)
 		}
 
+		mediaErr := decodeLineOfMediaPlaylist(media, wv, state, line, strict)
+		if strict && mediaErr != nil {
+			return media, state.listType, strictError(lineNo, line, mediaErr)
+		}
+		warnings = append(warnings, state.lineWarnings(lineNo, line, masterErr, mediaErr)...)
 	}
 	if state.listType == MEDIA && state.tagWV {
 		media.WV = wv
 	}
+	media.DateRanges = state.dateRanges
+	media.Parts = state.parts
+	master.Defines = state.defines
+	media.Defines = state.defines
 
-	if strict && !state.m3u {
-		return nil, listType, errors.New("#EXTM3U absent")
+	if !state.m3u {
+		if strict {
+			return nil, listType, errM3UAbsent()
+		}
+		warnings = append(warnings, errM3UAbsent())
 	}
 
 	switch state.listType {
 	case MASTER:
+		master.Warnings = warnings
 		return master, MASTER, nil
 	case MEDIA:
+		media.Warnings = warnings
 		if media.Closed || media.MediaType == EVENT {
 			// VoD and Event's should show the entire playlist
 			media.SetWinSize(0)
@@ -251,6 +426,100 @@ func decode(buf *bytes.Buffer, strict bool, customDecoders []CustomDecoder) (Pla
 	return nil, state.listType, errors.New("Can't detect playlist type")
 }
 
+// reVariable matches the {$name} variable references.
+var (
+	reVariable     = regexp.MustCompile(`\{\$([a-zA-Z0-9_-]+)\}`)
+	reVariableName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+)
+
+// expand handles the variables of a line: its {$name} references are
+// replaced with the values of the variables defined before, then an
+// EXT-X-DEFINE tag defines one. References to undefined variables are
+// left as they are.
+func (s *decodingState) expand(line string) (string, error) {
+	var err error
+	line = substitute(line, s.vars, func(name string) {
+		if err == nil {
+			err = fmt.Errorf("undefined variable %q", name)
+		}
+	})
+	if strings.HasPrefix(line, "#EXT-X-DEFINE:") {
+		if defErr := s.define(line[14:]); err == nil {
+			err = defErr
+		}
+	}
+	return line, err
+}
+
+// SubstituteVariables replaces the {$name} references of line with the
+// values of vars, like the decoder does with the EXT-X-DEFINE variables.
+// References to other variables are left as they are.
+func SubstituteVariables(line string, vars map[string]string) string {
+	return substitute(line, vars, func(string) {})
+}
+
+// substitute replaces the variable references of line, calling undefined
+// with the names missing from vars.
+func substitute(line string, vars map[string]string, undefined func(name string)) string {
+	if !strings.Contains(line, "{$") {
+		return line
+	}
+	return reVariable.ReplaceAllStringFunc(line, func(ref string) string {
+		name := ref[2 : len(ref)-1]
+		if v, ok := vars[name]; ok {
+			return v
+		}
+		undefined(name)
+		return ref
+	})
+}
+
+// define parses the attribute list of an EXT-X-DEFINE tag and sets the
+// value of its variable.
+func (s *decodingState) define(attributes string) error {
+	def := new(Define)
+	params := decodeParamsLine(attributes)
+	switch {
+	case params["NAME"] != "":
+		def.Name, def.Type, def.Value = params["NAME"], VALUE, params["VALUE"]
+		if _, ok := params["VALUE"]; !ok {
+			return errors.New("VALUE is missing")
+		}
+	case params["IMPORT"] != "":
+		def.Name, def.Type = params["IMPORT"], IMPORT
+	case params["QUERYPARAM"] != "":
+		def.Name, def.Type = params["QUERYPARAM"], QUERYPARAM
+	default:
+		return errors.New("NAME, IMPORT or QUERYPARAM is missing")
+	}
+	if !reVariableName.MatchString(def.Name) {
+		return fmt.Errorf("invalid variable name %q", def.Name)
+	}
+	if _, ok := s.vars[def.Name]; ok {
+		return fmt.Errorf("variable %q is defined twice", def.Name)
+	}
+	s.defines = append(s.defines, def)
+
+	var ok bool
+	switch def.Type {
+	case IMPORT:
+		def.Value, ok = s.imports.Imports[def.Name]
+		if !ok {
+			return fmt.Errorf("imported variable %q is not defined", def.Name)
+		}
+	case QUERYPARAM:
+		if ok = s.imports.Query.Has(def.Name); !ok {
+			return fmt.Errorf("query parameter %q is missing", def.Name)
+		}
+		def.Value = s.imports.Query.Get(def.Name)
+	}
+	if s.vars == nil {
+		s.vars = make(map[string]string)
+	}
+	s.vars[def.Name] = def.Value
+	return nil
+}
+
 // DecodeAttributeList turns an attribute list into a key, value map. You should trim
 // any characters not part of the attribute list, such as the tag and ':'.
 func DecodeAttributeList(line string) map[string]string {
@@ -278,8 +547,8 @@ func decodeLineOfMasterPlaylist(p *MasterPlaylist, state *decodingState, line st
 			if strings.HasPrefix(line, v.TagName()) {
 				t, err := v.Decode(line)
 
-				if strict && err != nil {
-					return err
+				if err = state.check(strict, err); err != nil {
+					return customError{err}
 				}
 
 				p.Custom[t.TagName()] = t
@@ -293,11 +562,25 @@ func decodeLineOfMasterPlaylist(p *MasterPlaylist, state *decodingState, line st
 	case strings.HasPrefix(line, "#EXT-X-VERSION:"): // version tag
 		state.listType = MASTER
 		_, err = fmt.Sscanf(line, "#EXT-X-VERSION:%d", &p.ver)
-		if strict && err != nil {
+		if err = state.check(strict, err); err != nil {
 			return err
 		}
 	case line == "#EXT-X-INDEPENDENT-SEGMENTS":
 		p.SetIndependentSegments(true)
+	case strings.HasPrefix(line, "#EXT-X-SESSION-DATA:"):
+		state.listType = MASTER
+		data, err := decodeSessionData(line[20:])
+		p.SessionData = append(p.SessionData, data)
+		if err = state.check(strict, err); err != nil {
+			return err
+		}
+	case strings.HasPrefix(line, "#EXT-X-SESSION-KEY:"):
+		state.listType = MASTER
+		key, err := decodeSessionKey(line[19:])
+		p.SessionKeys = append(p.SessionKeys, key)
+		if err = state.check(strict, err); err != nil {
+			return err
+		}
 	case strings.HasPrefix(line, "#EXT-X-MEDIA:"):
 		var alt Alternative
 		state.listType = MASTER
@@ -316,8 +599,8 @@ func decodeLineOfMasterPlaylist(p *MasterPlaylist, state *decodingState, line st
 					alt.Default = true
 				} else if strings.ToUpper(v) == "NO" {
 					alt.Default = false
-				} else if strict {
-					return errors.New("value must be YES or NO")
+				} else if err = state.check(strict, errors.New("value must be YES or NO")); err != nil {
+					return err
 				}
 			case "AUTOSELECT":
 				alt.Autoselect = v
@@ -346,14 +629,14 @@ func decodeLineOfMasterPlaylist(p *MasterPlaylist, state *decodingState, line st
 			case "PROGRAM-ID":
 				var val int
 				val, err = strconv.Atoi(v)
-				if strict && err != nil {
+				if err = state.check(strict, err); err != nil {
 					return err
 				}
 				state.variant.ProgramId = uint32(val)
 			case "BANDWIDTH":
 				var val int
 				val, err = strconv.Atoi(v)
-				if strict && err != nil {
+				if err = state.check(strict, err); err != nil {
 					return err
 				}
 				state.variant.Bandwidth = uint32(val)
@@ -374,13 +657,15 @@ func decodeLineOfMasterPlaylist(p *MasterPlaylist, state *decodingState, line st
 			case "AVERAGE-BANDWIDTH":
 				var val int
 				val, err = strconv.Atoi(v)
-				if strict && err != nil {
+				if err = state.check(strict, err); err != nil {
 					return err
 				}
 				state.variant.AverageBandwidth = uint32(val)
 			case "FRAME-RATE":
-				if state.variant.FrameRate, err = strconv.ParseFloat(v, 64); strict && err != nil {
-					return err
+				if state.variant.FrameRate, err = strconv.ParseFloat(v, 64); err != nil {
+					if err = state.check(strict, err); err != nil {
+						return err
+					}
 				}
 			case "VIDEO-RANGE":
 				state.variant.VideoRange = v
@@ -407,14 +692,14 @@ func decodeLineOfMasterPlaylist(p *MasterPlaylist, state *decodingState, line st
 			case "PROGRAM-ID":
 				var val int
 				val, err = strconv.Atoi(v)
-				if strict && err != nil {
+				if err = state.check(strict, err); err != nil {
 					return err
 				}
 				state.variant.ProgramId = uint32(val)
 			case "BANDWIDTH":
 				var val int
 				val, err = strconv.Atoi(v)
-				if strict && err != nil {
+				if err = state.check(strict, err); err != nil {
 					return err
 				}
 				state.variant.Bandwidth = uint32(val)
@@ -429,7 +714,7 @@ func decodeLineOfMasterPlaylist(p *MasterPlaylist, state *decodingState, line st
 			case "AVERAGE-BANDWIDTH":
 				var val int
 				val, err = strconv.Atoi(v)
-				if strict && err != nil {
+				if err = state.check(strict, err); err != nil {
 					return err
 				}
 				state.variant.AverageBandwidth = uint32(val)
@@ -457,8 +742,8 @@ func decodeLineOfMediaPlaylist(p *MediaPlaylist, wv *WV, state *decodingState, l
 			if strings.HasPrefix(line, v.TagName()) {
 				t, err := v.Decode(line)
 
-				if strict && err != nil {
-					return err
+				if err = state.check(strict, err); err != nil {
+					return customError{err}
 				}
 
 				if v.SegmentTag() {
@@ -477,15 +762,17 @@ func decodeLineOfMediaPlaylist(p *MediaPlaylist, wv *WV, state *decodingState, l
 		state.listType = MEDIA
 		sepIndex := strings.Index(line, ",")
 		if sepIndex == -1 {
-			if strict {
-				return fmt.Errorf("could not parse: %q", line)
+			if err = state.check(strict, fmt.Errorf("could not parse: %q", line)); err != nil {
+				return err
 			}
 			sepIndex = len(line)
 		}
 		duration := line[8:sepIndex]
 		if len(duration) > 0 {
-			if state.duration, err = strconv.ParseFloat(duration, 64); strict && err != nil {
-				return fmt.Errorf("Duration parsing error: %s", err)
+			if state.duration, err = strconv.ParseFloat(duration, 64); err != nil {
+				if err = state.check(strict, fmt.Errorf("Duration parsing error: %s", err)); err != nil {
+					return err
+				}
 			}
 		}
 		if len(line) > sepIndex {
@@ -511,29 +798,38 @@ func decodeLineOfMediaPlaylist(p *MediaPlaylist, wv *WV, state *decodingState, l
 			state.tagInf = false
 		}
 		if state.tagRange {
-			if err = p.SetRange(state.limit, state.offset); strict && err != nil {
+			if err = state.check(strict, p.SetRange(state.limit, state.offset)); err != nil {
 				return err
 			}
 			state.tagRange = false
 		}
 		if state.tagSCTE35 {
 			state.tagSCTE35 = false
-			if err = p.SetSCTE35(state.scte); strict && err != nil {
+			if err = state.check(strict, p.SetSCTE35(state.scte)); err != nil {
 				return err
 			}
 		}
 		if state.tagDiscontinuity {
 			state.tagDiscontinuity = false
-			if err = p.SetDiscontinuity(); strict && err != nil {
+			if err = state.check(strict, p.SetDiscontinuity()); err != nil {
 				return err
 			}
 		}
 		if state.tagProgramDateTime && p.Count() > 0 {
 			state.tagProgramDateTime = false
-			if err = p.SetProgramDateTime(state.programDateTime); strict && err != nil {
+			if err = state.check(strict, p.SetProgramDateTime(state.programDateTime)); err != nil {
+				return err
+			}
+		}
+		if state.tagGap {
+			state.tagGap = false
+			if err = state.check(strict, p.SetGap()); err != nil {
 				return err
 			}
 		}
+		if state.bitrate > 0 && p.Count() > 0 {
+			p.Segments[p.last()].Bitrate = state.bitrate
+		}
 		// If EXT-X-KEY appeared before reference to segment (EXTINF) then it linked to this segment
 		if state.tagKey {
 			p.Segments[p.last()].Key = &Key{state.xkey.Method, state.xkey.URI, state.xkey.IV, state.xkey.Keyformat, state.xkey.Keyformatversions}
@@ -544,6 +840,20 @@ func decodeLineOfMediaPlaylist(p *MediaPlaylist, wv *WV, state *decodingState, l
 			}
 			state.tagKey = false
 		}
+		// EXT-X-DATERANGE tags appeared before reference to segment belong to this segment
+		if len(state.dateRanges) > 0 && p.Count() > 0 {
+			p.Segments[p.last()].DateRanges = state.dateRanges
+			state.dateRanges = nil
+		}
+		// so do the EXT-X-PART tags
+		if len(state.parts) > 0 && p.Count() > 0 {
+			p.Segments[p.last()].Parts = state.parts
+			state.parts = nil
+		}
+		// the segments of a delta update follow the skipped ones
+		if p.Skip != nil && p.Count() == 1 {
+			p.Segments[p.last()].SeqId += p.Skip.SkippedSegments
+		}
 		// If EXT-X-MAP appeared before reference to segment (EXTINF) then it linked to this segment
 		if state.tagMap {
 			p.Segments[p.last()].Map = &Map{state.xmap.URI, state.xmap.Limit, state.xmap.Offset}
@@ -569,27 +879,31 @@ func decodeLineOfMediaPlaylist(p *MediaPlaylist, wv *WV, state *decodingState, l
 		p.Closed = true
 	case strings.HasPrefix(line, "#EXT-X-VERSION:"):
 		state.listType = MEDIA
-		if _, err = fmt.Sscanf(line, "#EXT-X-VERSION:%d", &p.ver); strict && err != nil {
-			return err
+		if _, err = fmt.Sscanf(line, "#EXT-X-VERSION:%d", &p.ver); err != nil {
+			if err = state.check(strict, err); err != nil {
+				return err
+			}
 		}
 	case strings.HasPrefix(line, "#EXT-X-TARGETDURATION:"):
 		state.listType = MEDIA
-		if _, err = fmt.Sscanf(line, "#EXT-X-TARGETDURATION:%f", &p.TargetDuration); strict && err != nil {
-			return err
+		if _, err = fmt.Sscanf(line, "#EXT-X-TARGETDURATION:%f", &p.TargetDuration); err != nil {
+			if err = state.check(strict, err); err != nil {
+				return err
+			}
 		}
 	case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
 		state.listType = MEDIA
-		if _, err = fmt.Sscanf(line, "#EXT-X-MEDIA-SEQUENCE:%d", &p.SeqNo); strict && err != nil {
-			return err
+		if _, err = fmt.Sscanf(line, "#EXT-X-MEDIA-SEQUENCE:%d", &p.SeqNo); err != nil {
+			if err = state.check(strict, err); err != nil {
+				return err
+			}
 		}
 	case strings.HasPrefix(line, "#EXT-X-PLAYLIST-TYPE:"):
 		state.listType = MEDIA
 		var playlistType string
 		_, err = fmt.Sscanf(line, "#EXT-X-PLAYLIST-TYPE:%s", &playlistType)
 		if err != nil {
-			if strict {
-				return err
-			}
+			return state.check(strict, err)
 		} else {
 			switch playlistType {
 			case "EVENT":
@@ -600,8 +914,10 @@ func decodeLineOfMediaPlaylist(p *MediaPlaylist, wv *WV, state *decodingState, l
 		}
 	case strings.HasPrefix(line, "#EXT-X-DISCONTINUITY-SEQUENCE:"):
 		state.listType = MEDIA
-		if _, err = fmt.Sscanf(line, "#EXT-X-DISCONTINUITY-SEQUENCE:%d", &p.DiscontinuitySeq); strict && err != nil {
-			return err
+		if _, err = fmt.Sscanf(line, "#EXT-X-DISCONTINUITY-SEQUENCE:%d", &p.DiscontinuitySeq); err != nil {
+			if err = state.check(strict, err); err != nil {
+				return err
+			}
 		}
 	case strings.HasPrefix(line, "#EXT-X-START:"):
 		state.listType = MEDIA
@@ -610,7 +926,7 @@ func decodeLineOfMediaPlaylist(p *MediaPlaylist, wv *WV, state *decodingState, l
 			case "TIME-OFFSET":
 				st, err := strconv.ParseFloat(v, 64)
 				if err != nil {
-					return fmt.Errorf("Invalid TIME-OFFSET: %s: %v", v, err)
+					return state.check(strict, fmt.Errorf("Invalid TIME-OFFSET: %s: %v", v, err))
 				}
 				p.StartTime = st
 			case "PRECISE":
@@ -643,8 +959,10 @@ func decodeLineOfMediaPlaylist(p *MediaPlaylist, wv *WV, state *decodingState, l
 			case "URI":
 				state.xmap.URI = v
 			case "BYTERANGE":
-				if _, err = fmt.Sscanf(v, "%d@%d", &state.xmap.Limit, &state.xmap.Offset); strict && err != nil {
-					return fmt.Errorf("Byterange sub-range length value parsing error: %s", err)
+				if _, err = fmt.Sscanf(v, "%d@%d", &state.xmap.Limit, &state.xmap.Offset); err != nil {
+					if err = state.check(strict, fmt.Errorf("Byterange sub-range length value parsing error: %s", err)); err != nil {
+						return err
+					}
 				}
 			}
 		}
@@ -652,20 +970,26 @@ func decodeLineOfMediaPlaylist(p *MediaPlaylist, wv *WV, state *decodingState, l
 	case !state.tagProgramDateTime && strings.HasPrefix(line, "#EXT-X-PROGRAM-DATE-TIME:"):
 		state.tagProgramDateTime = true
 		state.listType = MEDIA
-		if state.programDateTime, err = TimeParse(line[25:]); strict && err != nil {
-			return err
+		if state.programDateTime, err = TimeParse(line[25:]); err != nil {
+			if err = state.check(strict, err); err != nil {
+				return err
+			}
 		}
 	case !state.tagRange && strings.HasPrefix(line, "#EXT-X-BYTERANGE:"):
 		state.tagRange = true
 		state.listType = MEDIA
 		state.offset = 0
 		params := strings.SplitN(line[17:], "@", 2)
-		if state.limit, err = strconv.ParseInt(params[0], 10, 64); strict && err != nil {
-			return fmt.Errorf("Byterange sub-range length value parsing error: %s", err)
+		if state.limit, err = strconv.ParseInt(params[0], 10, 64); err != nil {
+			if err = state.check(strict, fmt.Errorf("Byterange sub-range length value parsing error: %s", err)); err != nil {
+				return err
+			}
 		}
 		if len(params) > 1 {
-			if state.offset, err = strconv.ParseInt(params[1], 10, 64); strict && err != nil {
-				return fmt.Errorf("Byterange sub-range offset value parsing error: %s", err)
+			if state.offset, err = strconv.ParseInt(params[1], 10, 64); err != nil {
+				if err = state.check(strict, fmt.Errorf("Byterange sub-range offset value parsing error: %s", err)); err != nil {
+					return err
+				}
 			}
 		}
 	case !state.tagSCTE35 && strings.HasPrefix(line, "#EXT-SCTE35:"):
@@ -683,13 +1007,13 @@ func decodeLineOfMediaPlaylist(p *MediaPlaylist, wv *WV, state *decodingState, l
 				state.scte.Time, _ = strconv.ParseFloat(value, 64)
 			}
 		}
-	case !state.tagSCTE35 && strings.HasPrefix(line, "#EXT-OATCLS-SCTE35:"):
+	case (!state.tagSCTE35 || state.cueIn()) && strings.HasPrefix(line, "#EXT-OATCLS-SCTE35:"):
 		// EXT-OATCLS-SCTE35 contains the SCTE35 tag, EXT-X-CUE-OUT contains duration
 		state.tagSCTE35 = true
 		state.scte = new(SCTE)
 		state.scte.Syntax = SCTE35_OATCLS
 		state.scte.Cue = line[19:]
-	case state.tagSCTE35 && state.scte.Syntax == SCTE35_OATCLS && strings.HasPrefix(line, "#EXT-X-CUE-OUT:"):
+	case state.tagSCTE35 && state.scte.Syntax == SCTE35_OATCLS && state.scte.CueType == SCTE35Cue_Start && strings.HasPrefix(line, "#EXT-X-CUE-OUT:"):
 		// EXT-OATCLS-SCTE35 contains the SCTE35 tag, EXT-X-CUE-OUT contains duration
 		state.scte.Time, _ = strconv.ParseFloat(line[15:], 64)
 		state.scte.CueType = SCTE35Cue_Start
@@ -708,11 +1032,85 @@ func decodeLineOfMediaPlaylist(p *MediaPlaylist, wv *WV, state *decodingState, l
 				state.scte.Elapsed, _ = strconv.ParseFloat(value, 64)
 			}
 		}
+	case (!state.tagSCTE35 || state.cueIn()) && (line == "#EXT-X-CUE-OUT" || strings.HasPrefix(line, "#EXT-X-CUE-OUT:")):
+		// EXT-X-CUE-OUT without EXT-OATCLS-SCTE35, its duration is given
+		// alone or as DURATION attribute. It replaces a cue-in of the same
+		// segment: the new break starts where the previous one ends.
+		state.tagSCTE35 = true
+		state.listType = MEDIA
+		state.scte = new(SCTE)
+		state.scte.Syntax = SCTE35_OATCLS
+		state.scte.CueType = SCTE35Cue_Start
+		if value := strings.TrimPrefix(line[14:], ":"); value != "" {
+			if d, err := strconv.ParseFloat(value, 64); err == nil {
+				state.scte.Time = d
+			} else {
+				state.scte.Time, _ = strconv.ParseFloat(decodeParamsLine(value)["DURATION"], 64)
+			}
+		}
 	case !state.tagSCTE35 && line == "#EXT-X-CUE-IN":
 		state.tagSCTE35 = true
 		state.scte = new(SCTE)
 		state.scte.Syntax = SCTE35_OATCLS
 		state.scte.CueType = SCTE35Cue_End
+	case strings.HasPrefix(line, "#EXT-X-DATERANGE:"):
+		state.listType = MEDIA
+		dr, err := decodeDateRange(line[17:])
+		state.dateRanges = append(state.dateRanges, dr)
+		if err = state.check(strict, err); err != nil {
+			return err
+		}
+	case strings.HasPrefix(line, "#EXT-X-PART-INF:"):
+		state.listType = MEDIA
+		v := decodeParamsLine(line[16:])["PART-TARGET"]
+		if p.PartTarget, err = strconv.ParseFloat(v, 64); err != nil {
+			return state.check(strict, fmt.Errorf("invalid PART-TARGET: %q", v))
+		}
+	case strings.HasPrefix(line, "#EXT-X-SERVER-CONTROL:"):
+		state.listType = MEDIA
+		p.ServerControl, err = decodeServerControl(line[22:])
+		if err = state.check(strict, err); err != nil {
+			return err
+		}
+	case strings.HasPrefix(line, "#EXT-X-PART:"):
+		state.listType = MEDIA
+		part, err := decodePart(line[12:], state.lastPart)
+		state.parts = append(state.parts, part)
+		state.lastPart = part
+		if err = state.check(strict, err); err != nil {
+			return err
+		}
+	case strings.HasPrefix(line, "#EXT-X-PRELOAD-HINT:"):
+		state.listType = MEDIA
+		hint, err := decodePreloadHint(line[20:])
+		p.PreloadHints = append(p.PreloadHints, hint)
+		if err = state.check(strict, err); err != nil {
+			return err
+		}
+	case strings.HasPrefix(line, "#EXT-X-SKIP:"):
+		state.listType = MEDIA
+		p.Skip, err = decodeSkip(line[12:])
+		if err = state.check(strict, err); err != nil {
+			return err
+		}
+	case strings.HasPrefix(line, "#EXT-X-RENDITION-REPORT:"):
+		state.listType = MEDIA
+		report, err := decodeRenditionReport(line[24:])
+		p.RenditionReports = append(p.RenditionReports, report)
+		if err = state.check(strict, err); err != nil {
+			return err
+		}
+	case line == "#EXT-X-GAP":
+		state.listType = MEDIA
+		state.tagGap = true
+	case strings.HasPrefix(line, "#EXT-X-BITRATE:"):
+		state.listType = MEDIA
+		if state.bitrate, err = strconv.ParseInt(line[15:], 10, 64); err != nil || state.bitrate <= 0 {
+			state.bitrate = 0
+			if err = state.check(strict, fmt.Errorf("invalid bitrate %q", line[15:])); err != nil {
+				return err
+			}
+		}
 	case !state.tagDiscontinuity && strings.HasPrefix(line, "#EXT-X-DISCONTINUITY"):
 		state.tagDiscontinuity = true
 		state.listType = MEDIA
@@ -721,106 +1119,375 @@ func decodeLineOfMediaPlaylist(p *MediaPlaylist, wv *WV, state *decodingState, l
 		p.Iframe = true
 	case strings.HasPrefix(line, "#WV-AUDIO-CHANNELS"):
 		state.listType = MEDIA
-		if _, err = fmt.Sscanf(line, "#WV-AUDIO-CHANNELS %d", &wv.AudioChannels); strict && err != nil {
-			return err
-		}
-		if err == nil {
-			state.tagWV = true
+		if _, err = fmt.Sscanf(line, "#WV-AUDIO-CHANNELS %d", &wv.AudioChannels); err != nil {
+			return state.check(strict, err)
 		}
+		state.tagWV = true
 	case strings.HasPrefix(line, "#WV-AUDIO-FORMAT"):
 		state.listType = MEDIA
-		if _, err = fmt.Sscanf(line, "#WV-AUDIO-FORMAT %d", &wv.AudioFormat); strict && err != nil {
-			return err
-		}
-		if err == nil {
-			state.tagWV = true
+		if _, err = fmt.Sscanf(line, "#WV-AUDIO-FORMAT %d", &wv.AudioFormat); err != nil {
+			return state.check(strict, err)
 		}
+		state.tagWV = true
 	case strings.HasPrefix(line, "#WV-AUDIO-PROFILE-IDC"):
 		state.listType = MEDIA
-		if _, err = fmt.Sscanf(line, "#WV-AUDIO-PROFILE-IDC %d", &wv.AudioProfileIDC); strict && err != nil {
-			return err
-		}
-		if err == nil {
-			state.tagWV = true
+		if _, err = fmt.Sscanf(line, "#WV-AUDIO-PROFILE-IDC %d", &wv.AudioProfileIDC); err != nil {
+			return state.check(strict, err)
 		}
+		state.tagWV = true
 	case strings.HasPrefix(line, "#WV-AUDIO-SAMPLE-SIZE"):
 		state.listType = MEDIA
-		if _, err = fmt.Sscanf(line, "#WV-AUDIO-SAMPLE-SIZE %d", &wv.AudioSampleSize); strict && err != nil {
-			return err
-		}
-		if err == nil {
-			state.tagWV = true
+		if _, err = fmt.Sscanf(line, "#WV-AUDIO-SAMPLE-SIZE %d", &wv.AudioSampleSize); err != nil {
+			return state.check(strict, err)
 		}
+		state.tagWV = true
 	case strings.HasPrefix(line, "#WV-AUDIO-SAMPLING-FREQUENCY"):
 		state.listType = MEDIA
-		if _, err = fmt.Sscanf(line, "#WV-AUDIO-SAMPLING-FREQUENCY %d", &wv.AudioSamplingFrequency); strict && err != nil {
-			return err
-		}
-		if err == nil {
-			state.tagWV = true
+		if _, err = fmt.Sscanf(line, "#WV-AUDIO-SAMPLING-FREQUENCY %d", &wv.AudioSamplingFrequency); err != nil {
+			return state.check(strict, err)
 		}
+		state.tagWV = true
 	case strings.HasPrefix(line, "#WV-CYPHER-VERSION"):
 		state.listType = MEDIA
 		wv.CypherVersion = line[19:]
 		state.tagWV = true
 	case strings.HasPrefix(line, "#WV-ECM"):
 		state.listType = MEDIA
-		if _, err = fmt.Sscanf(line, "#WV-ECM %s", &wv.ECM); strict && err != nil {
-			return err
-		}
-		if err == nil {
-			state.tagWV = true
+		if _, err = fmt.Sscanf(line, "#WV-ECM %s", &wv.ECM); err != nil {
+			return state.check(strict, err)
 		}
+		state.tagWV = true
 	case strings.HasPrefix(line, "#WV-VIDEO-FORMAT"):
 		state.listType = MEDIA
-		if _, err = fmt.Sscanf(line, "#WV-VIDEO-FORMAT %d", &wv.VideoFormat); strict && err != nil {
-			return err
-		}
-		if err == nil {
-			state.tagWV = true
+		if _, err = fmt.Sscanf(line, "#WV-VIDEO-FORMAT %d", &wv.VideoFormat); err != nil {
+			return state.check(strict, err)
 		}
+		state.tagWV = true
 	case strings.HasPrefix(line, "#WV-VIDEO-FRAME-RATE"):
 		state.listType = MEDIA
-		if _, err = fmt.Sscanf(line, "#WV-VIDEO-FRAME-RATE %d", &wv.VideoFrameRate); strict && err != nil {
-			return err
-		}
-		if err == nil {
-			state.tagWV = true
+		if _, err = fmt.Sscanf(line, "#WV-VIDEO-FRAME-RATE %d", &wv.VideoFrameRate); err != nil {
+			return state.check(strict, err)
 		}
+		state.tagWV = true
 	case strings.HasPrefix(line, "#WV-VIDEO-LEVEL-IDC"):
 		state.listType = MEDIA
-		if _, err = fmt.Sscanf(line, "#WV-VIDEO-LEVEL-IDC %d", &wv.VideoLevelIDC); strict && err != nil {
-			return err
-		}
-		if err == nil {
-			state.tagWV = true
+		if _, err = fmt.Sscanf(line, "#WV-VIDEO-LEVEL-IDC %d", &wv.VideoLevelIDC); err != nil {
+			return state.check(strict, err)
 		}
+		state.tagWV = true
 	case strings.HasPrefix(line, "#WV-VIDEO-PROFILE-IDC"):
 		state.listType = MEDIA
-		if _, err = fmt.Sscanf(line, "#WV-VIDEO-PROFILE-IDC %d", &wv.VideoProfileIDC); strict && err != nil {
-			return err
-		}
-		if err == nil {
-			state.tagWV = true
+		if _, err = fmt.Sscanf(line, "#WV-VIDEO-PROFILE-IDC %d", &wv.VideoProfileIDC); err != nil {
+			return state.check(strict, err)
 		}
+		state.tagWV = true
 	case strings.HasPrefix(line, "#WV-VIDEO-RESOLUTION"):
 		state.listType = MEDIA
 		wv.VideoResolution = line[21:]
 		state.tagWV = true
 	case strings.HasPrefix(line, "#WV-VIDEO-SAR"):
 		state.listType = MEDIA
-		if _, err = fmt.Sscanf(line, "#WV-VIDEO-SAR %s", &wv.VideoSAR); strict && err != nil {
-			return err
-		}
-		if err == nil {
-			state.tagWV = true
+		if _, err = fmt.Sscanf(line, "#WV-VIDEO-SAR %s", &wv.VideoSAR); err != nil {
+			return state.check(strict, err)
 		}
+		state.tagWV = true
 	case strings.HasPrefix(line, "#"):
 		// comments are ignored
 	}
 	return err
 }
 
+// decodeDateRange parses the attribute list of an EXT-X-DATERANGE tag. The
+// range is returned with the attributes that could be parsed, along with
+// the first problem found.
+func decodeDateRange(attributes string) (*DateRange, error) {
+	var err error
+	fail := func(format string, args ...interface{}) {
+		if err == nil {
+			err = fmt.Errorf(format, args...)
+		}
+	}
+	dr := new(DateRange)
+	hasStart := false
+	for _, kv := range reKeyValue.FindAllStringSubmatch(attributes, -1) {
+		k, raw := kv[1], kv[2]
+		v := strings.Trim(raw, `"`)
+		switch k {
+		case "ID":
+			dr.ID = v
+		case "CLASS":
+			dr.Class = v
+		case "START-DATE":
+			hasStart = true
+			t, e := TimeParse(v)
+			if e != nil {
+				fail("invalid START-DATE: %v", e)
+			}
+			dr.StartDate = t
+		case "END-DATE":
+			t, e := TimeParse(v)
+			if e != nil {
+				fail("invalid END-DATE: %v", e)
+			}
+			dr.EndDate = t
+		case "DURATION":
+			d, e := strconv.ParseFloat(v, 64)
+			if e != nil || d < 0 {
+				fail("invalid DURATION: %s", v)
+			}
+			dr.Duration = d
+		case "PLANNED-DURATION":
+			d, e := strconv.ParseFloat(v, 64)
+			if e != nil || d < 0 {
+				fail("invalid PLANNED-DURATION: %s", v)
+			}
+			dr.PlannedDuration = d
+		case "SCTE35-CMD":
+			dr.SCTE35Cmd = v
+		case "SCTE35-OUT":
+			dr.SCTE35Out = v
+		case "SCTE35-IN":
+			dr.SCTE35In = v
+		case "END-ON-NEXT":
+			if v != "YES" {
+				fail("END-ON-NEXT must be YES")
+			}
+			dr.EndOnNext = true
+		default:
+			if strings.HasPrefix(k, "X-") {
+				if dr.X == nil {
+					dr.X = make(map[string]string)
+				}
+				dr.X[k] = raw
+			}
+		}
+	}
+	switch {
+	case dr.ID == "":
+		fail("ID is missing")
+	case !hasStart:
+		fail("START-DATE is missing")
+	case dr.EndOnNext && dr.Class == "":
+		fail("END-ON-NEXT requires a CLASS")
+	case dr.EndOnNext && (dr.Duration > 0 || !dr.EndDate.IsZero()):
+		fail("END-ON-NEXT excludes DURATION and END-DATE")
+	}
+	return dr, err
+}
+
+// decodeSessionData parses the attribute list of an EXT-X-SESSION-DATA
+// tag.
+func decodeSessionData(attributes string) (*SessionData, error) {
+	data := new(SessionData)
+	hasValue := false
+	for k, v := range decodeParamsLine(attributes) {
+		switch k {
+		case "DATA-ID":
+			data.DataID = v
+		case "VALUE":
+			data.Value, hasValue = v, true
+		case "URI":
+			data.URI = v
+		case "FORMAT":
+			data.Format = v
+		case "LANGUAGE":
+			data.Language = v
+		}
+	}
+	switch {
+	case data.DataID == "":
+		return data, errors.New("DATA-ID is missing")
+	case hasValue == (data.URI != ""):
+		return data, errors.New("exactly one of VALUE and URI must be present")
+	case data.Format != "" && data.Format != "JSON" && data.Format != "RAW":
+		return data, fmt.Errorf("FORMAT must be JSON or RAW, got %q", data.Format)
+	}
+	return data, nil
+}
+
+// decodeSessionKey parses the attribute list of an EXT-X-SESSION-KEY tag.
+func decodeSessionKey(attributes string) (*Key, error) {
+	key := new(Key)
+	for k, v := range decodeParamsLine(attributes) {
+		switch k {
+		case "METHOD":
+			key.Method = v
+		case "URI":
+			key.URI = v
+		case "IV":
+			key.IV = v
+		case "KEYFORMAT":
+			key.Keyformat = v
+		case "KEYFORMATVERSIONS":
+			key.Keyformatversions = v
+		}
+	}
+	switch {
+	case key.Method == "":
+		return key, errors.New("METHOD is missing")
+	case key.Method == "NONE":
+		return key, errors.New("METHOD must not be NONE")
+	case key.URI == "":
+		return key, errors.New("URI is missing")
+	}
+	return key, nil
+}
+
+// decodeServerControl parses the attribute list of an EXT-X-SERVER-CONTROL
+// tag.
+func decodeServerControl(attributes string) (*ServerControl, error) {
+	var err error
+	sc := new(ServerControl)
+	for k, v := range decodeParamsLine(attributes) {
+		var e error
+		switch k {
+		case "CAN-SKIP-UNTIL":
+			sc.CanSkipUntil, e = strconv.ParseFloat(v, 64)
+		case "CAN-SKIP-DATERANGES":
+			sc.CanSkipDateRanges = v == "YES"
+		case "HOLD-BACK":
+			sc.HoldBack, e = strconv.ParseFloat(v, 64)
+		case "PART-HOLD-BACK":
+			sc.PartHoldBack, e = strconv.ParseFloat(v, 64)
+		case "CAN-BLOCK-RELOAD":
+			sc.CanBlockReload = v == "YES"
+		}
+		if e != nil && err == nil {
+			err = fmt.Errorf("invalid %s: %q", k, v)
+		}
+	}
+	return sc, err
+}
+
+// decodePart parses the attribute list of an EXT-X-PART tag. The offset of
+// a byte range without one follows the previous part of the same URI.
+func decodePart(attributes string, previous *Part) (*Part, error) {
+	var err error
+	fail := func(format string, args ...interface{}) {
+		if err == nil {
+			err = fmt.Errorf(format, args...)
+		}
+	}
+	part := new(Part)
+	hasDuration, hasOffset := false, false
+	for k, v := range decodeParamsLine(attributes) {
+		switch k {
+		case "URI":
+			part.URI = v
+		case "DURATION":
+			hasDuration = true
+			d, e := strconv.ParseFloat(v, 64)
+			if e != nil || d < 0 {
+				fail("invalid DURATION: %q", v)
+			}
+			part.Duration = d
+		case "INDEPENDENT":
+			part.Independent = v == "YES"
+		case "GAP":
+			part.Gap = v == "YES"
+		case "BYTERANGE":
+			params := strings.SplitN(v, "@", 2)
+			var e error
+			if part.Limit, e = strconv.ParseInt(params[0], 10, 64); e != nil {
+				fail("invalid BYTERANGE: %q", v)
+			}
+			if len(params) > 1 {
+				hasOffset = true
+				if part.Offset, e = strconv.ParseInt(params[1], 10, 64); e != nil {
+					fail("invalid BYTERANGE: %q", v)
+				}
+			}
+		}
+	}
+	if part.Limit > 0 && !hasOffset {
+		if previous == nil || previous.URI != part.URI || previous.Limit == 0 {
+			fail("BYTERANGE without offset must follow a part of the same URI")
+		} else {
+			part.Offset = previous.Offset + previous.Limit
+		}
+	}
+	switch {
+	case part.URI == "":
+		fail("URI is missing")
+	case !hasDuration:
+		fail("DURATION is missing")
+	}
+	return part, err
+}
+
+// decodePreloadHint parses the attribute list of an EXT-X-PRELOAD-HINT tag.
+func decodePreloadHint(attributes string) (*PreloadHint, error) {
+	var err error
+	hint := new(PreloadHint)
+	for k, v := range decodeParamsLine(attributes) {
+		var e error
+		switch k {
+		case "TYPE":
+			hint.Type = v
+		case "URI":
+			hint.URI = v
+		case "BYTERANGE-START":
+			hint.Start, e = strconv.ParseInt(v, 10, 64)
+		case "BYTERANGE-LENGTH":
+			hint.Length, e = strconv.ParseInt(v, 10, 64)
+		}
+		if e != nil && err == nil {
+			err = fmt.Errorf("invalid %s: %q", k, v)
+		}
+	}
+	switch {
+	case err != nil:
+	case hint.Type != "PART" && hint.Type != "MAP":
+		err = fmt.Errorf("TYPE must be PART or MAP, got %q", hint.Type)
+	case hint.URI == "":
+		err = errors.New("URI is missing")
+	}
+	return hint, err
+}
+
+// decodeSkip parses the attribute list of an EXT-X-SKIP tag.
+func decodeSkip(attributes string) (*Skip, error) {
+	skip := new(Skip)
+	params := decodeParamsLine(attributes)
+	if removed := params["RECENTLY-REMOVED-DATERANGES"]; removed != "" {
+		skip.RecentlyRemovedDateRanges = strings.Split(removed, "\t")
+	}
+	v, ok := params["SKIPPED-SEGMENTS"]
+	if !ok {
+		return skip, errors.New("SKIPPED-SEGMENTS is missing")
+	}
+	var err error
+	if skip.SkippedSegments, err = strconv.ParseUint(v, 10, 64); err != nil {
+		return skip, fmt.Errorf("invalid SKIPPED-SEGMENTS: %q", v)
+	}
+	return skip, nil
+}
+
+// decodeRenditionReport parses the attribute list of an
+// EXT-X-RENDITION-REPORT tag.
+func decodeRenditionReport(attributes string) (*RenditionReport, error) {
+	var err error
+	report := &RenditionReport{LastPart: -1}
+	for k, v := range decodeParamsLine(attributes) {
+		var e error
+		switch k {
+		case "URI":
+			report.URI = v
+		case "LAST-MSN":
+			report.LastMSN, e = strconv.ParseUint(v, 10, 64)
+		case "LAST-PART":
+			report.LastPart, e = strconv.ParseInt(v, 10, 64)
+		}
+		if e != nil && err == nil {
+			err = fmt.Errorf("invalid %s: %q", k, v)
+		}
+	}
+	if err == nil && report.URI == "" {
+		err = errors.New("URI is missing")
+	}
+	return report, err
+}
+
 // StrictTimeParse implements RFC3339 with Nanoseconds accuracy.
 func StrictTimeParse(value string) (time.Time, error) {
 	return time.Parse(DATETIME, value)
@@ -15,8 +15,10 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -973,6 +975,372 @@ func TestDecodeMediaPlaylistStartTime(t *testing.T) {
 	}
 }
 
+func TestDecodeMalformedTags(t *testing.T) {
+	master := "#EXTM3U\n#EXT-X-VERSION:3\n%s\nlow.m3u8\n"
+	media := "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:10\n%s\n#EXTINF:10,\nsegment.ts\n"
+
+	tests := []struct {
+		playlist string
+		line     string
+		tag      string
+	}{
+		// master playlist
+		{master, "#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID=\"aac\",NAME=\"en\",DEFAULT=MAYBE", "EXT-X-MEDIA"},
+		{master, "#EXT-X-STREAM-INF:PROGRAM-ID=x,BANDWIDTH=1000", "EXT-X-STREAM-INF"},
+		{master, "#EXT-X-STREAM-INF:BANDWIDTH=x", "EXT-X-STREAM-INF"},
+		{master, "#EXT-X-STREAM-INF:BANDWIDTH=1000,AVERAGE-BANDWIDTH=x", "EXT-X-STREAM-INF"},
+		{master, "#EXT-X-STREAM-INF:BANDWIDTH=1000,FRAME-RATE=x", "EXT-X-STREAM-INF"},
+		{master, "#EXT-X-I-FRAME-STREAM-INF:PROGRAM-ID=x,BANDWIDTH=1000,URI=\"i.m3u8\"", "EXT-X-I-FRAME-STREAM-INF"},
+		{master, "#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=x,URI=\"i.m3u8\"", "EXT-X-I-FRAME-STREAM-INF"},
+		{master, "#EXT-X-I-FRAME-STREAM-INF:BANDWIDTH=1000,AVERAGE-BANDWIDTH=x,URI=\"i.m3u8\"", "EXT-X-I-FRAME-STREAM-INF"},
+		{master, "#EXT-X-SESSION-DATA:VALUE=\"x\"", "EXT-X-SESSION-DATA"},
+		{master, "#EXT-X-SESSION-DATA:DATA-ID=\"com.example.title\"", "EXT-X-SESSION-DATA"},
+		{master, "#EXT-X-SESSION-DATA:DATA-ID=\"com.example.title\",VALUE=\"x\",URI=\"title.json\"", "EXT-X-SESSION-DATA"},
+		{master, "#EXT-X-SESSION-DATA:DATA-ID=\"com.example.title\",URI=\"title.xml\",FORMAT=XML", "EXT-X-SESSION-DATA"},
+		{master, "#EXT-X-SESSION-KEY:URI=\"key\"", "EXT-X-SESSION-KEY"},
+		{master, "#EXT-X-SESSION-KEY:METHOD=NONE", "EXT-X-SESSION-KEY"},
+		{master, "#EXT-X-SESSION-KEY:METHOD=AES-128", "EXT-X-SESSION-KEY"},
+
+		// media playlist
+		{media, "#EXT-X-VERSION:x", "EXT-X-VERSION"},
+		{media, "#EXT-X-MEDIA-SEQUENCE:x", "EXT-X-MEDIA-SEQUENCE"},
+		{media, "#EXT-X-DISCONTINUITY-SEQUENCE:x", "EXT-X-DISCONTINUITY-SEQUENCE"},
+		{media, "#EXT-X-PLAYLIST-TYPE:", "EXT-X-PLAYLIST-TYPE"},
+		{media, "#EXT-X-START:TIME-OFFSET=x", "EXT-X-START"},
+		{media, "#EXT-X-MAP:URI=\"init.mp4\",BYTERANGE=\"x\"", "EXT-X-MAP"},
+		{media, "#EXT-X-PROGRAM-DATE-TIME:yesterday", "EXT-X-PROGRAM-DATE-TIME"},
+		{media, "#EXT-X-BYTERANGE:x", "EXT-X-BYTERANGE"},
+		{media, "#EXT-X-BYTERANGE:100@x", "EXT-X-BYTERANGE"},
+		{media, "#EXTINF:x,", "EXTINF"},
+		{media, "#EXTINF:10", "EXTINF"},
+		{media, "#WV-AUDIO-CHANNELS x", "WV-AUDIO-CHANNELS"},
+		{media, "#WV-AUDIO-FORMAT x", "WV-AUDIO-FORMAT"},
+		{media, "#WV-AUDIO-PROFILE-IDC x", "WV-AUDIO-PROFILE-IDC"},
+		{media, "#WV-AUDIO-SAMPLE-SIZE x", "WV-AUDIO-SAMPLE-SIZE"},
+		{media, "#WV-AUDIO-SAMPLING-FREQUENCY x", "WV-AUDIO-SAMPLING-FREQUENCY"},
+		{media, "#WV-ECM", "WV-ECM"},
+		{media, "#WV-VIDEO-FORMAT x", "WV-VIDEO-FORMAT"},
+		{media, "#WV-VIDEO-FRAME-RATE x", "WV-VIDEO-FRAME-RATE"},
+		{media, "#WV-VIDEO-LEVEL-IDC x", "WV-VIDEO-LEVEL-IDC"},
+		{media, "#WV-VIDEO-PROFILE-IDC x", "WV-VIDEO-PROFILE-IDC"},
+		{media, "#WV-VIDEO-SAR", "WV-VIDEO-SAR"},
+		{media, "#EXT-X-DATERANGE:START-DATE=\"2026-10-18T10:00:00Z\"", "EXT-X-DATERANGE"},
+		{media, "#EXT-X-DATERANGE:ID=\"ad1\"", "EXT-X-DATERANGE"},
+		{media, "#EXT-X-DATERANGE:ID=\"ad1\",START-DATE=\"yesterday\"", "EXT-X-DATERANGE"},
+		{media, "#EXT-X-DATERANGE:ID=\"ad1\",START-DATE=\"2026-10-18T10:00:00Z\",END-DATE=\"tomorrow\"", "EXT-X-DATERANGE"},
+		{media, "#EXT-X-DATERANGE:ID=\"ad1\",START-DATE=\"2026-10-18T10:00:00Z\",DURATION=x", "EXT-X-DATERANGE"},
+		{media, "#EXT-X-DATERANGE:ID=\"ad1\",START-DATE=\"2026-10-18T10:00:00Z\",PLANNED-DURATION=-1", "EXT-X-DATERANGE"},
+		{media, "#EXT-X-DATERANGE:ID=\"ad1\",START-DATE=\"2026-10-18T10:00:00Z\",END-ON-NEXT=NO", "EXT-X-DATERANGE"},
+		{media, "#EXT-X-DATERANGE:ID=\"ad1\",START-DATE=\"2026-10-18T10:00:00Z\",END-ON-NEXT=YES", "EXT-X-DATERANGE"},
+		{media, "#EXT-X-DATERANGE:ID=\"ad1\",CLASS=\"ad\",START-DATE=\"2026-10-18T10:00:00Z\",DURATION=10,END-ON-NEXT=YES", "EXT-X-DATERANGE"},
+		{media, "#EXT-X-PART-INF:PART-TARGET=x", "EXT-X-PART-INF"},
+		{media, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,HOLD-BACK=x", "EXT-X-SERVER-CONTROL"},
+		{media, "#EXT-X-PART:DURATION=1.0", "EXT-X-PART"},
+		{media, "#EXT-X-PART:URI=\"part.mp4\"", "EXT-X-PART"},
+		{media, "#EXT-X-PART:DURATION=x,URI=\"part.mp4\"", "EXT-X-PART"},
+		{media, "#EXT-X-PART:DURATION=1.0,URI=\"part.mp4\",BYTERANGE=1000", "EXT-X-PART"},
+		{media, "#EXT-X-PRELOAD-HINT:TYPE=SEGMENT,URI=\"part.mp4\"", "EXT-X-PRELOAD-HINT"},
+		{media, "#EXT-X-PRELOAD-HINT:TYPE=PART", "EXT-X-PRELOAD-HINT"},
+		{media, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"part.mp4\",BYTERANGE-START=x", "EXT-X-PRELOAD-HINT"},
+		{media, "#EXT-X-SKIP:RECENTLY-REMOVED-DATERANGES=\"ad1\"", "EXT-X-SKIP"},
+		{media, "#EXT-X-SKIP:SKIPPED-SEGMENTS=-1", "EXT-X-SKIP"},
+		{media, "#EXT-X-BITRATE:x", "EXT-X-BITRATE"},
+		{media, "#EXT-X-BITRATE:-1", "EXT-X-BITRATE"},
+		{media, "#EXT-X-RENDITION-REPORT:LAST-MSN=10", "EXT-X-RENDITION-REPORT"},
+		{media, "#EXT-X-RENDITION-REPORT:URI=\"low.m3u8\",LAST-MSN=x", "EXT-X-RENDITION-REPORT"},
+		{media, "#EXT-X-DEFINE:VALUE=\"x\"", "EXT-X-DEFINE"},
+		{media, "#EXT-X-DEFINE:NAME=\"cdn\"", "EXT-X-DEFINE"},
+		{media, "#EXT-X-DEFINE:NAME=\"cdn.host\",VALUE=\"x\"", "EXT-X-DEFINE"},
+		{media, "#EXT-X-DEFINE:IMPORT=\"cdn\"", "EXT-X-DEFINE"},
+		{media, "#EXT-X-DEFINE:QUERYPARAM=\"token\"", "EXT-X-DEFINE"},
+		{media, "#EXT-X-MAP:URI=\"{$cdn}/init.mp4\"", "EXT-X-MAP"},
+	}
+
+	for _, test := range tests {
+		data := fmt.Sprintf(test.playlist, test.line)
+		lineNo := 3
+		if test.playlist == media {
+			lineNo = 4
+		}
+
+		_, _, err := DecodeFrom(bytes.NewBufferString(data), true)
+		var pe *ParseError
+		if !errors.As(err, &pe) {
+			t.Errorf("%s: strict decoding error = %v, want a *ParseError", test.line, err)
+			continue
+		}
+		if pe.Line != lineNo || pe.Tag != test.tag || pe.Raw != test.line || pe.Err == nil {
+			t.Errorf("%s: strict decoding error = %+v, want line %d, tag %s", test.line, pe, lineNo, test.tag)
+		}
+
+		p, _, err := DecodeFrom(bytes.NewBufferString(data), false)
+		if err != nil {
+			t.Errorf("%s: non-strict decoding error: %v", test.line, err)
+			continue
+		}
+		var warnings []*ParseError
+		switch pl := p.(type) {
+		case *MasterPlaylist:
+			warnings = pl.Warnings
+		case *MediaPlaylist:
+			warnings = pl.Warnings
+		}
+		if len(warnings) != 1 {
+			t.Errorf("%s: non-strict decoding warnings = %v, want 1", test.line, warnings)
+			continue
+		}
+		if w := warnings[0]; w.Line != lineNo || w.Tag != test.tag || w.Raw != test.line {
+			t.Errorf("%s: warning = %+v, want line %d, tag %s", test.line, w, lineNo, test.tag)
+		}
+	}
+}
+
+func TestDecodeWarningsOfPlaylistDecoders(t *testing.T) {
+	media, err := NewMediaPlaylist(1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = media.DecodeFrom(bytes.NewBufferString("#EXT-X-TARGETDURATION:x\n#EXTINF:10,\na.ts\n"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(media.Warnings) != 2 || media.Warnings[0].Line != 1 || media.Warnings[1].Line != 0 {
+		t.Errorf("media playlist warnings = %v, want line 1 and #EXTM3U absent", media.Warnings)
+	}
+	err = media.DecodeFrom(bytes.NewBufferString("#EXTM3U\n#EXT-X-TARGETDURATION:x\n"), true)
+	if err == nil || !strings.HasPrefix(err.Error(), "line 2: EXT-X-TARGETDURATION: ") {
+		t.Errorf("media playlist error = %v", err)
+	}
+
+	master := NewMasterPlaylist()
+	err = master.DecodeFrom(bytes.NewBufferString("#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=x\nlow.m3u8\n"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(master.Warnings) != 1 || master.Warnings[0].Line != 2 || !errors.Is(master.Warnings[0], master.Warnings[0].Err) {
+		t.Errorf("master playlist warnings = %v, want line 2", master.Warnings)
+	}
+	if master.Variants[0].URI != "low.m3u8" {
+		t.Errorf("variant URI = %q, decoding should go on after a warning", master.Variants[0].URI)
+	}
+
+	_, _, err = DecodeFrom(bytes.NewBufferString("#EXTINF:10,\na.ts\n"), true)
+	if pe, ok := err.(*ParseError); !ok || pe.Line != 0 || pe.Error() != "#EXTM3U absent" {
+		t.Errorf("missing #EXTM3U error = %v", err)
+	}
+}
+
+func TestDecodeMediaPlaylistWithDateRange(t *testing.T) {
+	playlist := `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:10
+#EXT-X-PROGRAM-DATE-TIME:2026-10-18T10:00:00Z
+#EXT-X-DATERANGE:ID="splice-6FFFFFF0",START-DATE="2026-10-18T10:00:00Z",PLANNED-DURATION=59.993,SCTE35-OUT=0xFC002F0000000000FF000014056FFFFFF000E011622DCAFF000052636200000000000A0008029896F50000008700000000
+#EXT-X-DATERANGE:ID="chapter",CLASS="com.example.chapter",START-DATE="2026-10-18T10:00:00.5Z",X-COM-EXAMPLE-TITLE="Intro, part 1",X-COM-EXAMPLE-ID=0x1F,X-COM-EXAMPLE-SCORE=4.5,END-ON-NEXT=YES
+#EXTINF:10,
+a.ts
+#EXTINF:10,
+b.ts
+#EXT-X-DATERANGE:ID="splice-6FFFFFF0",START-DATE="2026-10-18T10:00:00Z",END-DATE="2026-10-18T10:01:00Z",DURATION=60,SCTE35-IN=0xFC002A0000000000FF00000F056FFFFFF000401162802E6100000000000A0008029896F50000008700000000
+`
+	p, listType, err := DecodeFrom(bytes.NewBufferString(playlist), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if listType != MEDIA {
+		t.Fatal("Sample not recognized as media playlist.")
+	}
+	pp := p.(*MediaPlaylist)
+	start := time.Date(2026, time.October, 18, 10, 0, 0, 0, time.UTC)
+
+	ranges := pp.Segments[0].DateRanges
+	if len(ranges) != 2 {
+		t.Fatalf("First segment has %d date ranges, want 2", len(ranges))
+	}
+	out := ranges[0]
+	if out.ID != "splice-6FFFFFF0" || !out.StartDate.Equal(start) || out.PlannedDuration != 59.993 ||
+		!strings.HasPrefix(out.SCTE35Out, "0xFC002F") || out.SCTE35In != "" || out.EndOnNext {
+		t.Errorf("Unexpected first date range: %+v", out)
+	}
+	chapter := ranges[1]
+	wantX := map[string]string{
+		"X-COM-EXAMPLE-TITLE": `"Intro, part 1"`,
+		"X-COM-EXAMPLE-ID":    "0x1F",
+		"X-COM-EXAMPLE-SCORE": "4.5",
+	}
+	if chapter.Class != "com.example.chapter" || !chapter.EndOnNext ||
+		!chapter.StartDate.Equal(start.Add(500*time.Millisecond)) || !reflect.DeepEqual(chapter.X, wantX) {
+		t.Errorf("Unexpected second date range: %+v", chapter)
+	}
+	if len(pp.Segments[1].DateRanges) != 0 {
+		t.Errorf("Second segment has date ranges: %v", pp.Segments[1].DateRanges)
+	}
+
+	if len(pp.DateRanges) != 1 {
+		t.Fatalf("Playlist has %d trailing date ranges, want 1", len(pp.DateRanges))
+	}
+	in := pp.DateRanges[0]
+	if in.ID != out.ID || !in.EndDate.Equal(start.Add(time.Minute)) || in.Duration != 60 || in.SCTE35In == "" {
+		t.Errorf("Unexpected trailing date range: %+v", in)
+	}
+}
+
+func TestDecodeMediaPlaylistWithLowLatencyTags(t *testing.T) {
+	f, err := os.Open("testdata/ll-hls.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p, listType, err := DecodeFrom(bufio.NewReader(f), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if listType != MEDIA {
+		t.Fatal("Sample not recognized as media playlist.")
+	}
+	pp := p.(*MediaPlaylist)
+
+	wantControl := &ServerControl{CanSkipUntil: 24, PartHoldBack: 3.012, CanBlockReload: true}
+	if !reflect.DeepEqual(pp.ServerControl, wantControl) {
+		t.Errorf("Server control = %+v, want %+v", pp.ServerControl, wantControl)
+	}
+	if pp.PartTarget != 1.004 {
+		t.Errorf("Part target = %v, want 1.004", pp.PartTarget)
+	}
+	if pp.Count() != 4 || len(pp.Segments[0].Parts) != 0 || len(pp.Segments[2].Parts) != 4 {
+		t.Fatalf("Unexpected segments and parts: %d segments", pp.Count())
+	}
+	if part := pp.Segments[2].Parts[0]; part.URI != "filePart268.0.mp4" || part.Duration != 1.00001 || !part.Independent {
+		t.Errorf("Unexpected first part of segment 268: %+v", part)
+	}
+	wantRanges := [][2]int64{{20000, 0}, {23000, 20000}, {18000, 43000}, {19000, 61000}}
+	for i, part := range pp.Segments[3].Parts {
+		if part.Limit != wantRanges[i][0] || part.Offset != wantRanges[i][1] {
+			t.Errorf("Part %d of segment 269 has byte range %d@%d, want %d@%d", i, part.Limit, part.Offset, wantRanges[i][0], wantRanges[i][1])
+		}
+	}
+	if !pp.Segments[3].Parts[3].Gap {
+		t.Error("Last part of segment 269 should be a gap")
+	}
+
+	if len(pp.Parts) != 2 || pp.Parts[1].URI != "filePart270.1.mp4" {
+		t.Errorf("Unexpected trailing parts: %v", pp.Parts)
+	}
+	wantHints := []*PreloadHint{
+		{Type: "PART", URI: "filePart270.2.mp4"},
+		{Type: "MAP", URI: "init2.mp4", Start: 100, Length: 800},
+	}
+	if !reflect.DeepEqual(pp.PreloadHints, wantHints) {
+		t.Errorf("Preload hints = %v, want %v", pp.PreloadHints, wantHints)
+	}
+	wantReports := []*RenditionReport{
+		{URI: "../1M/waitForMSN.php", LastMSN: 270, LastPart: 2},
+		{URI: "../4M/waitForMSN.php", LastMSN: 270, LastPart: -1},
+	}
+	if !reflect.DeepEqual(pp.RenditionReports, wantReports) {
+		t.Errorf("Rendition reports = %v, want %v", pp.RenditionReports, wantReports)
+	}
+}
+
+func TestDecodeMediaPlaylistDeltaUpdate(t *testing.T) {
+	f, err := os.Open("testdata/ll-hls-delta.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p, err := NewMediaPlaylist(8, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.DecodeFrom(bufio.NewReader(f), true); err != nil {
+		t.Fatal(err)
+	}
+	wantSkip := &Skip{SkippedSegments: 3, RecentlyRemovedDateRanges: []string{"splice-1", "splice-2"}}
+	if !reflect.DeepEqual(p.Skip, wantSkip) {
+		t.Errorf("Skip = %+v, want %+v", p.Skip, wantSkip)
+	}
+	if !p.ServerControl.CanSkipDateRanges {
+		t.Error("Server control should allow skipping date ranges")
+	}
+	if p.SeqNo != 266 || p.Segments[0].SeqId != 269 || p.Segments[1].SeqId != 270 {
+		t.Errorf("Segments of the delta update should follow the skipped ones, got %d and %d",
+			p.Segments[0].SeqId, p.Segments[1].SeqId)
+	}
+}
+
+func TestDecodePlaylistsWithDefine(t *testing.T) {
+	master := `#EXTM3U
+#EXT-X-VERSION:8
+#EXT-X-DEFINE:NAME="cdn",VALUE="https://cdn.example.com/live"
+#EXT-X-DEFINE:QUERYPARAM="token"
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="aac",NAME="en",URI="{$cdn}/audio.m3u8?token={$token}"
+#EXT-X-STREAM-INF:BANDWIDTH=1000000,AUDIO="aac"
+{$cdn}/720p.m3u8?token={$token}
+`
+	masterpl := NewMasterPlaylist()
+	masterpl.WithVariables(Variables{Query: url.Values{"token": {"abc"}}})
+	if err := masterpl.DecodeFrom(bytes.NewBufferString(master), true); err != nil {
+		t.Fatal(err)
+	}
+	if uri := masterpl.Variants[0].URI; uri != "https://cdn.example.com/live/720p.m3u8?token=abc" {
+		t.Errorf("Variant URI = %q", uri)
+	}
+	if uri := masterpl.Variants[0].Alternatives[0].URI; uri != "https://cdn.example.com/live/audio.m3u8?token=abc" {
+		t.Errorf("Alternative URI = %q", uri)
+	}
+	wantDefines := []*Define{
+		{Name: "cdn", Type: VALUE, Value: "https://cdn.example.com/live"},
+		{Name: "token", Type: QUERYPARAM, Value: "abc"},
+	}
+	if !reflect.DeepEqual(masterpl.Defines, wantDefines) {
+		t.Errorf("Defines = %v, want %v", masterpl.Defines, wantDefines)
+	}
+
+	media := `#EXTM3U
+#EXT-X-VERSION:8
+#EXT-X-TARGETDURATION:10
+#EXT-X-DEFINE:IMPORT="cdn"
+#EXT-X-DEFINE:NAME="path",VALUE="{$cdn}/720p"
+#EXT-X-KEY:METHOD=AES-128,URI="{$path}/key"
+#EXTINF:10,
+{$path}/seg1.ts
+#EXT-X-ENDLIST
+`
+	p, listType, err := DecodeWithVariables(bytes.NewBufferString(media), true, Variables{Imports: masterpl.DefinedVariables()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if listType != MEDIA {
+		t.Fatal("Sample not recognized as media playlist.")
+	}
+	pp := p.(*MediaPlaylist)
+	if uri := pp.Segments[0].URI; uri != "https://cdn.example.com/live/720p/seg1.ts" {
+		t.Errorf("Segment URI = %q", uri)
+	}
+	if uri := pp.Key.URI; uri != "https://cdn.example.com/live/720p/key" {
+		t.Errorf("Key URI = %q", uri)
+	}
+	if len(pp.Defines) != 2 || pp.Defines[0].Type != IMPORT || pp.Defines[0].Value != "https://cdn.example.com/live" {
+		t.Errorf("Unexpected defines: %v", pp.Defines)
+	}
+	vars := pp.DefinedVariables()
+	if got := SubstituteVariables("{$path}/seg2.ts?v={$version}", vars); got != "https://cdn.example.com/live/720p/seg2.ts?v={$version}" {
+		t.Errorf("SubstituteVariables = %q", got)
+	}
+
+	// the master playlist variables are only imported when given
+	_, _, err = DecodeFrom(bytes.NewBufferString(media), true)
+	if err == nil || !strings.Contains(err.Error(), `imported variable "cdn" is not defined`) {
+		t.Errorf("Decoding without imports error = %v", err)
+	}
+
+	duplicate := "#EXTM3U\n#EXT-X-DEFINE:NAME=\"a\",VALUE=\"1\"\n#EXT-X-DEFINE:NAME=\"a\",VALUE=\"2\"\n"
+	_, _, err = DecodeFrom(bytes.NewBufferString(duplicate), true)
+	if pe, ok := err.(*ParseError); !ok || pe.Line != 3 || pe.Tag != "EXT-X-DEFINE" {
+		t.Errorf("Duplicate variable error = %v", err)
+	}
+}
+
 /****************
  *  Benchmarks  *
  ****************/
@@ -1005,3 +1373,127 @@ func BenchmarkDecodeMediaPlaylist(b *testing.B) {
 		}
 	}
 }
+
+func TestDecodeMasterPlaylistWithSessionData(t *testing.T) {
+	master := `#EXTM3U
+#EXT-X-VERSION:7
+#EXT-X-SESSION-DATA:DATA-ID="com.apple.hls.title",VALUE="Keynote",LANGUAGE="en"
+#EXT-X-SESSION-DATA:DATA-ID="com.example.meta",URI="meta.json"
+#EXT-X-SESSION-DATA:DATA-ID="com.example.notes",URI="notes.txt",FORMAT=RAW
+#EXT-X-SESSION-KEY:METHOD=AES-128,URI="https://keys.example.com/1",IV=0x00000000000000000000000000000001
+#EXT-X-STREAM-INF:BANDWIDTH=1000000
+720p.m3u8
+`
+	p, listType, err := DecodeFrom(bytes.NewBufferString(master), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if listType != MASTER {
+		t.Fatal("Sample not recognized as master playlist.")
+	}
+	masterpl := p.(*MasterPlaylist)
+	wantData := []*SessionData{
+		{DataID: "com.apple.hls.title", Value: "Keynote", Language: "en"},
+		{DataID: "com.example.meta", URI: "meta.json"},
+		{DataID: "com.example.notes", URI: "notes.txt", Format: "RAW"},
+	}
+	if !reflect.DeepEqual(masterpl.SessionData, wantData) {
+		t.Errorf("SessionData = %+v, want %+v", masterpl.SessionData, wantData)
+	}
+	wantKeys := []*Key{{Method: "AES-128", URI: "https://keys.example.com/1", IV: "0x00000000000000000000000000000001"}}
+	if !reflect.DeepEqual(masterpl.SessionKeys, wantKeys) {
+		t.Errorf("SessionKeys = %+v, want %+v", masterpl.SessionKeys, wantKeys)
+	}
+	if len(masterpl.Variants) != 1 {
+		t.Errorf("Variants = %d, want 1", len(masterpl.Variants))
+	}
+
+	// a master playlist without variants is recognized by its session tags
+	_, listType, err = DecodeFrom(bytes.NewBufferString("#EXTM3U\n#EXT-X-SESSION-DATA:DATA-ID=\"a\",VALUE=\"b\"\n"), true)
+	if err != nil || listType != MASTER {
+		t.Errorf("Session data only playlist: type %v, error %v", listType, err)
+	}
+}
+
+func TestDecodeMediaPlaylistWithGapAndBitrate(t *testing.T) {
+	playlist := `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:4
+#EXT-X-BITRATE:1200
+#EXTINF:4.000,
+seg0.ts
+#EXT-X-GAP
+#EXTINF:4.000,
+seg1.ts
+#EXT-X-BITRATE:800
+#EXTINF:4.000,
+seg2.ts
+#EXT-X-ENDLIST
+`
+	p, listType, err := DecodeFrom(bytes.NewBufferString(playlist), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if listType != MEDIA {
+		t.Fatal("Sample not recognized as media playlist.")
+	}
+	pp := p.(*MediaPlaylist)
+	for i, want := range []struct {
+		gap     bool
+		bitrate int64
+	}{{false, 1200}, {true, 1200}, {false, 800}} {
+		seg := pp.Segments[i]
+		if seg.Gap != want.gap || seg.Bitrate != want.bitrate {
+			t.Errorf("Segment %d: gap %v, bitrate %d, want %v, %d", i, seg.Gap, seg.Bitrate, want.gap, want.bitrate)
+		}
+	}
+}
+
+func TestDecodeMediaPlaylistWithBareCueOut(t *testing.T) {
+	playlist := `#EXTM3U
+#EXT-X-TARGETDURATION:10
+#EXTINF:10,
+content0.ts
+#EXT-X-CUE-OUT:30
+#EXTINF:10,
+ad0.ts
+#EXT-X-CUE-OUT-CONT:ElapsedTime=10,Duration=30
+#EXTINF:10,
+ad1.ts
+#EXT-X-CUE-IN
+#EXTINF:10,
+content1.ts
+#EXT-X-CUE-OUT:DURATION=15.5
+#EXTINF:10,
+ad2.ts
+#EXT-X-CUE-IN
+#EXT-X-CUE-OUT
+#EXTINF:10,
+ad3.ts
+#EXT-X-CUE-IN
+#EXT-OATCLS-SCTE35:/DAlAAAAAAAAAP/wFAUAAAABf+/+ANgNkv4AFJlwAAEBAQAA5xULLA==
+#EXT-X-CUE-OUT:20
+#EXTINF:10,
+ad4.ts
+#EXT-X-ENDLIST
+`
+	p, _, err := DecodeFrom(bytes.NewBufferString(playlist), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pp := p.(*MediaPlaylist)
+	for i, want := range []*SCTE{
+		nil,
+		{Syntax: SCTE35_OATCLS, CueType: SCTE35Cue_Start, Time: 30},
+		{Syntax: SCTE35_OATCLS, CueType: SCTE35Cue_Mid, Time: 30, Elapsed: 10},
+		{Syntax: SCTE35_OATCLS, CueType: SCTE35Cue_End},
+		{Syntax: SCTE35_OATCLS, CueType: SCTE35Cue_Start, Time: 15.5},
+		// a cue-in directly followed by a cue-out, the new break wins
+		{Syntax: SCTE35_OATCLS, CueType: SCTE35Cue_Start},
+		{Syntax: SCTE35_OATCLS, CueType: SCTE35Cue_Start, Time: 20, Cue: "/DAlAAAAAAAAAP/wFAUAAAABf+/+ANgNkv4AFJlwAAEBAQAA5xULLA=="},
+	} {
+		if got := pp.Segments[i].SCTE; !reflect.DeepEqual(got, want) {
+			t.Errorf("Segment %d SCTE = %+v, want %+v", i, got, want)
+		}
+	}
+}
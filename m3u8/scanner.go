@@ -0,0 +1,224 @@
+package m3u8
+
+/*
+ Part of M3U8 parser & generator library.
+ This file defines the incremental playlist decoder.
+*/
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strings"
+)
+
+// Item is a playlist element yielded by Scanner: a tag, or the media
+// segment or variant completed by a URI line.
+type Item struct {
+	Line     int           // 1-based line number
+	Tag      string        // name of the tag without '#', like EXT-X-KEY; empty for URI lines
+	Value    string        // text of the tag after its ':'
+	Segment  *MediaSegment // the segment of a URI line, with the tags before it applied
+	Variant  *Variant      // the variant of a URI line or of an EXT-X-I-FRAME-STREAM-INF tag
+	Warnings []*ParseError // problems of the line in non-strict mode
+}
+
+// Scanner decodes a playlist from an io.Reader one line at a time and
+// yields its tags, segments and variants as they are read, without keeping
+// them: memory use doesn't grow with the number of segments. The lines are
+// decoded like Decode does, the tags before a segment URI are applied to
+// the segment yielded with it.
+//
+//	s := m3u8.NewScanner(r, false)
+//	for s.Scan() {
+//		if seg := s.Item().Segment; seg != nil {
+//			...
+//		}
+//	}
+//	if err := s.Err(); err != nil {
+//		...
+//	}
+type Scanner struct {
+	r      *bufio.Reader
+	strict bool
+	state  *decodingState
+	wv     *WV
+	master *MasterPlaylist
+	media  *MediaPlaylist
+	lineNo int
+	item   Item
+	eof    bool
+	done   bool // the end of the playlist was handled
+	err    error
+}
+
+// NewScanner returns a Scanner reading a playlist from r. If strict is
+// true, scanning stops at the first syntax error, returned by Err.
+func NewScanner(r io.Reader, strict bool) *Scanner {
+	media, _ := NewMediaPlaylist(0, 2) // the segment being yielded and the one before
+	return &Scanner{
+		r:      bufio.NewReader(r),
+		strict: strict,
+		state:  &decodingState{},
+		wv:     new(WV),
+		master: NewMasterPlaylist(),
+		media:  media,
+	}
+}
+
+// WithVariables sets the values the EXT-X-DEFINE tags of the playlist
+// import, see MediaPlaylist.WithVariables. It must be called before Scan.
+func (s *Scanner) WithVariables(v Variables) *Scanner {
+	s.state.imports = v
+	return s
+}
+
+// WithCustomDecoders adds custom tag decoders, see DecodeWith. It must be
+// called before Scan.
+func (s *Scanner) WithCustomDecoders(customDecoders []CustomDecoder) *Scanner {
+	s.master.WithCustomDecoders(customDecoders)
+	s.media.WithCustomDecoders(customDecoders)
+	s.state.custom = make(map[string]CustomTag)
+	return s
+}
+
+// Scan advances to the next item, available with Item. It returns false at
+// the end of the playlist or on an error, available with Err.
+func (s *Scanner) Scan() bool {
+	for s.err == nil && !s.eof {
+		line, err := s.r.ReadString('\n')
+		if err == io.EOF {
+			s.eof = true
+		} else if err != nil {
+			s.err = err
+			return false
+		}
+		if line == "" {
+			break
+		}
+		s.lineNo++
+		if item, ok := s.decodeLine(line); ok {
+			s.item = item
+			return true
+		}
+	}
+	if s.err == nil && !s.done {
+		s.done = true
+		s.finish()
+	}
+	return false
+}
+
+// decodeLine decodes line, it returns false for the lines that aren't an
+// item: empty lines, comments and stray URIs.
+func (s *Scanner) decodeLine(line string) (Item, bool) {
+	state := s.state
+	if strings.TrimSpace(line) == "" {
+		return Item{}, false
+	}
+	line, err := state.expand(line)
+	if state.check(s.strict, err) != nil {
+		s.err = strictError(s.lineNo, line, err)
+		return Item{}, false
+	}
+
+	segment, variant := state.tagInf, state.tagStreamInf
+	masterErr := decodeLineOfMasterPlaylist(s.master, state, line, s.strict)
+	if s.strict && masterErr != nil {
+		s.err = strictError(s.lineNo, line, masterErr)
+		return Item{}, false
+	}
+	mediaErr := decodeLineOfMediaPlaylist(s.media, s.wv, state, line, s.strict)
+	if s.strict && mediaErr != nil {
+		s.err = strictError(s.lineNo, line, mediaErr)
+		return Item{}, false
+	}
+	// the variants are yielded, not kept
+	s.master.Variants = nil
+
+	item := Item{Line: s.lineNo, Warnings: state.lineWarnings(s.lineNo, line, masterErr, mediaErr)}
+	trimmed := strings.TrimSpace(line)
+	switch {
+	case strings.HasPrefix(trimmed, "#EXT"):
+		item.Tag, item.Value, _ = strings.Cut(trimmed[1:], ":")
+		if item.Tag == "EXT-X-I-FRAME-STREAM-INF" {
+			item.Variant = state.variant
+		}
+	case strings.HasPrefix(trimmed, "#"):
+		return Item{}, false
+	case segment && !state.tagInf:
+		item.Segment = s.media.Segments[s.media.last()]
+		s.dropPrevious()
+	case variant && !state.tagStreamInf:
+		item.Variant = state.variant
+	default:
+		return Item{}, false
+	}
+	return item, true
+}
+
+// dropPrevious removes from the media playlist the segment before the one
+// just appended, which stays for the next one to take its sequence number.
+func (s *Scanner) dropPrevious() {
+	p := s.media
+	if p.count < 2 {
+		return
+	}
+	p.Segments[p.head] = nil
+	p.head = (p.head + 1) % p.capacity
+	p.count--
+}
+
+// finish applies the tags trailing the last segment to the playlist and
+// checks the playlist started with EXTM3U.
+func (s *Scanner) finish() {
+	state := s.state
+	if state.listType == MEDIA && state.tagWV {
+		s.media.WV = s.wv
+	}
+	s.media.DateRanges = state.dateRanges
+	s.media.Parts = state.parts
+	s.master.Defines = state.defines
+	s.media.Defines = state.defines
+	if !state.m3u {
+		if s.strict {
+			s.err = errM3UAbsent()
+			return
+		}
+		s.media.Warnings = append(s.media.Warnings, errM3UAbsent())
+		s.master.Warnings = append(s.master.Warnings, errM3UAbsent())
+	}
+	if state.listType != MASTER && state.listType != MEDIA {
+		s.err = errors.New("Can't detect playlist type")
+	}
+}
+
+// Item returns the item read by the last call to Scan.
+func (s *Scanner) Item() Item {
+	return s.item
+}
+
+// Err returns the first error met by Scan, nil at the end of the playlist.
+func (s *Scanner) Err() error {
+	return s.err
+}
+
+// ListType returns the type of the playlist, known from the first tag
+// specific to media or master playlists.
+func (s *Scanner) ListType() ListType {
+	return s.state.listType
+}
+
+// Media returns the attributes of the media playlist read so far, like
+// TargetDuration, SeqNo or Closed once EXT-X-ENDLIST is read, and
+// at the end the trailing DateRanges, Parts and the Warnings about the
+// whole playlist. Its Segments only hold the last segment scanned.
+func (s *Scanner) Media() *MediaPlaylist {
+	return s.media
+}
+
+// Master returns the attributes of the master playlist read so far, like
+// SessionData and Defines. Its Variants are yielded as items and not kept.
+func (s *Scanner) Master() *MasterPlaylist {
+	return s.master
+}
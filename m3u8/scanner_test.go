@@ -0,0 +1,280 @@
+/*
+Incremental playlist decoding tests.
+*/
+package m3u8
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// largePlaylist returns a VOD media playlist of n segments.
+func largePlaylist(n int) []byte {
+	var b bytes.Buffer
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-TARGETDURATION:10\n")
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, "#EXTINF:10.000,\nmovie%d.ts\n", i)
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+	return b.Bytes()
+}
+
+// scannerPlaylists are the media playlists scanned and decoded by the
+// tests, by name.
+func scannerPlaylists(t *testing.T) map[string][]byte {
+	t.Helper()
+	playlists := map[string][]byte{"large": largePlaylist(40000)}
+	for _, name := range []string{
+		"testdata/media-playlist-with-byterange.m3u8",
+		"testdata/media-playlist-with-discontinuity.m3u8",
+		"testdata/media-playlist-with-program-date-time.m3u8",
+		"testdata/media-playlist-with-scte35.m3u8",
+		"testdata/media-playlist-with-oatcls-scte35.m3u8",
+	} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		playlists[name] = data
+	}
+	return playlists
+}
+
+// scanSegments returns the segments yielded scanning the playlist.
+func scanSegments(t *testing.T, data []byte) ([]*MediaSegment, *Scanner) {
+	t.Helper()
+	s := NewScanner(bytes.NewReader(data), false)
+	var segments []*MediaSegment
+	for s.Scan() {
+		if seg := s.Item().Segment; seg != nil {
+			segments = append(segments, seg)
+		}
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return segments, s
+}
+
+func TestScannerMatchesDecode(t *testing.T) {
+	for name, data := range scannerPlaylists(t) {
+		p, listType, err := DecodeFrom(bytes.NewReader(data), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if listType != MEDIA {
+			t.Fatalf("%s: got list type %v, want MEDIA", name, listType)
+		}
+		pp := p.(*MediaPlaylist)
+		want := pp.Segments[:pp.Count()]
+
+		got, s := scanSegments(t, data)
+		if s.ListType() != MEDIA {
+			t.Errorf("%s: scanned list type %v, want MEDIA", name, s.ListType())
+		}
+		if len(got) != len(want) {
+			t.Fatalf("%s: scanned %d segments, decoded %d", name, len(got), len(want))
+		}
+		for i := range want {
+			if !reflect.DeepEqual(got[i], want[i]) {
+				t.Errorf("%s: segment %d:\n got %+v\nwant %+v", name, i, got[i], want[i])
+			}
+		}
+		media := s.Media()
+		if media.TargetDuration != pp.TargetDuration || media.SeqNo != pp.SeqNo || media.Closed != pp.Closed {
+			t.Errorf("%s: scanned header %v/%d/%t, decoded %v/%d/%t", name,
+				media.TargetDuration, media.SeqNo, media.Closed, pp.TargetDuration, pp.SeqNo, pp.Closed)
+		}
+	}
+}
+
+func TestScannerKeepsOneSegment(t *testing.T) {
+	_, s := scanSegments(t, largePlaylist(40000))
+	if n := s.Media().Count(); n != 1 {
+		t.Errorf("scanner kept %d segments, want 1", n)
+	}
+}
+
+func TestScannerItems(t *testing.T) {
+	playlist := `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-TARGETDURATION:10
+# a comment
+
+#EXT-X-KEY:METHOD=AES-128,URI="key"
+#EXTINF:10,first
+a.ts
+#EXT-X-DISCONTINUITY
+#EXTINF:9.5,
+b.ts
+#EXT-X-ENDLIST
+`
+	s := NewScanner(strings.NewReader(playlist), true)
+	var items []string
+	for s.Scan() {
+		item := s.Item()
+		switch {
+		case item.Segment != nil:
+			items = append(items, item.Segment.URI)
+		default:
+			items = append(items, item.Tag)
+		}
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"EXTM3U", "EXT-X-VERSION", "EXT-X-TARGETDURATION", "EXT-X-KEY", "EXTINF", "a.ts",
+		"EXT-X-DISCONTINUITY", "EXTINF", "b.ts", "EXT-X-ENDLIST"}
+	if !reflect.DeepEqual(items, want) {
+		t.Errorf("got items %q, want %q", items, want)
+	}
+	if !s.Media().Closed {
+		t.Error("playlist not closed after EXT-X-ENDLIST")
+	}
+}
+
+func TestScannerSegmentTags(t *testing.T) {
+	playlist := `#EXTM3U
+#EXT-X-TARGETDURATION:10
+#EXT-X-MEDIA-SEQUENCE:7
+#EXT-X-KEY:METHOD=AES-128,URI="key"
+#EXTINF:10,
+a.ts
+#EXT-X-DISCONTINUITY
+#EXT-X-BYTERANGE:100@200
+#EXTINF:9.5,
+b.ts
+`
+	s := NewScanner(strings.NewReader(playlist), true)
+	var segments []*MediaSegment
+	for s.Scan() {
+		if seg := s.Item().Segment; seg != nil {
+			segments = append(segments, seg)
+		}
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 2 {
+		t.Fatalf("got %d segments, want 2", len(segments))
+	}
+	a, b := segments[0], segments[1]
+	if a.SeqId != 7 || a.Key == nil || a.Key.URI != "key" || a.Discontinuity {
+		t.Errorf("unexpected first segment %+v", a)
+	}
+	if b.SeqId != 8 || !b.Discontinuity || b.Limit != 100 || b.Offset != 200 || b.Duration != 9.5 {
+		t.Errorf("unexpected second segment %+v", b)
+	}
+}
+
+func TestScannerMasterPlaylist(t *testing.T) {
+	f, err := os.Open("testdata/master-with-i-frame-stream-inf.m3u8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	s := NewScanner(f, false)
+	var uris []string
+	var iframes int
+	for s.Scan() {
+		if v := s.Item().Variant; v != nil {
+			uris = append(uris, v.URI)
+			if v.Iframe {
+				iframes++
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if s.ListType() != MASTER {
+		t.Errorf("got list type %v, want MASTER", s.ListType())
+	}
+	if len(uris) == 0 || iframes == 0 {
+		t.Fatalf("got variants %q with %d I-frame variants", uris, iframes)
+	}
+	for _, uri := range uris {
+		if uri == "" {
+			t.Errorf("variant without URI in %q", uris)
+		}
+	}
+	if len(s.Master().Variants) != 0 {
+		t.Errorf("scanner kept %d variants", len(s.Master().Variants))
+	}
+}
+
+func TestScannerStrictError(t *testing.T) {
+	playlist := `#EXTM3U
+#EXT-X-TARGETDURATION:10
+#EXTINF:10,
+a.ts
+#EXT-X-BITRATE:fast
+#EXTINF:10,
+b.ts
+`
+	s := NewScanner(strings.NewReader(playlist), true)
+	var n int
+	for s.Scan() {
+		n++
+	}
+	var pe *ParseError
+	if !errors.As(s.Err(), &pe) || pe.Line != 5 || pe.Tag != "EXT-X-BITRATE" {
+		t.Fatalf("got error %v, want a parse error of line 5", s.Err())
+	}
+	if n != 4 {
+		t.Errorf("got %d items before the error, want 4", n)
+	}
+
+	s = NewScanner(strings.NewReader(playlist), false)
+	var warnings []*ParseError
+	for s.Scan() {
+		warnings = append(warnings, s.Item().Warnings...)
+	}
+	if s.Err() != nil {
+		t.Fatal(s.Err())
+	}
+	if len(warnings) != 1 || warnings[0].Line != 5 {
+		t.Errorf("got warnings %v, want one for line 5", warnings)
+	}
+}
+
+func TestScannerWithoutM3U(t *testing.T) {
+	s := NewScanner(strings.NewReader("#EXT-X-TARGETDURATION:10\n#EXTINF:10,\na.ts\n"), true)
+	for s.Scan() {
+	}
+	if s.Err() == nil || !strings.Contains(s.Err().Error(), "#EXTM3U absent") {
+		t.Errorf("got error %v, want #EXTM3U absent", s.Err())
+	}
+}
+
+func BenchmarkScanMediaPlaylist(b *testing.B) {
+	data := largePlaylist(40000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s := NewScanner(bytes.NewReader(data), true)
+		for s.Scan() {
+		}
+		if err := s.Err(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkDecodeFromMediaPlaylist decodes the playlist of
+// BenchmarkScanMediaPlaylist whole, for comparison.
+func BenchmarkDecodeFromMediaPlaylist(b *testing.B) {
+	data := largePlaylist(40000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := DecodeFrom(bytes.NewReader(data), true); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -14,6 +14,7 @@ package m3u8
 import (
 	"bytes"
 	"io"
+	"net/url"
 	"time"
 )
 
@@ -78,6 +79,16 @@ const (
 	SCTE35Cue_End                        // SCTE35Cue_End indicates an in cue point
 )
 
+// DefineType is the way an EXT-X-DEFINE tag gives the value of its
+// variable
+type DefineType uint
+
+const (
+	VALUE      DefineType = iota // VALUE defines the value in the playlist
+	IMPORT                       // IMPORT takes the value of the variable of the master playlist
+	QUERYPARAM                   // QUERYPARAM takes the value of the query parameter of the playlist URL
+)
+
 // MediaPlaylist structure represents a single bitrate playlist aka
 // media playlist. It related to both a simple media playlists and a
 // sliding window media playlists. URI lines in the Playlist point to
@@ -129,7 +140,17 @@ type MediaPlaylist struct {
 	Map              *Map // EXT-X-MAP is optional tag specifies how to obtain the Media Initialization Section (default map for the playlist)
 	WV               *WV  // Widevine related tags outside of M3U8 specs
 	Custom           map[string]CustomTag
+	Warnings         []*ParseError // problems skipped by a non-strict decoding
+	DateRanges       []*DateRange  // EXT-X-DATERANGE tags after the last segment, the others belong to the segment they precede
+	Defines          []*Define     // EXT-X-DEFINE variables, their references are replaced with the values while decoding
+	PartTarget       float64       // EXT-X-PART-INF PART-TARGET of Low-Latency HLS playlists, in seconds
+	ServerControl    *ServerControl
+	Skip             *Skip              // EXT-X-SKIP of a playlist delta update
+	Parts            []*Part            // EXT-X-PART tags after the last segment, of the segment being produced
+	PreloadHints     []*PreloadHint     // EXT-X-PRELOAD-HINT
+	RenditionReports []*RenditionReport // EXT-X-RENDITION-REPORT
 	customDecoders   []CustomDecoder
+	variables        Variables
 }
 
 // MasterPlaylist structure represents a master playlist which
@@ -153,7 +174,12 @@ type MasterPlaylist struct {
 	ver                 uint8
 	independentSegments bool
 	Custom              map[string]CustomTag
+	Warnings            []*ParseError // problems skipped by a non-strict decoding
+	Defines             []*Define     // EXT-X-DEFINE variables, their references are replaced with the values while decoding
+	SessionData         []*SessionData
+	SessionKeys         []*Key // EXT-X-SESSION-KEY, keys of the media playlists a client may load in advance
 	customDecoders      []CustomDecoder
+	variables           Variables
 }
 
 // Variant structure represents variants for master playlist.
@@ -206,14 +232,18 @@ type MediaSegment struct {
 	SeqId           uint64
 	Title           string // optional second parameter for EXTINF tag
 	URI             string
-	Duration        float64   // first parameter for EXTINF tag; duration must be integers if protocol version is less than 3 but we are always keep them float
-	Limit           int64     // EXT-X-BYTERANGE <n> is length in bytes for the file under URI
-	Offset          int64     // EXT-X-BYTERANGE [@o] is offset from the start of the file under URI
-	Key             *Key      // EXT-X-KEY displayed before the segment and means changing of encryption key (in theory each segment may have own key)
-	Map             *Map      // EXT-X-MAP displayed before the segment
-	Discontinuity   bool      // EXT-X-DISCONTINUITY indicates an encoding discontinuity between the media segment that follows it and the one that preceded it (i.e. file format, number and type of tracks, encoding parameters, encoding sequence, timestamp sequence)
-	SCTE            *SCTE     // SCTE-35 used for Ad signaling in HLS
-	ProgramDateTime time.Time // EXT-X-PROGRAM-DATE-TIME tag associates the first sample of a media segment with an absolute date and/or time
+	Duration        float64      // first parameter for EXTINF tag; duration must be integers if protocol version is less than 3 but we are always keep them float
+	Limit           int64        // EXT-X-BYTERANGE <n> is length in bytes for the file under URI
+	Offset          int64        // EXT-X-BYTERANGE [@o] is offset from the start of the file under URI
+	Key             *Key         // EXT-X-KEY displayed before the segment and means changing of encryption key (in theory each segment may have own key)
+	Map             *Map         // EXT-X-MAP displayed before the segment
+	Discontinuity   bool         // EXT-X-DISCONTINUITY indicates an encoding discontinuity between the media segment that follows it and the one that preceded it (i.e. file format, number and type of tracks, encoding parameters, encoding sequence, timestamp sequence)
+	SCTE            *SCTE        // SCTE-35 used for Ad signaling in HLS
+	ProgramDateTime time.Time    // EXT-X-PROGRAM-DATE-TIME tag associates the first sample of a media segment with an absolute date and/or time
+	DateRanges      []*DateRange // EXT-X-DATERANGE tags displayed before the segment
+	Parts           []*Part      // EXT-X-PART tags of the segment, displayed before it
+	Gap             bool         // EXT-X-GAP indicates the segment is missing and must not be loaded
+	Bitrate         int64        // EXT-X-BITRATE approximate bitrate of the segment in kbit/s, it applies until the next EXT-X-BITRATE tag
 	Custom          map[string]CustomTag
 }
 
@@ -227,6 +257,105 @@ type SCTE struct {
 	Elapsed float64
 }
 
+// DateRange associates a range of time with attributes, like an ad break
+// signaled with SCTE-35.
+//
+// Realizes EXT-X-DATERANGE tag.
+type DateRange struct {
+	ID              string
+	Class           string
+	StartDate       time.Time
+	EndDate         time.Time         // zero when absent
+	Duration        float64           // seconds, zero when absent
+	PlannedDuration float64           // seconds, zero when absent
+	SCTE35Cmd       string            // hexadecimal SCTE-35 splice_info_section, like 0xFC30...
+	SCTE35Out       string            // hexadecimal SCTE-35 splice_info_section
+	SCTE35In        string            // hexadecimal SCTE-35 splice_info_section
+	EndOnNext       bool              // the range ends at the start of the next range of the same Class
+	X               map[string]string // client attributes by name, like X-COM-EXAMPLE-AD-ID, with their values as written (quoted strings keep their quotes)
+}
+
+// SessionData represents arbitrary session data of a master playlist,
+// given with its VALUE or as a resource to load from URI.
+//
+// Realizes EXT-X-SESSION-DATA tag.
+type SessionData struct {
+	DataID   string // reverse DNS name, like com.apple.hls.title
+	Value    string
+	URI      string
+	Format   string // format of the URI resource, JSON (default) or RAW
+	Language string
+}
+
+// Define represents a variable of the playlist. Its value replaces the
+// {$name} references of the URIs and attributes that follow.
+//
+// Realizes EXT-X-DEFINE tag.
+type Define struct {
+	Name  string
+	Type  DefineType
+	Value string // VALUE, or the imported value once decoded
+}
+
+// Variables are the values imported by the EXT-X-DEFINE tags of a
+// playlist being decoded.
+type Variables struct {
+	Imports map[string]string // variables of the master playlist, for IMPORT
+	Query   url.Values        // query parameters of the playlist URL, for QUERYPARAM
+}
+
+// Part represents a partial segment of a Low-Latency HLS playlist.
+//
+// Realizes EXT-X-PART tag.
+type Part struct {
+	URI         string
+	Duration    float64
+	Independent bool  // the part starts with an independent frame
+	Limit       int64 // BYTERANGE <n> is length in bytes for the file under URI
+	Offset      int64 // BYTERANGE [@o] is offset from the start of the file under URI, computed from the previous part when omitted
+	Gap         bool  // the part is not available
+}
+
+// PreloadHint announces a resource the server will produce next, so a
+// client can request it before it is listed.
+//
+// Realizes EXT-X-PRELOAD-HINT tag.
+type PreloadHint struct {
+	Type   string // PART or MAP
+	URI    string
+	Start  int64 // BYTERANGE-START
+	Length int64 // BYTERANGE-LENGTH, zero when the hint runs to the end of the resource
+}
+
+// ServerControl holds the delivery directives of a Low-Latency HLS
+// server.
+//
+// Realizes EXT-X-SERVER-CONTROL tag.
+type ServerControl struct {
+	CanSkipUntil      float64 // seconds, zero when playlist delta updates are not supported
+	CanSkipDateRanges bool
+	HoldBack          float64 // seconds
+	PartHoldBack      float64 // seconds
+	CanBlockReload    bool    // the server supports blocking playlist reloads with _HLS_msn and _HLS_part
+}
+
+// Skip tells how many segments a playlist delta update left out.
+//
+// Realizes EXT-X-SKIP tag.
+type Skip struct {
+	SkippedSegments           uint64
+	RecentlyRemovedDateRanges []string // IDs of the removed EXT-X-DATERANGE tags
+}
+
+// RenditionReport tells the last segment and part of another rendition.
+//
+// Realizes EXT-X-RENDITION-REPORT tag.
+type RenditionReport struct {
+	URI      string
+	LastMSN  uint64
+	LastPart int64 // -1 when absent
+}
+
 // Key structure represents information about stream encryption.
 //
 // Realizes EXT-X-KEY tag.
@@ -321,6 +450,8 @@ type decodingState struct {
 	tagKey             bool
 	tagMap             bool
 	tagCustom          bool
+	tagGap             bool
+	bitrate            int64 // last EXT-X-BITRATE, applies to the following segments
 	programDateTime    time.Time
 	limit              int64
 	offset             int64
@@ -331,5 +462,12 @@ type decodingState struct {
 	xkey               *Key
 	xmap               *Map
 	scte               *SCTE
+	dateRanges         []*DateRange
+	parts              []*Part
+	lastPart           *Part
+	defines            []*Define
+	vars               map[string]string // values of the defined variables by name
+	imports            Variables
 	custom             map[string]CustomTag
+	warnings           []error // problems of the line being decoded, see check
 }
@@ -16,6 +16,7 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -75,14 +76,65 @@ func (p *MasterPlaylist) Encode() *bytes.Buffer {
 		return &p.buf
 	}
 
+	if len(p.Defines) > 0 {
+		version(&p.ver, 8) // due section 4.4.2.3
+	}
 	p.buf.WriteString("#EXTM3U\n#EXT-X-VERSION:")
 	p.buf.WriteString(strver(p.ver))
 	p.buf.WriteRune('\n')
+	writeDefines(&p.buf, p.Defines)
 
 	if p.IndependentSegments() {
 		p.buf.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
 	}
 
+	for _, data := range p.SessionData {
+		p.buf.WriteString("#EXT-X-SESSION-DATA:DATA-ID=\"")
+		p.buf.WriteString(data.DataID)
+		p.buf.WriteRune('"')
+		if data.URI != "" {
+			p.buf.WriteString(",URI=\"")
+			p.buf.WriteString(data.URI)
+			p.buf.WriteRune('"')
+			if data.Format != "" {
+				p.buf.WriteString(",FORMAT=")
+				p.buf.WriteString(data.Format)
+			}
+		} else {
+			p.buf.WriteString(",VALUE=\"")
+			p.buf.WriteString(data.Value)
+			p.buf.WriteRune('"')
+		}
+		if data.Language != "" {
+			p.buf.WriteString(",LANGUAGE=\"")
+			p.buf.WriteString(data.Language)
+			p.buf.WriteRune('"')
+		}
+		p.buf.WriteRune('\n')
+	}
+	for _, key := range p.SessionKeys {
+		p.buf.WriteString("#EXT-X-SESSION-KEY:METHOD=")
+		p.buf.WriteString(key.Method)
+		p.buf.WriteString(",URI=\"")
+		p.buf.WriteString(key.URI)
+		p.buf.WriteRune('"')
+		if key.IV != "" {
+			p.buf.WriteString(",IV=")
+			p.buf.WriteString(key.IV)
+		}
+		if key.Keyformat != "" {
+			p.buf.WriteString(",KEYFORMAT=\"")
+			p.buf.WriteString(key.Keyformat)
+			p.buf.WriteRune('"')
+		}
+		if key.Keyformatversions != "" {
+			p.buf.WriteString(",KEYFORMATVERSIONS=\"")
+			p.buf.WriteString(key.Keyformatversions)
+			p.buf.WriteRune('"')
+		}
+		p.buf.WriteRune('\n')
+	}
+
 	// Write any custom master tags
 	if p.Custom != nil {
 		for _, v := range p.Custom {
@@ -403,9 +455,13 @@ func (p *MediaPlaylist) Encode() *bytes.Buffer {
 		return &p.buf
 	}
 
+	if len(p.Defines) > 0 {
+		version(&p.ver, 8) // due section 4.4.2.3
+	}
 	p.buf.WriteString("#EXTM3U\n#EXT-X-VERSION:")
 	p.buf.WriteString(strver(p.ver))
 	p.buf.WriteRune('\n')
+	writeDefines(&p.buf, p.Defines)
 
 	// Write any custom master tags
 	if p.Custom != nil {
@@ -472,6 +528,32 @@ func (p *MediaPlaylist) Encode() *bytes.Buffer {
 	p.buf.WriteString("#EXT-X-TARGETDURATION:")
 	p.buf.WriteString(strconv.FormatInt(int64(math.Ceil(p.TargetDuration)), 10)) // due section 3.4.2 of M3U8 specs EXT-X-TARGETDURATION must be integer
 	p.buf.WriteRune('\n')
+	if sc := p.ServerControl; sc != nil {
+		var attrs []string
+		if sc.CanSkipUntil > 0 {
+			attrs = append(attrs, "CAN-SKIP-UNTIL="+strconv.FormatFloat(sc.CanSkipUntil, 'f', -1, 64))
+			if sc.CanSkipDateRanges {
+				attrs = append(attrs, "CAN-SKIP-DATERANGES=YES")
+			}
+		}
+		if sc.HoldBack > 0 {
+			attrs = append(attrs, "HOLD-BACK="+strconv.FormatFloat(sc.HoldBack, 'f', -1, 64))
+		}
+		if sc.PartHoldBack > 0 {
+			attrs = append(attrs, "PART-HOLD-BACK="+strconv.FormatFloat(sc.PartHoldBack, 'f', -1, 64))
+		}
+		if sc.CanBlockReload {
+			attrs = append(attrs, "CAN-BLOCK-RELOAD=YES")
+		}
+		p.buf.WriteString("#EXT-X-SERVER-CONTROL:")
+		p.buf.WriteString(strings.Join(attrs, ","))
+		p.buf.WriteRune('\n')
+	}
+	if p.PartTarget > 0 {
+		p.buf.WriteString("#EXT-X-PART-INF:PART-TARGET=")
+		p.buf.WriteString(strconv.FormatFloat(p.PartTarget, 'f', -1, 64))
+		p.buf.WriteRune('\n')
+	}
 	if p.StartTime > 0.0 {
 		p.buf.WriteString("#EXT-X-START:TIME-OFFSET=")
 		p.buf.WriteString(strconv.FormatFloat(p.StartTime, 'f', -1, 64))
@@ -557,8 +639,20 @@ func (p *MediaPlaylist) Encode() *bytes.Buffer {
 		}
 	}
 
+	if p.Skip != nil {
+		p.buf.WriteString("#EXT-X-SKIP:SKIPPED-SEGMENTS=")
+		p.buf.WriteString(strconv.FormatUint(p.Skip.SkippedSegments, 10))
+		if len(p.Skip.RecentlyRemovedDateRanges) > 0 {
+			p.buf.WriteString(",RECENTLY-REMOVED-DATERANGES=\"")
+			p.buf.WriteString(strings.Join(p.Skip.RecentlyRemovedDateRanges, "\t"))
+			p.buf.WriteRune('"')
+		}
+		p.buf.WriteRune('\n')
+	}
+
 	var (
 		seg           *MediaSegment
+		bitrate       int64
 		durationCache = make(map[float64]string)
 	)
 
@@ -593,9 +687,11 @@ func (p *MediaPlaylist) Encode() *bytes.Buffer {
 			case SCTE35_OATCLS:
 				switch seg.SCTE.CueType {
 				case SCTE35Cue_Start:
-					p.buf.WriteString("#EXT-OATCLS-SCTE35:")
-					p.buf.WriteString(seg.SCTE.Cue)
-					p.buf.WriteRune('\n')
+					if seg.SCTE.Cue != "" {
+						p.buf.WriteString("#EXT-OATCLS-SCTE35:")
+						p.buf.WriteString(seg.SCTE.Cue)
+						p.buf.WriteRune('\n')
+					}
 					p.buf.WriteString("#EXT-X-CUE-OUT:")
 					p.buf.WriteString(strconv.FormatFloat(seg.SCTE.Time, 'f', -1, 64))
 					p.buf.WriteRune('\n')
@@ -662,6 +758,18 @@ func (p *MediaPlaylist) Encode() *bytes.Buffer {
 			p.buf.WriteString(seg.ProgramDateTime.Format(DATETIME))
 			p.buf.WriteRune('\n')
 		}
+		for _, dr := range seg.DateRanges {
+			writeDateRange(&p.buf, dr)
+		}
+		if seg.Bitrate > 0 && seg.Bitrate != bitrate {
+			p.buf.WriteString("#EXT-X-BITRATE:")
+			p.buf.WriteString(strconv.FormatInt(seg.Bitrate, 10))
+			p.buf.WriteRune('\n')
+			bitrate = seg.Bitrate
+		}
+		if seg.Gap {
+			p.buf.WriteString("#EXT-X-GAP\n")
+		}
 		if seg.Limit > 0 {
 			p.buf.WriteString("#EXT-X-BYTERANGE:")
 			p.buf.WriteString(strconv.FormatInt(seg.Limit, 10))
@@ -680,6 +788,10 @@ func (p *MediaPlaylist) Encode() *bytes.Buffer {
 			}
 		}
 
+		for _, part := range seg.Parts {
+			writePart(&p.buf, part)
+		}
+
 		p.buf.WriteString("#EXTINF:")
 		if str, ok := durationCache[seg.Duration]; ok {
 			p.buf.WriteString(str)
@@ -703,12 +815,146 @@ func (p *MediaPlaylist) Encode() *bytes.Buffer {
 		}
 		p.buf.WriteRune('\n')
 	}
+	for _, dr := range p.DateRanges {
+		writeDateRange(&p.buf, dr)
+	}
+	for _, part := range p.Parts {
+		writePart(&p.buf, part)
+	}
+	for _, hint := range p.PreloadHints {
+		p.buf.WriteString("#EXT-X-PRELOAD-HINT:TYPE=")
+		p.buf.WriteString(hint.Type)
+		p.buf.WriteString(",URI=\"")
+		p.buf.WriteString(hint.URI)
+		p.buf.WriteRune('"')
+		if hint.Start > 0 {
+			p.buf.WriteString(",BYTERANGE-START=")
+			p.buf.WriteString(strconv.FormatInt(hint.Start, 10))
+		}
+		if hint.Length > 0 {
+			p.buf.WriteString(",BYTERANGE-LENGTH=")
+			p.buf.WriteString(strconv.FormatInt(hint.Length, 10))
+		}
+		p.buf.WriteRune('\n')
+	}
+	for _, report := range p.RenditionReports {
+		p.buf.WriteString("#EXT-X-RENDITION-REPORT:URI=\"")
+		p.buf.WriteString(report.URI)
+		p.buf.WriteString("\",LAST-MSN=")
+		p.buf.WriteString(strconv.FormatUint(report.LastMSN, 10))
+		if report.LastPart >= 0 {
+			p.buf.WriteString(",LAST-PART=")
+			p.buf.WriteString(strconv.FormatInt(report.LastPart, 10))
+		}
+		p.buf.WriteRune('\n')
+	}
 	if p.Closed {
 		p.buf.WriteString("#EXT-X-ENDLIST\n")
 	}
 	return &p.buf
 }
 
+// writeDefines writes the EXT-X-DEFINE tags of defines.
+func writeDefines(buf *bytes.Buffer, defines []*Define) {
+	for _, def := range defines {
+		buf.WriteString("#EXT-X-DEFINE:")
+		switch def.Type {
+		case IMPORT:
+			buf.WriteString("IMPORT=\"")
+			buf.WriteString(def.Name)
+		case QUERYPARAM:
+			buf.WriteString("QUERYPARAM=\"")
+			buf.WriteString(def.Name)
+		default:
+			buf.WriteString("NAME=\"")
+			buf.WriteString(def.Name)
+			buf.WriteString("\",VALUE=\"")
+			buf.WriteString(def.Value)
+		}
+		buf.WriteString("\"\n")
+	}
+}
+
+// writePart writes the EXT-X-PART tag of part.
+func writePart(buf *bytes.Buffer, part *Part) {
+	buf.WriteString("#EXT-X-PART:DURATION=")
+	buf.WriteString(strconv.FormatFloat(part.Duration, 'f', -1, 64))
+	buf.WriteString(",URI=\"")
+	buf.WriteString(part.URI)
+	buf.WriteRune('"')
+	if part.Independent {
+		buf.WriteString(",INDEPENDENT=YES")
+	}
+	if part.Limit > 0 {
+		buf.WriteString(",BYTERANGE=")
+		buf.WriteString(strconv.FormatInt(part.Limit, 10))
+		buf.WriteRune('@')
+		buf.WriteString(strconv.FormatInt(part.Offset, 10))
+	}
+	if part.Gap {
+		buf.WriteString(",GAP=YES")
+	}
+	buf.WriteRune('\n')
+}
+
+// writeDateRange writes the EXT-X-DATERANGE tag of dr, with its client
+// attributes sorted by name.
+func writeDateRange(buf *bytes.Buffer, dr *DateRange) {
+	buf.WriteString("#EXT-X-DATERANGE:ID=\"")
+	buf.WriteString(dr.ID)
+	buf.WriteRune('"')
+	if dr.Class != "" {
+		buf.WriteString(",CLASS=\"")
+		buf.WriteString(dr.Class)
+		buf.WriteRune('"')
+	}
+	if !dr.StartDate.IsZero() {
+		buf.WriteString(",START-DATE=\"")
+		buf.WriteString(dr.StartDate.Format(DATETIME))
+		buf.WriteRune('"')
+	}
+	if !dr.EndDate.IsZero() {
+		buf.WriteString(",END-DATE=\"")
+		buf.WriteString(dr.EndDate.Format(DATETIME))
+		buf.WriteRune('"')
+	}
+	if dr.Duration > 0 {
+		buf.WriteString(",DURATION=")
+		buf.WriteString(strconv.FormatFloat(dr.Duration, 'f', -1, 64))
+	}
+	if dr.PlannedDuration > 0 {
+		buf.WriteString(",PLANNED-DURATION=")
+		buf.WriteString(strconv.FormatFloat(dr.PlannedDuration, 'f', -1, 64))
+	}
+	names := make([]string, 0, len(dr.X))
+	for name := range dr.X {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		buf.WriteRune(',')
+		buf.WriteString(name)
+		buf.WriteRune('=')
+		buf.WriteString(dr.X[name])
+	}
+	if dr.SCTE35Cmd != "" {
+		buf.WriteString(",SCTE35-CMD=")
+		buf.WriteString(dr.SCTE35Cmd)
+	}
+	if dr.SCTE35Out != "" {
+		buf.WriteString(",SCTE35-OUT=")
+		buf.WriteString(dr.SCTE35Out)
+	}
+	if dr.SCTE35In != "" {
+		buf.WriteString(",SCTE35-IN=")
+		buf.WriteString(dr.SCTE35In)
+	}
+	if dr.EndOnNext {
+		buf.WriteString(",END-ON-NEXT=YES")
+	}
+	buf.WriteRune('\n')
+}
+
 // String here for compatibility with Stringer interface For example
 // log.Printf("%s", sampleMediaList) will encode playist and print its
 // string representation.
@@ -826,6 +1072,17 @@ func (p *MediaPlaylist) SetSCTE35(scte35 *SCTE) error {
 	return nil
 }
 
+// AppendDateRange adds an EXT-X-DATERANGE tag before the current media
+// segment.
+func (p *MediaPlaylist) AppendDateRange(dr *DateRange) error {
+	if p.count == 0 {
+		return errors.New("playlist is empty")
+	}
+	seg := p.Segments[p.last()]
+	seg.DateRanges = append(seg.DateRanges, dr)
+	return nil
+}
+
 // SetDiscontinuity sets discontinuity flag for the current media
 // segment. EXT-X-DISCONTINUITY indicates an encoding discontinuity
 // between the media segment that follows it and the one that preceded
@@ -839,6 +1096,27 @@ func (p *MediaPlaylist) SetDiscontinuity() error {
 	return nil
 }
 
+// SetGap marks the current media segment as missing, it must not be
+// loaded by clients. Realizes EXT-X-GAP.
+func (p *MediaPlaylist) SetGap() error {
+	if p.count == 0 {
+		return errors.New("playlist is empty")
+	}
+	p.Segments[p.last()].Gap = true
+	return nil
+}
+
+// SetBitrate sets the approximate bitrate, in kbit/s, of the current media
+// segment. The writer emits EXT-X-BITRATE when it changes from the
+// previous segment.
+func (p *MediaPlaylist) SetBitrate(kbps int64) error {
+	if p.count == 0 {
+		return errors.New("playlist is empty")
+	}
+	p.Segments[p.last()].Bitrate = kbps
+	return nil
+}
+
 // SetProgramDateTime sets program date and time for the current media
 // segment. EXT-X-PROGRAM-DATE-TIME tag associates the first sample of
 // a media segment with an absolute date and/or time. It applies only
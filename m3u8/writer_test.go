@@ -12,10 +12,12 @@ package m3u8
 import (
 	"bufio"
 	"bytes"
+	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"reflect"
 	"strings"
 	"sync"
@@ -23,6 +25,8 @@ import (
 	"time"
 )
 
+var update = flag.Bool("update", false, "update the golden files of testdata")
+
 // Check how master and media playlists implement common Playlist interface
 func TestInterfaceImplemented(t *testing.T) {
 	m := NewMasterPlaylist()
@@ -161,6 +165,225 @@ func TestProgramDateTimeForMediaPlaylist(t *testing.T) {
 	// log.Println(p.Encode().String())
 }
 
+// Create new media playlist
+// Add date ranges before the segments and check they are encoded
+func TestAppendDateRangeForMediaPlaylist(t *testing.T) {
+	p, e := NewMediaPlaylist(3, 4)
+	if e != nil {
+		t.Fatalf("Create media playlist failed: %s", e)
+	}
+	if e = p.AppendDateRange(&DateRange{ID: "ad1"}); e == nil {
+		t.Error("Date range added to an empty playlist")
+	}
+	if e = p.Append("test01.ts", 5.0, ""); e != nil {
+		t.Errorf("Add 1st segment to a media playlist failed: %s", e)
+	}
+	start := time.Date(2026, time.October, 18, 10, 0, 0, 0, time.UTC)
+	if e = p.AppendDateRange(&DateRange{
+		ID:              "ad1",
+		Class:           "com.example.ad",
+		StartDate:       start,
+		PlannedDuration: 30,
+		SCTE35Out:       "0xFC30",
+		X:               map[string]string{"X-B": "2", "X-A": `"one"`},
+	}); e != nil {
+		t.Errorf("Add date range failed: %s", e)
+	}
+	if e = p.AppendDateRange(&DateRange{ID: "ad1", StartDate: start, EndDate: start.Add(30 * time.Second), Duration: 30.5}); e != nil {
+		t.Errorf("Add 2nd date range failed: %s", e)
+	}
+	expected := `#EXT-X-DATERANGE:ID="ad1",CLASS="com.example.ad",START-DATE="2026-10-18T10:00:00Z",PLANNED-DURATION=30,X-A="one",X-B=2,SCTE35-OUT=0xFC30
+#EXT-X-DATERANGE:ID="ad1",START-DATE="2026-10-18T10:00:00Z",END-DATE="2026-10-18T10:00:30Z",DURATION=30.5
+#EXTINF:5.000,
+test01.ts
+`
+	if !strings.Contains(p.String(), expected) {
+		t.Errorf("Date ranges not encoded as expected:\n%s", p)
+	}
+}
+
+// Decode a playlist with date ranges, encode it again and compare
+func TestDateRangeRoundTrip(t *testing.T) {
+	playlist := `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-TARGETDURATION:10
+#EXT-X-PROGRAM-DATE-TIME:2026-10-18T10:00:00Z
+#EXT-X-DATERANGE:ID="splice-1",START-DATE="2026-10-18T10:00:00Z",PLANNED-DURATION=20,SCTE35-OUT=0xFC302F
+#EXT-X-DATERANGE:ID="chapter-1",CLASS="com.example.chapter",START-DATE="2026-10-18T10:00:00.25Z",X-COM-EXAMPLE-ID=0x1F,X-COM-EXAMPLE-TITLE="Intro, part 1",END-ON-NEXT=YES
+#EXTINF:10.000,
+a.ts
+#EXTINF:10.000,
+b.ts
+#EXT-X-DATERANGE:ID="splice-1",START-DATE="2026-10-18T10:00:00Z",END-DATE="2026-10-18T10:00:20Z",DURATION=20,SCTE35-CMD=0xFC3011,SCTE35-IN=0xFC302A
+#EXT-X-ENDLIST
+`
+	p, _, err := DecodeFrom(bytes.NewBufferString(playlist), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded := p.String()
+	if encoded != playlist {
+		t.Fatalf("Encoded playlist differs from the decoded one:\n%s\nwant:\n%s", encoded, playlist)
+	}
+	again, _, err := DecodeFrom(bytes.NewBufferString(encoded), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, second := p.(*MediaPlaylist), again.(*MediaPlaylist)
+	if !reflect.DeepEqual(first.Segments[0].DateRanges, second.Segments[0].DateRanges) ||
+		!reflect.DeepEqual(first.DateRanges, second.DateRanges) {
+		t.Error("Date ranges changed through a round trip")
+	}
+}
+
+// Decode the Low-Latency HLS playlists of testdata and compare their
+// encoding with the golden files. Run with -update to rewrite them.
+func TestEncodeLowLatencyGolden(t *testing.T) {
+	for _, name := range []string{"ll-hls", "ll-hls-delta"} {
+		data, err := ioutil.ReadFile(filepath.Join("testdata", name+".m3u8"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, listType, err := DecodeFrom(bytes.NewReader(data), true)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if listType != MEDIA {
+			t.Fatalf("%s: not recognized as media playlist", name)
+		}
+		encoded := p.Encode().Bytes()
+
+		golden := filepath.Join("testdata", name+".golden")
+		if *update {
+			if err := ioutil.WriteFile(golden, encoded, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(encoded, want) {
+			t.Errorf("%s: encoded playlist differs from %s:\n%s\nwant:\n%s", name, golden, encoded, want)
+		}
+
+		// the golden file decodes to the same playlist
+		again, _, err := DecodeFrom(bytes.NewReader(want), true)
+		if err != nil {
+			t.Fatalf("%s: %s", golden, err)
+		}
+		first, second := p.(*MediaPlaylist), again.(*MediaPlaylist)
+		if !reflect.DeepEqual(first.ServerControl, second.ServerControl) || first.PartTarget != second.PartTarget ||
+			!reflect.DeepEqual(first.Skip, second.Skip) || !reflect.DeepEqual(first.Parts, second.Parts) ||
+			!reflect.DeepEqual(first.PreloadHints, second.PreloadHints) ||
+			!reflect.DeepEqual(first.RenditionReports, second.RenditionReports) {
+			t.Errorf("%s: Low-Latency HLS tags changed through a round trip", name)
+		}
+		for i := uint(0); i < first.Count(); i++ {
+			if !reflect.DeepEqual(first.Segments[i].Parts, second.Segments[i].Parts) {
+				t.Errorf("%s: parts of segment %d changed through a round trip", name, i)
+			}
+		}
+	}
+}
+
+func TestEncodeDefines(t *testing.T) {
+	master := NewMasterPlaylist()
+	master.Defines = []*Define{
+		{Name: "cdn", Type: VALUE, Value: "https://cdn.example.com"},
+		{Name: "token", Type: QUERYPARAM, Value: "abc"},
+	}
+	master.Append("https://cdn.example.com/720p.m3u8", nil, VariantParams{Bandwidth: 1000000})
+	want := `#EXTM3U
+#EXT-X-VERSION:8
+#EXT-X-DEFINE:NAME="cdn",VALUE="https://cdn.example.com"
+#EXT-X-DEFINE:QUERYPARAM="token"
+`
+	if out := master.String(); !strings.HasPrefix(out, want) {
+		t.Errorf("Encoded master playlist:\n%s\nwant prefix:\n%s", out, want)
+	}
+
+	media := `#EXTM3U
+#EXT-X-VERSION:8
+#EXT-X-DEFINE:IMPORT="cdn"
+#EXT-X-DEFINE:NAME="path",VALUE="{$cdn}/720p"
+#EXT-X-MEDIA-SEQUENCE:0
+#EXT-X-TARGETDURATION:10
+#EXTINF:10.000,
+{$path}/seg1.ts
+#EXT-X-ENDLIST
+`
+	p, _, err := DecodeWithVariables(bytes.NewBufferString(media), true, Variables{Imports: map[string]string{"cdn": "https://cdn.example.com"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the definitions are kept, the references are written with their values
+	expanded := strings.NewReplacer("{$path}", "https://cdn.example.com/720p", "{$cdn}", "https://cdn.example.com").Replace(media)
+	if out := p.String(); out != expanded {
+		t.Errorf("Encoded media playlist:\n%s\nwant:\n%s", out, expanded)
+	}
+}
+
+func TestEncodeSessionData(t *testing.T) {
+	master := NewMasterPlaylist()
+	master.SessionData = []*SessionData{
+		{DataID: "com.apple.hls.title", Value: "Keynote", Language: "en"},
+		{DataID: "com.example.notes", URI: "notes.txt", Format: "RAW"},
+	}
+	master.SessionKeys = []*Key{{Method: "SAMPLE-AES", URI: "skd://key1", Keyformat: "com.apple.streamingkeydelivery", Keyformatversions: "1"}}
+	master.Append("720p.m3u8", nil, VariantParams{Bandwidth: 1000000})
+	out := master.String()
+	want := `#EXT-X-SESSION-DATA:DATA-ID="com.apple.hls.title",VALUE="Keynote",LANGUAGE="en"
+#EXT-X-SESSION-DATA:DATA-ID="com.example.notes",URI="notes.txt",FORMAT=RAW
+#EXT-X-SESSION-KEY:METHOD=SAMPLE-AES,URI="skd://key1",KEYFORMAT="com.apple.streamingkeydelivery",KEYFORMATVERSIONS="1"
+`
+	if !strings.Contains(out, want) {
+		t.Errorf("Encoded master playlist:\n%s\nwant:\n%s", out, want)
+	}
+
+	decoded := NewMasterPlaylist()
+	if err := decoded.DecodeFrom(bytes.NewBufferString(out), true); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.SessionData, master.SessionData) || !reflect.DeepEqual(decoded.SessionKeys, master.SessionKeys) {
+		t.Errorf("Decoded session data %+v and keys %+v, want %+v and %+v", decoded.SessionData, decoded.SessionKeys, master.SessionData, master.SessionKeys)
+	}
+}
+
+func TestEncodeGapAndBitrate(t *testing.T) {
+	p, err := NewMediaPlaylist(0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, bitrate := range []int64{1200, 1200, 800} {
+		if err := p.Append(fmt.Sprintf("seg%d.ts", i), 4, ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.SetBitrate(bitrate); err != nil {
+			t.Fatal(err)
+		}
+		if i == 1 {
+			if err := p.SetGap(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	want := `#EXT-X-BITRATE:1200
+#EXTINF:4.000,
+seg0.ts
+#EXT-X-GAP
+#EXTINF:4.000,
+seg1.ts
+#EXT-X-BITRATE:800
+#EXTINF:4.000,
+seg2.ts
+`
+	if out := p.String(); !strings.HasSuffix(out, want) {
+		t.Errorf("Encoded media playlist:\n%s\nwant suffix:\n%s", out, want)
+	}
+}
+
 // Create new media playlist
 // Add two segments to media playlist with duration 9.0 and 9.1.
 // Target duration must be set to nearest greater integer (= 10).
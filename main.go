@@ -1,244 +1,178 @@
 package main
 
 import (
-	"bufio"
-	"context"
 	"flag"
 	"fmt"
-	"log"
-	"net/url"
+	"io"
 	"os"
 	"os/exec"
-	"os/signal"
-	"runtime"
-	"sort"
 	"strings"
-	"syscall"
-	"time"
 
-	"github.com/grafov/m3u8"
-	"github.com/schollz/progressbar/v3"
+	"hls_downloader/downloader"
 )
 
-var (
-	u, out              string
-	numberOfWorkers     int
-	overrideCurrentFile bool
-	alwaysHightest      bool
-	verbose             bool
-)
-
-func main() {
-	flag.StringVar(&u, "url", "", "Master playlist direct url (required)")
-	flag.StringVar(&out, "o", "", "Output file (mp4 format) (default: timestamp.mp4)")
-	flag.IntVar(&numberOfWorkers, "p", 0, "Number of workers, if 0, number of CPU cores will be used")
-	flag.BoolVar(&overrideCurrentFile, "f", false, "Override output file if exists")
-	flag.BoolVar(&alwaysHightest, "h", true, "Always select highest bitrate variant")
-	flag.BoolVar(&verbose, "v", false, "Verbose mode")
-	flag.Parse()
-
-	if u == "" {
-		flag.Usage()
-		return
-	}
+// version is set at build time with -ldflags "-X main.version=<version>".
+var version = "dev"
 
-	// ensure ffmpeg is installed and runnable
-	if _, err := exec.LookPath("ffmpeg"); err != nil {
-		fmt.Fprintln(os.Stderr, "ffmpeg not found in PATH. Please install ffmpeg: https://ffmpeg.org/download.html")
-		os.Exit(1)
-	}
-	// sanity-check ffmpeg can be executed
-	if out, err := exec.Command("ffmpeg", "-version").CombinedOutput(); err != nil {
-		fmt.Fprintln(os.Stderr, "ffmpeg was found but failed to run:", err)
-		fmt.Fprintln(os.Stderr, "ffmpeg output:")
-		fmt.Fprintln(os.Stderr, string(out))
-		os.Exit(1)
-	}
+// command is a subcommand of the CLI.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string)
+}
 
-	uri, err := url.Parse(u)
-	if err != nil {
-		log.Panicln(err)
+// commands are set in init as they refer to newFlagSet, which refers to
+// them for the usage.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"download", "Download a stream into a mp4 file (the default command)", download},
+		{"info", "Print the variants and segments of a playlist", info},
+		{"mirror", "Copy a stream with its playlists into a directory", mirror},
+		{"verify", "Check a downloaded file against its playlist", verify},
+		{"keys", "List and save the encryption keys of a stream", keys},
+		{"lint", "Check the playlists of a stream against the HLS specification", lint},
+		{"thumbnails", "Make thumbnails, sprite sheets and a WebVTT track from the I-frames of a stream", thumbnails},
+		{"serve", "Run the downloader as a service with a job queue API", serve},
 	}
+}
 
-	if out == "" {
-		now := time.Now()
-		out = now.Format("20060102_150405") + ".mp4"
+func main() {
+	args := os.Args[1:]
+	if len(args) == 0 {
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		usage(os.Stdout)
+		return
+	case "version", "-version", "--version":
+		fmt.Println("hls_downloader", version)
+		return
 	}
-
-	// validate the output file name
-	if _, err := os.Stat(out); err == nil && !overrideCurrentFile {
-		log.Panicln("Output file already exists, use -f to override")
+	if strings.HasPrefix(args[0], "-") {
+		// hls_downloader -url <url> predates the subcommands
+		download(args)
+		return
 	}
-	if !strings.HasSuffix(out, ".mp4") {
-		log.Panicln("Output file must be a mp4 file")
+	for _, cmd := range commands {
+		if cmd.name == args[0] {
+			cmd.run(args[1:])
+			return
+		}
 	}
+	fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", args[0])
+	usage(os.Stderr)
+	os.Exit(2)
+}
 
-	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, syscall.SIGINT)
-	defer signal.Stop(signals)
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	tmpDir, err := os.MkdirTemp("", "hls_downloader")
-	if err != nil {
-		log.Panicln(err)
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: hls_downloader <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(w, "  %-10s %s\n", cmd.name, cmd.summary)
 	}
-	defer os.RemoveAll(tmpDir)
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Run 'hls_downloader <command> -h' for the flags of a command.")
+}
 
-	if verbose {
-		log.Println("Created temporary directory:", tmpDir)
+// newFlagSet creates the flag set of a command, its usage line shows the
+// arguments in argsUsage.
+func newFlagSet(name, argsUsage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		for _, cmd := range commands {
+			if cmd.name == name {
+				fmt.Fprintf(fs.Output(), "%s.\n\n", cmd.summary)
+			}
+		}
+		fmt.Fprintf(fs.Output(), "Usage: hls_downloader %s %s\n\nFlags:\n", name, argsUsage)
+		fs.PrintDefaults()
 	}
+	return fs
+}
 
-	go func() {
-		<-signals
-		cancel()
-		os.RemoveAll(tmpDir)
-		os.Exit(1)
-	}()
-
-	log.Println("Fetching playlist...")
-
-	data, err := Get(ctx, uri)
-	if err != nil {
-		log.Panicln(err)
+// playlistURL returns the -url flag or the first argument of fs, and exits
+// with the usage when neither is given.
+func playlistURL(fs *flag.FlagSet, flagValue string) string {
+	if flagValue != "" {
+		return flagValue
 	}
-
-	buf := bufio.NewReader(strings.NewReader(string(data)))
-	p, listType, err := m3u8.DecodeFrom(buf, false)
-	if err != nil {
-		log.Panicln(err)
+	if fs.NArg() > 0 {
+		return fs.Arg(0)
 	}
+	fs.Usage()
+	os.Exit(2)
+	return ""
+}
 
-	var masterpl *m3u8.MasterPlaylist
-
-	switch listType {
-	case m3u8.MEDIA:
-		panic("Master playlist expected, media playlist found")
-	case m3u8.MASTER:
-		masterpl = p.(*m3u8.MasterPlaylist)
+// checkFFmpeg exits when ffmpeg is missing or can't run.
+func checkFFmpeg() {
+	// ensure ffmpeg is installed and runnable
+	if _, err := exec.LookPath("ffmpeg"); err != nil {
+		fmt.Fprintln(os.Stderr, "ffmpeg not found in PATH. Please install ffmpeg: https://ffmpeg.org/download.html")
+		os.Exit(1)
 	}
-
-	variants := masterpl.Variants
-	if len(variants) == 0 {
-		log.Panicln("No variants found in master playlist")
+	// sanity-check ffmpeg can be executed
+	if out, err := exec.Command("ffmpeg", "-version").CombinedOutput(); err != nil {
+		fmt.Fprintln(os.Stderr, "ffmpeg was found but failed to run:", err)
+		fmt.Fprintln(os.Stderr, "ffmpeg output:")
+		fmt.Fprintln(os.Stderr, string(out))
+		os.Exit(1)
 	}
+}
 
-	// sort variants by bandwidth
-	sort.Slice(variants, func(i, j int) bool {
-		return variants[i].VariantParams.Bandwidth > variants[j].VariantParams.Bandwidth
-	})
-
-	log.Println("Available Variants:")
-	for i, variant := range variants {
-		name := variant.VariantParams.Name
-		if name == "" {
-			name = variant.VariantParams.Resolution
-		}
-		if name == "" {
-			name = fmt.Sprintf("%d", variant.VariantParams.Bandwidth)
-		}
-		log.Printf("%d: %s\n", i, name)
-	}
+// promptVariant asks which of the listed variants to download.
+func promptVariant(variants []*downloader.Variant) (int, error) {
 	var variantId int
-
-	if alwaysHightest {
-		variantId = 0
-		log.Printf("Automatically selected highest bitrate variant: %d\n", variantId)
-	} else {
-		fmt.Print("Select variant: ")
-		fmt.Scanln(&variantId)
-		if variantId < 0 || variantId >= len(variants) {
-			panic("Invalid variant id")
-		}
+	fmt.Print("Select variant: ")
+	if _, err := fmt.Scanln(&variantId); err != nil {
+		return 0, fmt.Errorf("invalid variant id: %w", err)
 	}
+	return variantId, nil
+}
 
-	variant := variants[variantId]
+// mirrorList collects the hosts passed with repeated (or comma separated)
+// -mirror flags.
+type mirrorList []string
 
-	vUrl := concatUrl(uri, variant.URI)
-	if verbose {
-		log.Println("Fetching variant playlist:", vUrl)
-	}
-
-	vPlaylistD, err := Get(ctx, vUrl)
-	if err != nil {
-		log.Panicln(err)
-	}
-	vPlaylistBuf := bufio.NewReader(strings.NewReader(string(vPlaylistD)))
-	p, _, err = m3u8.DecodeFrom(vPlaylistBuf, false)
-	if err != nil {
-		log.Panicln(err)
-	}
+func (m *mirrorList) String() string {
+	return strings.Join(*m, ",")
+}
 
-	mediapl := p.(*m3u8.MediaPlaylist)
-	segments := []*m3u8.MediaSegment{}
-	for _, segment := range mediapl.Segments {
-		if segment == nil {
-			break
+func (m *mirrorList) Set(value string) error {
+	for _, host := range strings.Split(value, ",") {
+		host = strings.TrimSpace(host)
+		if host == "" {
+			continue
 		}
-		segments = append(segments, segment)
-	}
-
-	listF, err := os.CreateTemp(tmpDir, "list")
-	if err != nil {
-		log.Panicln(err)
+		*m = append(*m, host)
 	}
-	defer listF.Close()
-
-	// bar := progressbar.Default(int64(len(segments)), "Downloading segments")
-	bar := progressbar.NewOptions64(
-		int64(len(segments)),
-		progressbar.OptionSetDescription("Downloading segments"),
-		progressbar.OptionSetWriter(os.Stderr),
-		progressbar.OptionThrottle(65*time.Millisecond),
-		progressbar.OptionShowCount(),
-		progressbar.OptionClearOnFinish(),
-		progressbar.OptionSetElapsedTime(true),
-		progressbar.OptionSetPredictTime(false),
-		progressbar.OptionFullWidth(),
-	)
+	return nil
+}
 
-	st := time.Now()
-	defer func() {
-		log.Println("Total time:", time.Since(st))
-	}()
+// headerList collects the headers passed with repeated -header flags.
+type headerList map[string]string
 
-	nWorkers := len(segments) / runtime.NumCPU()
-	if nWorkers == 0 {
-		nWorkers = 1
-	}
-	if numberOfWorkers > 0 {
-		nWorkers = numberOfWorkers
-	}
-	if verbose {
-		log.Printf("Number of workers: %d\n", nWorkers)
+func (h *headerList) String() string {
+	var pairs []string
+	for name, value := range *h {
+		pairs = append(pairs, name+": "+value)
 	}
+	return strings.Join(pairs, ", ")
+}
 
-	dInput := &downloadInput{
-		playlistKey:  mediapl.Key,
-		variantUrl:   vUrl,
-		segments:     segments,
-		tmpDir:       tmpDir,
-		listFile:     listF,
-		progressBar:  bar,
-		numOfWorkers: nWorkers,
+func (h *headerList) Set(value string) error {
+	name, v, ok := strings.Cut(value, ":")
+	if !ok || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("invalid header %q, expected 'Name: value'", value)
 	}
-	if err := downloadSegments(ctx, dInput); err != nil {
-		log.Panicln(err)
+	if *h == nil {
+		*h = make(headerList)
 	}
-
-	log.Println("Stitching segments...")
-
-	// concat segments using ffmpeg
-	rawArgs := fmt.Sprintf("-v error -y -f concat -safe 0 -i %s -c copy %s", listF.Name(), out)
-	output, err := exec.CommandContext(
-		ctx,
-		"ffmpeg",
-		strings.Split(rawArgs, " ")...).CombinedOutput()
-	if err != nil {
-		log.Println("FFmpeg Error:", string(output))
-		log.Panicln(err)
-	}
-
-	log.Println("Done!, output file:", out)
+	(*h)[strings.TrimSpace(name)] = strings.TrimSpace(v)
+	return nil
 }
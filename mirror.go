@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+
+	"hls_downloader/downloader"
+)
+
+// mirror copies a stream with its playlists into a directory.
+func mirror(args []string) {
+	fs := newFlagSet("mirror", "[flags] <url>")
+	var (
+		client       clientFlags
+		u, dir       string
+		workers      int
+		variantRules string
+		retries      int
+	)
+	client.register(fs)
+	fs.StringVar(&u, "url", "", "Master or media playlist url, may be given as argument")
+	fs.StringVar(&dir, "o", "mirror", "Directory the stream is copied to")
+	fs.IntVar(&workers, "p", 4, "Number of files downloaded at once")
+	fs.StringVar(&variantRules, "variant", "", "Copy only the first matching variant and its renditions: highest, lowest, index, 1280x720, 720p or name (comma separated) (default: every variant)")
+	fs.IntVar(&retries, "retries", -1, "Retries of a failed request, 0 doesn't retry and -1 retries until the retry timeout")
+	fs.Parse(args)
+	u = playlistURL(fs, u)
+
+	cfg, err := client.load(fs)
+	if err != nil {
+		log.Panicln(err)
+	}
+	// the variant rules of the config are meant for downloads
+	cfg.Variant = nil
+	cfg.Workers = workers
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "variant":
+			cfg.Variant = splitList(variantRules)
+		case "retries":
+			cfg.Retry.MaxRetries = retries
+		}
+	})
+	opts, err := cfg.options()
+	if err != nil {
+		log.Panicln(err)
+	}
+	opts.Logger = log.Default()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	res, err := downloader.New(opts).Mirror(ctx, u, dir)
+	if err != nil {
+		log.Panicln(err)
+	}
+	log.Printf("Done!, %d files (%s), playlist: %s\n", res.Downloaded, downloader.HumanBytes(res.Bytes), res.Output)
+}
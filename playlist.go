@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/url"
+
+	"github.com/grafov/m3u8"
+
+	"hls_downloader/downloader"
+)
+
+// mediaPlaylist is the media playlist of a stream, with the variant it
+// was picked from when the URL is a master playlist.
+type mediaPlaylist struct {
+	*m3u8.MediaPlaylist
+	URL     *url.URL
+	Variant *downloader.Variant
+}
+
+// fetchMedia fetches the playlist at rawUrl, following the variant picked
+// by opts.SelectVariant (the highest by default) of a master playlist.
+func fetchMedia(ctx context.Context, opts downloader.Options, rawUrl string) (*mediaPlaylist, error) {
+	uri, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	d := downloader.New(opts)
+	p, listType, err := d.FetchPlaylist(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	if listType == m3u8.MEDIA {
+		return &mediaPlaylist{MediaPlaylist: p.(*m3u8.MediaPlaylist), URL: uri}, nil
+	}
+	variants := downloader.Variants(p.(*m3u8.MasterPlaylist))
+	if len(variants) == 0 {
+		return nil, downloader.ErrNoVariants
+	}
+	i := 0
+	if opts.SelectVariant != nil {
+		if i, err = opts.SelectVariant(variants); err != nil {
+			return nil, err
+		}
+		if i < 0 || i >= len(variants) {
+			return nil, errors.New("invalid variant id")
+		}
+	}
+	vUrl := downloader.ResolveURL(uri, variants[i].URI)
+	mediapl, err := d.FetchMediaPlaylist(ctx, vUrl, p.(*m3u8.MasterPlaylist))
+	if err != nil {
+		return nil, err
+	}
+	return &mediaPlaylist{MediaPlaylist: mediapl, URL: vUrl, Variant: variants[i]}, nil
+}
+
+// segments returns the segments of the playlist, without the nil padding
+// of the m3u8 buffer.
+func (p *mediaPlaylist) segments() []*m3u8.MediaSegment {
+	var segments []*m3u8.MediaSegment
+	for _, s := range p.Segments {
+		if s == nil {
+			break
+		}
+		segments = append(segments, s)
+	}
+	return segments
+}
+
+// duration returns the sum of the segment durations in seconds.
+func (p *mediaPlaylist) duration() float64 {
+	total := 0.0
+	for _, s := range p.segments() {
+		total += s.Duration
+	}
+	return total
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"hls_downloader/downloader"
+
+	"github.com/schollz/progressbar/v3"
+	"golang.org/x/term"
+)
+
+const (
+	progressBar  = "bar"
+	progressJSON = "json"
+)
+
+// jsonProgress writes the download events to stdout as newline-delimited
+// JSON, followed by a summary line.
+type jsonProgress struct {
+	enc *json.Encoder
+}
+
+// summary is the last line of the -progress json output.
+type summary struct {
+	Event  string    `json:"event"`
+	Time   time.Time `json:"time"`
+	Status string    `json:"status"`
+	*downloader.Result
+	Duration float64 `json:"duration"` // seconds
+	Error    string  `json:"error,omitempty"`
+}
+
+func newJSONProgress() *jsonProgress {
+	return &jsonProgress{enc: json.NewEncoder(os.Stdout)}
+}
+
+func (p *jsonProgress) onEvent(ev downloader.Event) {
+	p.enc.Encode(ev)
+}
+
+// summary writes the final line, err is nil on success.
+func (p *jsonProgress) summary(res *downloader.Result, err error) {
+	s := summary{
+		Event:    "summary",
+		Time:     time.Now(),
+		Status:   "ok",
+		Result:   res,
+		Duration: res.Duration.Seconds(),
+	}
+	if err != nil {
+		s.Status = "failed"
+		s.Error = err.Error()
+	}
+	p.enc.Encode(s)
+}
+
+// progressInterval is how often a progress line is printed when the
+// progress bar can't be drawn.
+const progressInterval = 5 * time.Second
+
+// progressPrinter renders the byte progress of the download on stderr,
+// as a bar with speed and ETA on terminals or as periodic log lines
+// otherwise (and in verbose mode, where the logs would break the bar).
+type progressPrinter struct {
+	mu                  sync.Mutex
+	lines               bool
+	bar                 *progressbar.ProgressBar
+	start               time.Time
+	done, total, reused int64
+	stop                chan struct{}
+	stopOnce            sync.Once
+	wg                  sync.WaitGroup
+}
+
+func newProgressPrinter(verbose bool) *progressPrinter {
+	return &progressPrinter{
+		lines: verbose || !term.IsTerminal(int(os.Stderr.Fd())),
+		stop:  make(chan struct{}),
+	}
+}
+
+func (p *progressPrinter) onEvent(ev downloader.Event) {
+	switch ev.Type {
+	case downloader.EventProgress:
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.start.IsZero() {
+			p.begin(ev.Total - ev.Reused)
+		}
+		p.done, p.total, p.reused = ev.Bytes, ev.Total, ev.Reused
+		// the bar measures its speed from its own counter, the segments
+		// of an earlier attempt are left out of it
+		if p.bar != nil {
+			if p.total > 0 {
+				p.bar.ChangeMax64(p.total - p.reused)
+			}
+			p.bar.Set64(p.done - p.reused)
+		}
+	case downloader.EventStitchingStarted:
+		p.close()
+	}
+}
+
+// begin starts the rendering with the first progress event. The caller
+// must hold p.mu.
+func (p *progressPrinter) begin(total int64) {
+	p.start = time.Now()
+	if p.lines {
+		p.wg.Add(1)
+		go p.printLines()
+		return
+	}
+	if total == 0 {
+		total = -1 // unknown until the first Content-Length arrives
+	}
+	p.bar = progressbar.NewOptions64(
+		total,
+		progressbar.OptionSetDescription("Downloading segments"),
+		progressbar.OptionSetWriter(os.Stderr),
+		progressbar.OptionThrottle(65*time.Millisecond),
+		progressbar.OptionShowBytes(true),
+		progressbar.OptionShowCount(),
+		progressbar.OptionClearOnFinish(),
+		progressbar.OptionSetElapsedTime(true),
+		progressbar.OptionSetPredictTime(true),
+		progressbar.OptionFullWidth(),
+	)
+}
+
+func (p *progressPrinter) printLines() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stop:
+			log.Println(p.String())
+			return
+		case <-ticker.C:
+			log.Println(p.String())
+		}
+	}
+}
+
+// String formats the progress as a single line with speed and ETA.
+func (p *progressPrinter) String() string {
+	p.mu.Lock()
+	done, total, reused := p.done, p.total, p.reused
+	p.mu.Unlock()
+	return progressLine(done, total, reused, time.Since(p.start))
+}
+
+// progressLine formats done bytes of total after elapsed. The speed, and
+// the ETA from it, only count the bytes transferred since the start, not
+// the reused ones of segments found in the work directory.
+func progressLine(done, total, reused int64, elapsed time.Duration) string {
+	var speed float64
+	if elapsed > 0 {
+		speed = float64(done-reused) / elapsed.Seconds()
+	}
+	line := fmt.Sprintf("Downloaded %s", downloader.HumanBytes(done))
+	if total > 0 {
+		line += fmt.Sprintf(" / ~%s (%d%%)", downloader.HumanBytes(total), done*100/total)
+	}
+	line += fmt.Sprintf(", %s/s", downloader.HumanBytes(int64(speed)))
+	if speed > 0 && total > done {
+		eta := time.Duration(float64(total-done) / speed * float64(time.Second))
+		line += fmt.Sprintf(", ETA %s", eta.Round(time.Second))
+	}
+	return line
+}
+
+// close stops the periodic lines and clears the progress bar, it may be
+// called more than once, concurrently.
+func (p *progressPrinter) close() {
+	p.stopOnce.Do(func() {
+		close(p.stop)
+		p.wg.Wait()
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.bar != nil {
+			p.bar.Finish()
+		}
+	})
+}
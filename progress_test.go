@@ -0,0 +1,61 @@
+package main
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProgressLine(t *testing.T) {
+	const mib = 1 << 20
+	for _, tc := range []struct {
+		name                string
+		done, total, reused int64
+		elapsed             time.Duration
+		want                string
+	}{
+		{
+			name: "speed and ETA", done: 10 * mib, total: 40 * mib, elapsed: 10 * time.Second,
+			want: "Downloaded 10.0 MiB / ~40.0 MiB (25%), 1.0 MiB/s, ETA 30s",
+		},
+		{
+			// 30 MiB were in the work directory, only 10 MiB are transferred
+			name: "resumed", done: 40 * mib, total: 80 * mib, reused: 30 * mib, elapsed: 10 * time.Second,
+			want: "Downloaded 40.0 MiB / ~80.0 MiB (50%), 1.0 MiB/s, ETA 40s",
+		},
+		{
+			name: "nothing transferred yet", done: 30 * mib, total: 80 * mib, reused: 30 * mib, elapsed: time.Second,
+			want: "Downloaded 30.0 MiB / ~80.0 MiB (37%), 0 B/s",
+		},
+		{
+			name: "unknown total", done: 2048, elapsed: 2 * time.Second,
+			want: "Downloaded 2.0 KiB, 1.0 KiB/s",
+		},
+		{
+			name: "complete", done: 4 * mib, total: 4 * mib, elapsed: 4 * time.Second,
+			want: "Downloaded 4.0 MiB / ~4.0 MiB (100%), 1.0 MiB/s",
+		},
+		{
+			name: "no time elapsed", done: 1024, total: 2048,
+			want: "Downloaded 1.0 KiB / ~2.0 KiB (50%), 0 B/s",
+		},
+	} {
+		if got := progressLine(tc.done, tc.total, tc.reused, tc.elapsed); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestProgressPrinterClose(t *testing.T) {
+	p := &progressPrinter{lines: true, stop: make(chan struct{})}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.close()
+		}()
+	}
+	wg.Wait()
+	p.close()
+}
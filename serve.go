@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"hls_downloader/server"
+)
+
+// serve runs the downloader as a long-lived service with a job queue API.
+func serve(args []string) {
+	fs := newFlagSet("serve", "[flags]")
+	addr := fs.String("addr", ":8080", "Address to listen on")
+	dir := fs.String("dir", "downloads", "Directory the outputs are written to")
+	maxJobs := fs.Int("jobs", 2, "Number of jobs running at once")
+	slots := fs.Int("p", 16, "Number of segments downloaded at once across all jobs")
+	store := fs.String("store", "", "File the jobs are persisted to, <dir>/jobs.json by default")
+	configFile := fs.String("config", "", "Config file (default: $HLSDL_CONFIG or ~/.config/hls_downloader/config.yaml)")
+	verbose := fs.Bool("v", false, "Verbose mode")
+	fs.Parse(args)
+
+	// the config provides the headers, proxy and retry policy of the
+	// jobs, as for the downloads of the command line
+	cfg, err := loadConfig(*configFile)
+	if err != nil {
+		log.Panicln(err)
+	}
+	cfg.Verbose = cfg.Verbose || *verbose
+	opts, err := cfg.options()
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	if *store == "" {
+		*store = filepath.Join(*dir, "jobs.json")
+	}
+
+	checkFFmpeg()
+
+	srv, err := server.New(server.Config{
+		Dir:     *dir,
+		MaxJobs: *maxJobs,
+		Slots:   *slots,
+		Logger:  log.Default(),
+		Options: opts,
+		Store:   server.NewStore(*store),
+	})
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	httpSrv := &http.Server{Addr: *addr, Handler: srv}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		log.Println("Shutting down...")
+		srv.Close()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		httpSrv.Shutdown(shutdownCtx)
+	}()
+
+	log.Println("Listening on", *addr)
+	if err := httpSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Panicln(err)
+	}
+	<-stopped
+}
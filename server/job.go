@@ -0,0 +1,238 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"hls_downloader/downloader"
+)
+
+// Status is the state of a job.
+type Status string
+
+const (
+	StatusQueued   Status = "queued"
+	StatusRunning  Status = "running"
+	StatusDone     Status = "done"
+	StatusFailed   Status = "failed"
+	StatusCanceled Status = "canceled"
+)
+
+// active reports whether a job with the status is yet to end, or ended by
+// a shutdown to be resumed.
+func (st Status) active() bool {
+	return st == StatusQueued || st == StatusRunning
+}
+
+// JobRequest is the body of POST /jobs.
+type JobRequest struct {
+	URL     string `json:"url"`
+	Variant string `json:"variant,omitempty"` // see downloader.VariantSelector
+	Output  string `json:"output,omitempty"`  // file name in the output directory, <id>.mp4 by default, may be a template
+}
+
+// Job is the public view of a download job.
+type Job struct {
+	ID       string             `json:"id"`
+	URL      string             `json:"url"`
+	Variant  string             `json:"variant,omitempty"`
+	Output   string             `json:"output"`
+	Status   Status             `json:"status"`
+	Error    string             `json:"error,omitempty"`
+	Created  time.Time          `json:"created"`
+	Started  *time.Time         `json:"started,omitempty"`
+	Finished *time.Time         `json:"finished,omitempty"`
+	Progress *downloader.Event  `json:"progress,omitempty"` // latest progress event
+	Result   *downloader.Result `json:"result,omitempty"`
+}
+
+// subscriberBuffer is the number of events a slow SSE client may lag
+// behind before it misses events.
+const subscriberBuffer = 256
+
+// job is a Job with its runtime state.
+type job struct {
+	mu     sync.Mutex
+	Job    Job
+	path   string // output file, expanded by finish when a template
+	ctx    context.Context
+	cancel context.CancelFunc
+	events []downloader.Event // every event but progress, replayed to new subscribers
+	subs   map[chan downloader.Event]struct{}
+	done   chan struct{}
+
+	// persisted state, see record
+	variantURL  string
+	stopped     bool // canceled by the user, never resumed
+	interrupted bool // canceled by a shutdown, resumed on the next start
+	onChange    func()
+}
+
+func newJob(id string, req JobRequest, path string) *job {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &job{
+		Job: Job{
+			ID:      id,
+			URL:     req.URL,
+			Variant: req.Variant,
+			Output:  req.Output,
+			Status:  StatusQueued,
+			Created: time.Now(),
+		},
+		path:     path,
+		ctx:      ctx,
+		cancel:   cancel,
+		subs:     make(map[chan downloader.Event]struct{}),
+		done:     make(chan struct{}),
+		onChange: func() {},
+	}
+}
+
+// restoreJob recreates a job from its record. Jobs that ended are
+// restored as ended, the others are queued again.
+func restoreJob(rec record, path string) *job {
+	j := newJob(rec.ID, JobRequest{URL: rec.URL, Variant: rec.Variant, Output: rec.Output}, path)
+	j.Job.Created = rec.Created
+	j.variantURL = rec.VariantURL
+	if rec.Status.active() {
+		return j
+	}
+	j.Job = rec.Job
+	j.subs = nil
+	close(j.done)
+	j.cancel()
+	return j
+}
+
+// record returns the persisted state of the job. A job interrupted by a
+// shutdown is recorded as queued.
+func (j *job) record() record {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	rec := record{Job: j.Job, VariantURL: j.variantURL}
+	rec.Progress = nil
+	if j.interrupted && rec.Status == StatusCanceled {
+		rec.Status = StatusQueued
+		rec.Started = nil
+		rec.Finished = nil
+	}
+	return rec
+}
+
+// stop cancels the job at the request of the user. It is recorded before
+// the context is canceled so a shutdown racing with the end of the job
+// doesn't take it for interrupted.
+func (j *job) stop() {
+	j.mu.Lock()
+	j.stopped = true
+	j.interrupted = false
+	j.mu.Unlock()
+	j.cancel()
+}
+
+// interrupt cancels the job for a shutdown, unless the user canceled it.
+func (j *job) interrupt() {
+	j.mu.Lock()
+	j.interrupted = j.Job.Finished == nil && !j.stopped
+	j.mu.Unlock()
+	j.cancel()
+}
+
+// output returns the output file of the job.
+func (j *job) output() string {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.path
+}
+
+// snapshot returns a copy of the public view of the job.
+func (j *job) snapshot() Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.Job
+}
+
+func (j *job) start() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	j.Job.Status = StatusRunning
+	j.Job.Started = &now
+}
+
+// publish records ev and forwards it to the subscribers.
+func (j *job) publish(ev downloader.Event) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	switch ev.Type {
+	case downloader.EventProgress:
+		j.Job.Progress = &ev
+	case downloader.EventVariantChosen:
+		j.variantURL = ev.URL
+		j.onChange()
+	}
+	if ev.Type != downloader.EventProgress {
+		j.events = append(j.events, ev)
+	}
+	for ch := range j.subs {
+		select {
+		case ch <- ev:
+		default: // the client is too slow, drop the event
+		}
+	}
+}
+
+// finish records the outcome of the download and closes the subscribers.
+func (j *job) finish(res *downloader.Result, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	j.Job.Finished = &now
+	j.Job.Result = res
+	if res != nil && res.Output != "" {
+		// the template of the request, expanded in the output directory
+		j.path = res.Output
+		j.Job.Output = filepath.Base(res.Output)
+	}
+	switch {
+	case err == nil:
+		j.Job.Status = StatusDone
+	case errors.Is(err, context.Canceled):
+		j.Job.Status = StatusCanceled
+	default:
+		j.Job.Status = StatusFailed
+		j.Job.Error = err.Error()
+	}
+	for ch := range j.subs {
+		close(ch)
+	}
+	j.subs = nil
+	close(j.done)
+	j.cancel()
+}
+
+// subscribe returns the events so far and a channel of the next ones,
+// closed when the job ends. The channel is nil if the job already ended.
+func (j *job) subscribe() ([]downloader.Event, chan downloader.Event) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	history := append([]downloader.Event(nil), j.events...)
+	if j.subs == nil {
+		return history, nil
+	}
+	ch := make(chan downloader.Event, subscriberBuffer)
+	j.subs[ch] = struct{}{}
+	return history, ch
+}
+
+func (j *job) unsubscribe(ch chan downloader.Event) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, ok := j.subs[ch]; ok {
+		delete(j.subs, ch)
+		close(ch)
+	}
+}
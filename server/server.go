@@ -0,0 +1,451 @@
+// Package server runs downloads as jobs behind a REST API:
+//
+//	POST   /jobs             submit a JobRequest
+//	GET    /jobs             list the jobs
+//	GET    /jobs/{id}        get a job
+//	DELETE /jobs/{id}        cancel a job
+//	GET    /jobs/{id}/events stream the job events (Server-Sent Events)
+//	GET    /jobs/{id}/file   fetch the output of a finished job
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"hls_downloader/downloader"
+)
+
+// Config configures a Server.
+type Config struct {
+	// Dir is where the outputs of the jobs are written.
+	Dir string
+	// MaxJobs is the number of jobs running at once, the others wait in
+	// the queue.
+	MaxJobs int
+	// Slots is the number of segments downloaded at once across all jobs.
+	Slots int
+	// Logger receives the logs of the server and of the jobs, prefixed
+	// with the job id. They are discarded when nil.
+	Logger *log.Logger
+	// Options are the base downloader options of every job.
+	Options downloader.Options
+	// Store persists the jobs. When set, the segments of a job are kept in
+	// Dir/.work/<id> until it ends, and New resumes the jobs that were
+	// queued or running.
+	Store *Store
+}
+
+// saveInterval throttles the writes of the store while jobs progress.
+const saveInterval = time.Second
+
+// Server runs download jobs, it implements http.Handler.
+type Server struct {
+	cfg     Config
+	log     *log.Logger
+	running chan struct{}
+	slots   chan struct{}
+
+	mu    sync.Mutex
+	jobs  map[string]*job
+	list  []*job      // in submission order
+	dirty atomic.Bool // the store is behind, set while holding job locks
+
+	stop  chan struct{}
+	saved chan struct{}
+}
+
+// New creates a Server, creating the output directory if needed.
+func New(cfg Config) (*Server, error) {
+	if cfg.MaxJobs <= 0 {
+		cfg.MaxJobs = 1
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &Server{
+		cfg:     cfg,
+		log:     cfg.Logger,
+		running: make(chan struct{}, cfg.MaxJobs),
+		jobs:    make(map[string]*job),
+	}
+	if s.log == nil {
+		s.log = log.New(io.Discard, "", 0)
+	}
+	if cfg.Slots > 0 {
+		s.slots = make(chan struct{}, cfg.Slots)
+	}
+	if cfg.Store != nil {
+		if err := s.restore(); err != nil {
+			return nil, err
+		}
+		s.stop = make(chan struct{})
+		s.saved = make(chan struct{})
+		go s.saveLoop()
+	}
+	return s, nil
+}
+
+// restore loads the jobs of the store and queues the unfinished ones.
+func (s *Server) restore() error {
+	records, err := s.cfg.Store.load()
+	if err != nil {
+		return fmt.Errorf("load jobs: %w", err)
+	}
+	for _, rec := range records {
+		j := restoreJob(rec, filepath.Join(s.cfg.Dir, rec.Output))
+		j.onChange = s.changed
+		s.jobs[j.Job.ID] = j
+		s.list = append(s.list, j)
+		if j.snapshot().Status != StatusQueued {
+			continue
+		}
+		selectVariant, err := s.resumeSelector(rec)
+		if err != nil {
+			j.finish(nil, err)
+			continue
+		}
+		s.log.Printf("Job %s resumed: %s\n", j.Job.ID, j.Job.URL)
+		go s.run(j, selectVariant)
+	}
+	return nil
+}
+
+// resumeSelector picks the variant the job chose before the restart so
+// its segments are reused, or follows the request if none was chosen.
+func (s *Server) resumeSelector(rec record) (func([]*downloader.Variant) (int, error), error) {
+	if rec.VariantURL == "" {
+		return downloader.VariantSelector(rec.Variant)
+	}
+	master, err := url.Parse(rec.Job.URL)
+	if err != nil {
+		return nil, err
+	}
+	return func(variants []*downloader.Variant) (int, error) {
+		for i, v := range variants {
+			// the variant URIs are relative to the master playlist
+			if downloader.ResolveURL(master, v.URI).String() == rec.VariantURL {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("variant %s is gone from the playlist", rec.VariantURL)
+	}, nil
+}
+
+// changed marks the store as behind, it is written by saveLoop.
+func (s *Server) changed() {
+	s.dirty.Store(true)
+}
+
+func (s *Server) saveLoop() {
+	defer close(s.saved)
+	ticker := time.NewTicker(saveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if s.dirty.Load() {
+				s.save()
+			}
+		case <-s.stop:
+			s.save()
+			return
+		}
+	}
+}
+
+// save writes every job to the store.
+func (s *Server) save() {
+	if s.cfg.Store == nil {
+		return
+	}
+	s.mu.Lock()
+	list := append([]*job(nil), s.list...)
+	s.mu.Unlock()
+	s.dirty.Store(false)
+	records := make([]record, len(list))
+	for i, j := range list {
+		records[i] = j.record()
+	}
+	if err := s.cfg.Store.save(records); err != nil {
+		s.log.Println("Save jobs failed:", err)
+	}
+}
+
+var (
+	ErrNotFound = errors.New("job not found")
+	ErrConflict = errors.New("output already exists")
+)
+
+// Submit queues a new job.
+func (s *Server) Submit(req JobRequest) (*Job, error) {
+	if u, err := url.Parse(req.URL); err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid url %q", req.URL)
+	}
+	selectVariant, err := downloader.VariantSelector(req.Variant)
+	if err != nil {
+		return nil, err
+	}
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	if req.Output == "" {
+		req.Output = id + ".mp4"
+	}
+	req.Output = filepath.Base(req.Output)
+	if !strings.HasSuffix(req.Output, ".mp4") {
+		return nil, errors.New("output must be a mp4 file")
+	}
+	path := filepath.Join(s.cfg.Dir, req.Output)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// a template is only known once expanded, the downloader refuses to
+	// replace an existing file then
+	if !downloader.IsTemplate(path) {
+		if _, err := os.Stat(path); err == nil {
+			return nil, ErrConflict
+		}
+		for _, other := range s.list {
+			if other.output() == path {
+				return nil, ErrConflict
+			}
+		}
+	}
+	j := newJob(id, req, path)
+	j.onChange = s.changed
+	s.jobs[id] = j
+	s.list = append(s.list, j)
+	s.dirty.Store(true)
+	s.log.Printf("Job %s queued: %s\n", id, req.URL)
+	go s.run(j, selectVariant)
+	snap := j.snapshot()
+	return &snap, nil
+}
+
+func (s *Server) run(j *job, selectVariant func([]*downloader.Variant) (int, error)) {
+	// wait for a free job slot
+	select {
+	case s.running <- struct{}{}:
+		defer func() { <-s.running }()
+	case <-j.ctx.Done():
+		j.finish(nil, j.ctx.Err())
+		return
+	}
+	j.start()
+
+	opts := s.cfg.Options
+	opts.Logger = log.New(s.log.Writer(), fmt.Sprintf("[%s] ", j.Job.ID), s.log.Flags())
+	opts.SelectVariant = selectVariant
+	opts.OnEvent = j.publish
+	opts.Slots = s.slots
+	if s.cfg.Store != nil {
+		opts.WorkDir = filepath.Join(s.cfg.Dir, ".work", j.Job.ID)
+	}
+	res, err := downloader.New(opts).Download(j.ctx, j.Job.URL, j.output())
+	j.finish(res, err)
+	if err != nil {
+		s.log.Printf("Job %s ended: %s\n", j.Job.ID, err)
+	} else {
+		s.log.Printf("Job %s done: %s\n", j.Job.ID, j.output())
+	}
+	if opts.WorkDir != "" && !j.record().Status.active() {
+		os.RemoveAll(opts.WorkDir)
+	}
+	s.changed()
+}
+
+// Jobs lists the jobs in submission order.
+func (s *Server) Jobs() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	jobs := make([]Job, len(s.list))
+	for i, j := range s.list {
+		jobs[i] = j.snapshot()
+	}
+	return jobs
+}
+
+func (s *Server) job(id string) (*job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return j, nil
+}
+
+// Cancel stops a queued or running job.
+func (s *Server) Cancel(id string) error {
+	j, err := s.job(id)
+	if err != nil {
+		return err
+	}
+	j.stop()
+	return nil
+}
+
+// Close cancels every job and waits for them to end. With a Store, the
+// queued and running jobs are recorded to be resumed by the next Server.
+func (s *Server) Close() {
+	s.mu.Lock()
+	list := append([]*job(nil), s.list...)
+	s.mu.Unlock()
+	for _, j := range list {
+		j.interrupt()
+	}
+	for _, j := range list {
+		<-j.done
+	}
+	if s.stop != nil {
+		close(s.stop)
+		<-s.saved
+	}
+}
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	parts := strings.Split(path, "/")
+	if parts[0] != "jobs" || len(parts) > 3 {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, s.Jobs())
+	case len(parts) == 1 && r.Method == http.MethodPost:
+		s.handleSubmit(w, r)
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		j, err := s.job(parts[1])
+		if err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, j.snapshot())
+	case len(parts) == 2 && r.Method == http.MethodDelete:
+		if err := s.Cancel(parts[1]); err != nil {
+			writeError(w, http.StatusNotFound, err)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	case len(parts) == 3 && parts[2] == "events" && r.Method == http.MethodGet:
+		s.handleEvents(w, r, parts[1])
+	case len(parts) == 3 && parts[2] == "file" && r.Method == http.MethodGet:
+		s.handleFile(w, r, parts[1])
+	default:
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+	}
+}
+
+func (s *Server) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	var req JobRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	j, err := s.Submit(req)
+	if errors.Is(err, ErrConflict) {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, j)
+}
+
+// handleEvents streams the events of the job, starting with the past
+// ones, and ends with a "job" event holding the final state of the job.
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request, id string) {
+	j, err := s.job(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming unsupported"))
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	history, ch := j.subscribe()
+	for _, ev := range history {
+		writeEvent(w, string(ev.Type), ev)
+	}
+	flusher.Flush()
+	if ch != nil {
+		defer j.unsubscribe(ch)
+	loop:
+		for {
+			select {
+			case ev, ok := <-ch:
+				if !ok {
+					break loop
+				}
+				writeEvent(w, string(ev.Type), ev)
+				flusher.Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}
+	writeEvent(w, "job", j.snapshot())
+	flusher.Flush()
+}
+
+func (s *Server) handleFile(w http.ResponseWriter, r *http.Request, id string) {
+	j, err := s.job(id)
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if snap := j.snapshot(); snap.Status != StatusDone {
+		writeError(w, http.StatusConflict, fmt.Errorf("job is %s", snap.Status))
+		return
+	}
+	path := j.output()
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(path)))
+	http.ServeFile(w, r, path)
+}
+
+func writeEvent(w io.Writer, name string, v interface{}) {
+	data, _ := json.Marshal(v)
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,427 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/grafov/m3u8"
+
+	"hls_downloader/downloader"
+)
+
+func TestResumeSelector(t *testing.T) {
+	variants := []*downloader.Variant{
+		{Variant: &m3u8.Variant{URI: "index.m3u8"}},
+		{Variant: &m3u8.Variant{URI: "low/index.m3u8"}},
+		{Variant: &m3u8.Variant{URI: "https://cdn.example.com/hd/index.m3u8"}},
+	}
+	s := &Server{}
+	for _, tc := range []struct {
+		variantURL string
+		want       int
+	}{
+		{"https://example.com/live/index.m3u8", 0},
+		{"https://example.com/live/low/index.m3u8", 1},
+		{"https://cdn.example.com/hd/index.m3u8", 2},
+		{"https://example.com/live/mid/index.m3u8", -1},
+	} {
+		rec := record{Job: Job{URL: "https://example.com/live/master.m3u8"}, VariantURL: tc.variantURL}
+		selectVariant, err := s.resumeSelector(rec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := selectVariant(variants)
+		if tc.want < 0 {
+			if err == nil {
+				t.Errorf("%s: selected variant %d, want an error", tc.variantURL, got)
+			}
+			continue
+		}
+		if err != nil || got != tc.want {
+			t.Errorf("%s: selected variant %d (%v), want %d", tc.variantURL, got, err, tc.want)
+		}
+	}
+}
+
+// origin serves a master playlist with a single variant of 3 segments.
+// The segments wait for gate, when it isn't nil, to be released.
+type origin struct {
+	gate chan struct{}
+	once sync.Once
+}
+
+func (o *origin) release() {
+	o.once.Do(func() {
+		if o.gate != nil {
+			close(o.gate)
+		}
+	})
+}
+
+const originSegments = 3
+
+func (o *origin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/master.m3u8":
+		fmt.Fprint(w, "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=8000,RESOLUTION=640x360,NAME=\"talk\"\nmedia.m3u8\n")
+	case r.URL.Path == "/media.m3u8":
+		fmt.Fprint(w, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:1\n")
+		for i := 0; i < originSegments; i++ {
+			fmt.Fprintf(w, "#EXTINF:1.0,\ns%d.ts\n", i)
+		}
+		fmt.Fprint(w, "#EXT-X-ENDLIST\n")
+	case strings.HasPrefix(r.URL.Path, "/s"):
+		if o.gate != nil {
+			select {
+			case <-o.gate:
+			case <-r.Context().Done():
+				return
+			}
+		}
+		fmt.Fprintf(w, "segment %s", strings.TrimSuffix(r.URL.Path[2:], ".ts"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// concatMuxer writes the segments one after the other.
+type concatMuxer struct{}
+
+func (concatMuxer) Mux(ctx context.Context, input *downloader.MuxInput) error {
+	var data []byte
+	for _, name := range input.Segments {
+		b, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		data = append(data, b...)
+	}
+	return os.WriteFile(input.Output, data, 0644)
+}
+
+// newTestServer returns a Server downloading from o, closed with the test.
+func newTestServer(t *testing.T, o *origin, maxJobs int) (*Server, string) {
+	t.Helper()
+	srv := httptest.NewServer(o)
+	s, err := New(Config{
+		Dir:     t.TempDir(),
+		MaxJobs: maxJobs,
+		Options: downloader.Options{Muxer: concatMuxer{}, TempDir: t.TempDir()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		o.release()
+		s.Close()
+		srv.Close()
+	})
+	return s, srv.URL + "/master.m3u8"
+}
+
+func do(s *Server, method, path, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return w
+}
+
+// submit posts req and returns the created job.
+func submit(t *testing.T, s *Server, req JobRequest) Job {
+	t.Helper()
+	body, _ := json.Marshal(req)
+	w := do(s, http.MethodPost, "/jobs", string(body))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("submit %+v: status %d: %s", req, w.Code, w.Body)
+	}
+	var j Job
+	if err := json.NewDecoder(w.Body).Decode(&j); err != nil {
+		t.Fatal(err)
+	}
+	return j
+}
+
+// waitStatus waits for the job to reach the status.
+func waitStatus(t *testing.T, s *Server, id string, status Status) Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		j, err := s.job(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		snap := j.snapshot()
+		if snap.Status == status {
+			return snap
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job %s is %s (%s), want %s", id, snap.Status, snap.Error, status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSubmitValidation(t *testing.T) {
+	s, master := newTestServer(t, &origin{gate: make(chan struct{})}, 1)
+	if err := os.WriteFile(filepath.Join(s.cfg.Dir, "exists.mp4"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	submit(t, s, JobRequest{URL: master, Output: "queued.mp4"})
+
+	for _, tc := range []struct {
+		name string
+		body string
+		code int
+	}{
+		{"invalid json", `{"url":`, http.StatusBadRequest},
+		{"invalid url", `{"url":"not a url"}`, http.StatusBadRequest},
+		{"relative url", `{"url":"/master.m3u8"}`, http.StatusBadRequest},
+		{"invalid variant", `{"url":"` + master + `","variant":"-1"}`, http.StatusBadRequest},
+		{"not mp4", `{"url":"` + master + `","output":"talk.mkv"}`, http.StatusBadRequest},
+		{"existing file", `{"url":"` + master + `","output":"exists.mp4"}`, http.StatusConflict},
+		{"output of another job", `{"url":"` + master + `","output":"queued.mp4"}`, http.StatusConflict},
+		// only the file name is kept
+		{"directory", `{"url":"` + master + `","output":"../../queued.mp4"}`, http.StatusConflict},
+	} {
+		w := do(s, http.MethodPost, "/jobs", tc.body)
+		if w.Code != tc.code {
+			t.Errorf("%s: got status %d (%s), want %d", tc.name, w.Code, strings.TrimSpace(w.Body.String()), tc.code)
+		}
+	}
+	if jobs := s.Jobs(); len(jobs) != 1 {
+		t.Errorf("got %d jobs, want 1", len(jobs))
+	}
+
+	// the default output is named after the job
+	j := submit(t, s, JobRequest{URL: master})
+	if j.Output != j.ID+".mp4" || j.Status != StatusQueued {
+		t.Errorf("got output %s, status %s", j.Output, j.Status)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	s, master := newTestServer(t, &origin{gate: make(chan struct{})}, 1)
+	running := submit(t, s, JobRequest{URL: master, Output: "running.mp4"})
+	waitStatus(t, s, running.ID, StatusRunning)
+	queued := submit(t, s, JobRequest{URL: master, Output: "queued.mp4"})
+
+	for _, id := range []string{queued.ID, running.ID} {
+		if w := do(s, http.MethodDelete, "/jobs/"+id, ""); w.Code != http.StatusAccepted {
+			t.Errorf("cancel %s: got status %d", id, w.Code)
+		}
+	}
+	if got := waitStatus(t, s, queued.ID, StatusCanceled); got.Started != nil {
+		t.Error("the queued job started")
+	}
+	waitStatus(t, s, running.ID, StatusCanceled)
+	if _, err := os.Stat(filepath.Join(s.cfg.Dir, "running.mp4")); err == nil {
+		t.Error("canceled job wrote its output")
+	}
+
+	if w := do(s, http.MethodDelete, "/jobs/missing", ""); w.Code != http.StatusNotFound {
+		t.Errorf("cancel a missing job: got status %d", w.Code)
+	}
+}
+
+// readEvents reads the SSE stream of the job up to the final job event.
+func readEvents(t *testing.T, url string) (names []string, last Job) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("got content type %s", ct)
+	}
+	sc := bufio.NewScanner(resp.Body)
+	name := ""
+	for sc.Scan() {
+		line := sc.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+			names = append(names, name)
+		case strings.HasPrefix(line, "data: ") && name == "job":
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &last); err != nil {
+				t.Fatal(err)
+			}
+			return names, last
+		}
+	}
+	t.Fatalf("stream ended without the job event: %v", names)
+	return
+}
+
+func TestEvents(t *testing.T) {
+	o := &origin{gate: make(chan struct{})}
+	s, master := newTestServer(t, o, 1)
+	api := httptest.NewServer(s)
+	defer api.Close()
+	j := submit(t, s, JobRequest{URL: master})
+	waitStatus(t, s, j.ID, StatusRunning)
+
+	// the segments are let through once the client listens
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		o.release()
+	}()
+	names, last := readEvents(t, api.URL+"/jobs/"+j.ID+"/events")
+	if last.Status != StatusDone || last.Result == nil || last.Result.Downloaded != originSegments {
+		t.Errorf("got final job %+v", last)
+	}
+	count := make(map[string]int)
+	for _, name := range names {
+		count[name]++
+	}
+	for name, want := range map[string]int{
+		"playlist_fetched": 2,
+		"variant_chosen":   1,
+		"segment_started":  originSegments,
+		"segment_finished": originSegments,
+		"done":             1,
+		"job":              1,
+	} {
+		if count[name] != want {
+			t.Errorf("got %d %s events, want %d: %v", count[name], name, want, names)
+		}
+	}
+	if names[0] != "playlist_fetched" || names[len(names)-1] != "job" {
+		t.Errorf("got events %v", names)
+	}
+
+	// a client coming after the end gets the past events
+	again, _ := readEvents(t, api.URL+"/jobs/"+j.ID+"/events")
+	if len(again) != len(names)-count["progress"] {
+		t.Errorf("got %d events after the end, want %d", len(again), len(names)-count["progress"])
+	}
+
+	if resp, err := http.Get(api.URL + "/jobs/missing/events"); err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Errorf("events of a missing job: %v %v", resp.StatusCode, err)
+	}
+}
+
+func TestFile(t *testing.T) {
+	s, master := newTestServer(t, &origin{}, 1)
+	j := submit(t, s, JobRequest{URL: master, Output: "talk.mp4"})
+	waitStatus(t, s, j.ID, StatusDone)
+
+	w := do(s, http.MethodGet, "/jobs/"+j.ID+"/file", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", w.Code, w.Body)
+	}
+	if got, want := w.Body.String(), "segment 0segment 1segment 2"; got != want {
+		t.Errorf("got file %q, want %q", got, want)
+	}
+	if got := w.Header().Get("Content-Disposition"); got != `attachment; filename="talk.mp4"` {
+		t.Errorf("got Content-Disposition %s", got)
+	}
+
+	if w := do(s, http.MethodGet, "/jobs/missing/file", ""); w.Code != http.StatusNotFound {
+		t.Errorf("file of a missing job: got status %d", w.Code)
+	}
+}
+
+func TestFileUnfinished(t *testing.T) {
+	s, master := newTestServer(t, &origin{gate: make(chan struct{})}, 1)
+	j := submit(t, s, JobRequest{URL: master})
+	if w := do(s, http.MethodGet, "/jobs/"+j.ID+"/file", ""); w.Code != http.StatusConflict {
+		t.Errorf("file of a running job: got status %d", w.Code)
+	}
+}
+
+func TestInterrupt(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		stop bool // canceled by the user before the shutdown
+		want Status
+	}{
+		{"shutdown", false, StatusQueued},
+		{"canceled before the shutdown", true, StatusCanceled},
+	} {
+		j := newJob("id", JobRequest{URL: "https://example.com/master.m3u8"}, "out.mp4")
+		j.start()
+		if tc.stop {
+			j.stop()
+		}
+		// the shutdown comes before the job records its end
+		j.interrupt()
+		j.finish(nil, context.Canceled)
+		if got := j.record().Status; got != tc.want {
+			t.Errorf("%s: recorded %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestResume(t *testing.T) {
+	o := &origin{gate: make(chan struct{})}
+	srv := httptest.NewServer(o)
+	defer srv.Close()
+	defer o.release()
+	dir := t.TempDir()
+	cfg := Config{
+		Dir:     dir,
+		Store:   NewStore(filepath.Join(dir, "jobs.json")),
+		Options: downloader.Options{Muxer: concatMuxer{}},
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	interrupted := submit(t, s, JobRequest{URL: srv.URL + "/master.m3u8", Output: "interrupted.mp4"})
+	waitStatus(t, s, interrupted.ID, StatusRunning)
+	canceled := submit(t, s, JobRequest{URL: srv.URL + "/master.m3u8", Output: "canceled.mp4"})
+	if err := s.Cancel(canceled.ID); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	o.release()
+	s, err = New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	waitStatus(t, s, interrupted.ID, StatusDone)
+	if got := waitStatus(t, s, canceled.ID, StatusCanceled); got.Started != nil {
+		t.Error("the canceled job was resumed")
+	}
+	if _, err := os.Stat(filepath.Join(dir, ".work", interrupted.ID)); !os.IsNotExist(err) {
+		t.Errorf("the work directory of the job is left: %v", err)
+	}
+}
+
+func TestTemplateOutput(t *testing.T) {
+	s, master := newTestServer(t, &origin{}, 1)
+	j := submit(t, s, JobRequest{URL: master, Output: "{name}.mp4"})
+	done := waitStatus(t, s, j.ID, StatusDone)
+	if done.Output != "talk.mp4" {
+		t.Errorf("got output %s, want talk.mp4", done.Output)
+	}
+
+	w := do(s, http.MethodGet, "/jobs/"+j.ID+"/file", "")
+	if w.Code != http.StatusOK || w.Body.String() != "segment 0segment 1segment 2" {
+		t.Errorf("got status %d, file %q", w.Code, w.Body)
+	}
+	if got := w.Header().Get("Content-Disposition"); got != `attachment; filename="talk.mp4"` {
+		t.Errorf("got Content-Disposition %s", got)
+	}
+
+	// the expanded output conflicts with the next jobs
+	if w := do(s, http.MethodPost, "/jobs", `{"url":"`+master+`","output":"talk.mp4"}`); w.Code != http.StatusConflict {
+		t.Errorf("same output as the expanded template: got status %d", w.Code)
+	}
+	again := submit(t, s, JobRequest{URL: master, Output: "{name}.mp4"})
+	if failed := waitStatus(t, s, again.ID, StatusFailed); !strings.Contains(failed.Error, "exists") {
+		t.Errorf("template expanded to an existing file: got error %q", failed.Error)
+	}
+}
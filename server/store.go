@@ -0,0 +1,59 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// record is the persisted state of a job.
+type record struct {
+	Job
+	// VariantURL is the variant playlist chosen by the download, a resumed
+	// job picks the same variant to reuse the downloaded segments.
+	VariantURL string `json:"variant_url,omitempty"`
+}
+
+// Store keeps the jobs in a JSON file so they survive a restart.
+type Store struct {
+	path string
+}
+
+// NewStore returns a Store writing to path.
+func NewStore(path string) *Store {
+	return &Store{path: path}
+}
+
+// load reads the records, a missing file holds no records.
+func (st *Store) load() ([]record, error) {
+	data, err := os.ReadFile(st.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var records []record
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// save replaces the records. The file is written aside and renamed so a
+// crash never leaves it half written.
+func (st *Store) save(records []record) error {
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(st.path), 0755); err != nil {
+		return err
+	}
+	tmp := st.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, st.path)
+}
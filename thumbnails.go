@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"hls_downloader/downloader"
+)
+
+// thumbnails extracts thumbnails of a stream from its I-frame playlist.
+func thumbnails(args []string) {
+	fs := newFlagSet("thumbnails", "[flags] <url>")
+	var (
+		client clientFlags
+		u, dir string
+		opts   downloader.ThumbnailOptions
+	)
+	client.register(fs)
+	fs.StringVar(&u, "url", "", "Master or I-frame playlist url, may be given as argument")
+	fs.StringVar(&dir, "o", "thumbnails", "Directory the thumbnails, sprite sheets and "+downloader.ThumbnailTrack+" are written to")
+	fs.DurationVar(&opts.Interval, "interval", 10*time.Second, "Time between thumbnails")
+	fs.IntVar(&opts.Width, "width", 160, "Width of the thumbnails in pixels, the I-frame variant is the smallest at least as wide")
+	fs.IntVar(&opts.Columns, "columns", 5, "Thumbnails per row of a sprite sheet")
+	fs.IntVar(&opts.Rows, "rows", 5, "Rows of a sprite sheet")
+	fs.Parse(args)
+	u = playlistURL(fs, u)
+	checkFFmpeg()
+
+	cfg, err := client.load(fs)
+	if err != nil {
+		log.Panicln(err)
+	}
+	dlOpts, err := cfg.options()
+	if err != nil {
+		log.Panicln(err)
+	}
+	dlOpts.Logger = log.Default()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	res, err := downloader.New(dlOpts).Thumbnails(ctx, u, dir, opts)
+	if err != nil {
+		log.Panicln(err)
+	}
+	log.Printf("Done!, %d I-frames (%s), track: %s\n", res.Downloaded, downloader.HumanBytes(res.Bytes), res.Output)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// verify checks that a downloaded file is readable and as long as its
+// playlist, it exits with status 1 when it isn't.
+func verify(args []string) {
+	fs := newFlagSet("verify", "[flags] <url> <file>")
+	var (
+		client       clientFlags
+		u, file      string
+		variantRules string
+		tolerance    time.Duration
+	)
+	client.register(fs)
+	fs.StringVar(&u, "url", "", "Master or media playlist url, may be given as first argument")
+	fs.StringVar(&file, "file", "", "Downloaded file, may be given as last argument")
+	fs.StringVar(&variantRules, "variant", "", "Variant of a master playlist: highest, lowest, index, 1280x720, 720p or name (comma separated)")
+	fs.DurationVar(&tolerance, "tolerance", 0, "Accepted duration difference (default: the target duration of the playlist)")
+	fs.Parse(args)
+	u = playlistURL(fs, u)
+	if file == "" {
+		file = fs.Arg(fs.NArg() - 1)
+	}
+	if file == "" || file == u {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	cfg, err := client.load(fs)
+	if err != nil {
+		log.Panicln(err)
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "variant" {
+			cfg.Variant = splitList(variantRules)
+		}
+	})
+	opts, err := cfg.options()
+	if err != nil {
+		log.Panicln(err)
+	}
+	if _, err := os.Stat(file); err != nil {
+		log.Panicln(err)
+	}
+	media, err := fetchMedia(context.Background(), opts, u)
+	if err != nil {
+		log.Panicln(err)
+	}
+
+	expected := media.duration()
+	actual, err := probeDuration(file)
+	if err != nil {
+		fmt.Printf("FAIL %s: %s\n", file, err)
+		os.Exit(1)
+	}
+	allowed := tolerance.Seconds()
+	if allowed == 0 {
+		allowed = media.TargetDuration
+	}
+	diff := math.Abs(actual - expected)
+	if diff > allowed {
+		fmt.Printf("FAIL %s: duration %.3fs, playlist %.3fs (%d segments), off by %.3fs\n", file, actual, expected, len(media.segments()), diff)
+		os.Exit(1)
+	}
+	fmt.Printf("OK %s: duration %.3fs, playlist %.3fs (%d segments)\n", file, actual, expected, len(media.segments()))
+}
+
+// probeDuration returns the duration of a media file in seconds with
+// ffprobe, failing when the file can't be read.
+func probeDuration(file string) (float64, error) {
+	output, err := exec.Command("ffprobe", "-v", "error", "-show_entries", "format=duration", "-of", "default=noprint_wrappers=1:nokey=1", file).CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
+}